@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"github.com/aquasecurity/btfhub/pkg/job"
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+var btfgenCmd = &command{
+	name:  "btfgen",
+	short: "generate BTF files tailored to the given BPF objects (bpftool gen min_core_btf)",
+	flags: func(fs *flag.FlagSet) {
+		fs.Var(&btfgenObjects, "o", "BPF object to tailor the BTF files to (can be repeated)")
+		fs.StringVar(&btfgenOutput, "output", "custom-archive", "directory to write the tailored BTF files to")
+	},
+	run: runBTFGen,
+}
+
+var btfgenObjects stringList
+var btfgenOutput string
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func runBTFGen(ctx context.Context, _ []string) error {
+	if len(btfgenObjects) == 0 {
+		return errors.New("at least one BPF object (-o) is required")
+	}
+
+	dirs, err := archiveDirs()
+	if err != nil {
+		return err
+	}
+
+	objects := make([]string, 0, len(btfgenObjects))
+	for _, o := range btfgenObjects {
+		abs, err := filepath.Abs(o)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, abs)
+	}
+
+	jobChan, consume := startWorkers(ctx)
+
+	var sendErr error
+send:
+	for _, d := range dirs {
+		entries, err := d.Entries()
+		if err != nil {
+			sendErr = err
+			break
+		}
+		for i, e := range entries {
+			if i > 0 && e.Kernel == entries[i-1].Kernel {
+				continue // the same BTF file, in another output format
+			}
+			j := &job.MinCoreBTFJob{
+				ArchivePath: e.Path,
+				OutPath:     filepath.Join(btfgenOutput, d.Distro, d.Release, d.Arch, e.Kernel+".btf"),
+				Objects:     objects,
+				Runner:      utils.LocalRunner{},
+			}
+			select {
+			case <-ctx.Done():
+				sendErr = ctx.Err()
+				break send
+			case jobChan <- j:
+			}
+		}
+	}
+
+	close(jobChan)
+	if err := consume.Wait(); err != nil {
+		return err
+	}
+
+	return sendErr
+}
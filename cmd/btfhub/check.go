@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aquasecurity/btfhub/pkg/corecheck"
+	"github.com/aquasecurity/btfhub/pkg/job"
+)
+
+var checkObjectCmd = &command{
+	name:  "check-object",
+	short: "find the archived kernels a BPF object has unresolved CO-RE relocations on",
+	flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&checkObject, "o", "", "BPF object to check")
+		fs.BoolVar(&checkJSON, "json", false, "print the results as JSON")
+	},
+	run: runCheckObject,
+}
+
+var checkObject string
+var checkJSON bool
+
+// checkKernel is the outcome of the check of a kernel (the relocations are
+// indexes in the object relocations)
+type checkKernel struct {
+	Kernel     string `json:"kernel"` // distro/release/arch/kernel
+	Unresolved []int  `json:"unresolved"`
+	Error      string `json:"error,omitempty"`
+}
+
+func runCheckObject(ctx context.Context, _ []string) error {
+	if checkObject == "" {
+		return errors.New("a BPF object (-o) is required")
+	}
+
+	obj, err := corecheck.Load(checkObject)
+	if err != nil {
+		return err
+	}
+	object, err := filepath.Abs(checkObject)
+	if err != nil {
+		return err
+	}
+
+	dirs, err := archiveDirs()
+	if err != nil {
+		return err
+	}
+
+	// Collect the replies while the jobs are sent
+
+	replies := make(chan interface{})
+	var results []*job.CORECheckResult
+	collected := make(chan struct{})
+
+	go func() {
+		for reply := range replies {
+			if r, ok := reply.(*job.CORECheckResult); ok {
+				results = append(results, r)
+			}
+		}
+		close(collected)
+	}()
+
+	jobChan, consume := startWorkers(ctx)
+
+	var sendErr error
+send:
+	for _, d := range dirs {
+		entries, err := d.Entries()
+		if err != nil {
+			sendErr = err
+			break
+		}
+		for _, e := range entries {
+			j := &job.CORECheckJob{
+				Entry:     e,
+				Object:    object,
+				ReplyChan: replies,
+			}
+			select {
+			case <-ctx.Done():
+				sendErr = ctx.Err()
+				break send
+			case jobChan <- j:
+			}
+		}
+	}
+
+	close(jobChan)
+	err = consume.Wait()
+	close(replies)
+	<-collected
+
+	if err != nil {
+		return err
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+
+	// Kernels x unresolved relocations
+
+	var kernels []*checkKernel
+	for _, r := range results {
+		k := &checkKernel{
+			Kernel:     fmt.Sprintf("%s/%s", r.Entry.Dir, r.Entry.Kernel),
+			Unresolved: []int{},
+		}
+		if r.Err != nil {
+			k.Error = r.Err.Error()
+		}
+		for _, u := range r.Unresolved {
+			k.Unresolved = append(k.Unresolved, relocationIndex(obj, u))
+		}
+		sort.Ints(k.Unresolved)
+		kernels = append(kernels, k)
+	}
+	sort.Slice(kernels, func(i, j int) bool {
+		return kernels[i].Kernel < kernels[j].Kernel
+	})
+
+	var failed int
+	for _, k := range kernels {
+		if len(k.Unresolved) > 0 || k.Error != "" {
+			failed++
+		}
+	}
+
+	if checkJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(struct {
+			Object      string                  `json:"object"`
+			Relocations []*corecheck.Relocation `json:"relocations"`
+			Kernels     []*checkKernel          `json:"kernels"`
+		}{checkObject, obj.Relocations, kernels})
+	} else {
+		err = writeCheckMatrix(obj, kernels)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("INFO: checked %d relocations on %d kernels, %d with unresolved relocations\n", len(obj.Relocations), len(kernels), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d kernels have unresolved relocations", failed)
+	}
+
+	return nil
+}
+
+// relocationIndex returns the index, in the object relocations, of a
+// relocation of another load of the same object
+func relocationIndex(obj *corecheck.Object, r *corecheck.Relocation) int {
+	for i, or := range obj.Relocations {
+		if or.Desc == r.Desc {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeCheckMatrix prints the kernels with unresolved relocations, with a
+// column per relocation unresolved on any of them
+func writeCheckMatrix(obj *corecheck.Object, kernels []*checkKernel) error {
+	var columns []int
+	seen := map[int]bool{}
+	for _, k := range kernels {
+		for _, i := range k.Unresolved {
+			if !seen[i] {
+				seen[i] = true
+				columns = append(columns, i)
+			}
+		}
+	}
+	sort.Ints(columns)
+
+	for _, i := range columns {
+		fmt.Printf("R%d\t%s\n", i, obj.Relocations[i])
+	}
+	if len(columns) > 0 {
+		fmt.Println()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{"KERNEL"}
+	for _, i := range columns {
+		header = append(header, fmt.Sprintf("R%d", i))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, k := range kernels {
+		if k.Error != "" {
+			fmt.Fprintf(tw, "%s\terror: %s\n", k.Kernel, k.Error)
+			continue
+		}
+		if len(k.Unresolved) == 0 {
+			continue
+		}
+		row := []string{k.Kernel}
+		for _, i := range columns {
+			mark := "-"
+			if slices.Contains(k.Unresolved, i) {
+				mark = "x"
+			}
+			row = append(row, mark)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
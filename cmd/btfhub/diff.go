@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/btfdiff"
+	"github.com/aquasecurity/btfhub/pkg/corecheck"
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+var diffCmd = &command{
+	name:  "diff",
+	args:  "<btfA> <btfB>",
+	short: "show the type changes between two kernels (BTF files or lookup keys)",
+	flags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&diffJSON, "json", false, "print the differences as JSON")
+		fs.StringVar(&diffTypes, "types", "", "only compare the given types (comma separated names, or BPF objects to take the types of the CO-RE relocations from)")
+	},
+	run: runDiff,
+}
+
+var diffJSON bool
+var diffTypes string
+
+func runDiff(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("diff takes two BTF files or lookup keys")
+	}
+
+	var opts btfdiff.Options
+	if diffTypes != "" {
+		types, err := diffTypeNames(diffTypes)
+		if err != nil {
+			return err
+		}
+		opts.Types = types
+	}
+
+	pathA, specA, err := loadDiffSpec(args[0])
+	if err != nil {
+		return err
+	}
+	pathB, specB, err := loadDiffSpec(args[1])
+	if err != nil {
+		return err
+	}
+
+	d, err := btfdiff.Compare(specA, specB, opts)
+	if err != nil {
+		return err
+	}
+	d.A, d.B = pathA, pathB
+
+	if diffJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	return d.WriteText(os.Stdout)
+}
+
+// loadDiffSpec loads the BTF of a diff argument: a file (.btf.tar.xz, .oci,
+// .btf.zst, raw BTF or vmlinux), or a lookup key of the archive (a kernel
+// release, or distro/release/arch/kernel). It returns the path of the file.
+func loadDiffSpec(arg string) (string, *btf.Spec, error) {
+	path := arg
+
+	if !utils.Exists(arg) {
+		e, err := lookupKey(arg)
+		if err != nil {
+			return "", nil, err
+		}
+		path = e.Path
+	}
+
+	data, err := archive.ReadBTF(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("read %s: %s", path, err)
+	}
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("parse %s: %s", path, err)
+	}
+
+	return path, spec, nil
+}
+
+// lookupKey returns the archived BTF file of a lookup key
+func lookupKey(key string) (archive.Entry, error) {
+	var kernel string
+	var dirs []archive.Dir
+	var err error
+
+	if parts := strings.Split(key, "/"); len(parts) == 4 {
+		kernel = parts[3]
+		root, err := archiveRoot()
+		if err != nil {
+			return archive.Entry{}, err
+		}
+		dirs, err = archive.Dirs(root, parts[0], parts[1], parts[2])
+		if err != nil {
+			return archive.Entry{}, err
+		}
+	} else {
+		kernel = key
+		if dirs, err = archiveDirs(); err != nil {
+			return archive.Entry{}, err
+		}
+	}
+
+	for _, d := range dirs {
+		if e, ok := d.Lookup(kernel); ok {
+			return e, nil
+		}
+	}
+	return archive.Entry{}, fmt.Errorf("no such file or archived kernel: %s", key)
+}
+
+// diffTypeNames returns the type names of the -types flag. BPF objects stand
+// for the types their CO-RE relocations refer to.
+func diffTypeNames(list string) ([]string, error) {
+	var names []string
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !utils.Exists(item) {
+			names = append(names, item)
+			continue
+		}
+		obj, err := corecheck.Load(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, typ := range obj.Types() {
+			if name := typ.TypeName(); name != "" {
+				names = append(names, btfdiff.BaseName(name))
+			}
+		}
+	}
+
+	return names, nil
+}
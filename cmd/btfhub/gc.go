@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+)
+
+var gcCmd = &command{
+	name:  "gc",
+	short: "remove stale intermediate files (packages, vmlinux and BTF files)",
+	flags: func(fs *flag.FlagSet) {
+		scratchFlag(fs)
+		fs.DurationVar(&gcMaxAge, "max-age", 72*time.Hour, "remove intermediate files older than this (0 keeps them)")
+		fs.BoolVar(&gcDryRun, "n", false, "only list the files that would be removed")
+	},
+	run: runGC,
+}
+
+var gcMaxAge time.Duration
+var gcDryRun bool
+
+func runGC(ctx context.Context, _ []string) error {
+	return sweep(ctx, gcMaxAge, gcDryRun)
+}
+
+// sweep removes the intermediate files, of the selected archive and scratch
+// directories, that belong to an archived kernel or are older than maxAge, and
+// the old packages kept in the zypper cache
+func sweep(ctx context.Context, maxAge time.Duration, dryRun bool) error {
+	if err := validateTarget(); err != nil {
+		return err
+	}
+	root, err := archiveRoot()
+	if err != nil {
+		return err
+	}
+	scratch, err := scratchRoot()
+	if err != nil {
+		return err
+	}
+
+	var dirs []archive.Dir
+	for _, r := range []string{root, scratch} {
+		if _, err := os.Stat(r); err != nil || (r == scratch && scratch == root) {
+			continue // nothing to clean up (yet)
+		}
+		rdirs, err := archive.Dirs(r, distro, release, arch)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, rdirs...)
+	}
+
+	var stale []string
+	for _, d := range dirs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		final := d
+		final.Path = filepath.Join(root, d.Distro, d.Release, d.Arch)
+		files, err := d.Stale(final, maxAge)
+		if err != nil {
+			return err
+		}
+		stale = append(stale, files...)
+	}
+
+	if distro == "" || distro == "sles" {
+		files, err := archive.StaleCache(archive.ZyppCacheDir, maxAge)
+		if err != nil {
+			log.Printf("ERROR: %s: %s\n", archive.ZyppCacheDir, err)
+		}
+		stale = append(stale, files...)
+	}
+
+	if dryRun {
+		for _, f := range stale {
+			log.Printf("INFO: would remove %s\n", f)
+		}
+		return nil
+	}
+
+	removed, reclaimed, errs := archive.Remove(stale)
+	for _, err := range errs {
+		log.Printf("ERROR: %s\n", err)
+	}
+	log.Printf("INFO: removed %d stale files, reclaimed %s\n", removed, humanize.Bytes(reclaimed))
+
+	return nil
+}
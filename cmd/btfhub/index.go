@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+var indexCmd = &command{
+	name:  "index",
+	short: "write the manifest (checksums) of the archived BTF files",
+	run:   runIndex,
+}
+
+func runIndex(ctx context.Context, _ []string) error {
+	dirs, err := archiveDirs()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dirs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, err := d.Index()
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: %s: indexed %d BTF files\n", d, len(m.Files))
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/aquasecurity/btfhub/pkg/repo"
+)
+
+var listDistrosCmd = &command{
+	name:  "list-distros",
+	short: "list the supported distributions, releases and architectures",
+	run:   runListDistros,
+}
+
+func runListDistros(_ context.Context, _ []string) error {
+	if err := validateTarget(); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DISTRO\tRELEASES\tARCHS\tDEFAULT")
+	for _, d := range repo.Distros() {
+		if distro != "" && d.Name != distro {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n",
+			d.Name,
+			strings.Join(d.Releases, ","),
+			strings.Join(d.Archs, ","),
+			d.Default,
+		)
+	}
+
+	return tw.Flush()
+}
+
+var listKernelsCmd = &command{
+	name:  "list-kernels",
+	short: "list the kernels with a BTF file in the archive",
+	run:   runListKernels,
+}
+
+func runListKernels(_ context.Context, _ []string) error {
+	dirs, err := archiveDirs()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tKERNEL\tSIZE")
+	for _, d := range dirs {
+		entries, err := d.Entries()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", d, e.Kernel, humanize.Bytes(uint64(e.Size)))
+		}
+	}
+
+	return tw.Flush()
+}
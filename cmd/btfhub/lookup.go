@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+)
+
+var lookupCmd = &command{
+	name:  "lookup",
+	args:  "[kernel-release]",
+	short: "find the archived BTF file of a kernel (defaults to the running system)",
+	flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&lookupOut, "o", "", "extract the BTF file to the given path")
+	},
+	run: runLookup,
+}
+
+var lookupOut string
+
+func runLookup(_ context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("lookup takes at most one kernel release")
+	}
+
+	kernel := ""
+	if len(args) == 1 {
+		kernel = args[0]
+	}
+
+	// Default to the running system
+
+	if distro == "" {
+		id, rel, err := hostDistro()
+		if err != nil {
+			return err
+		}
+		distro = id
+		if release == "" {
+			release = rel
+		}
+	}
+	if arch == "" {
+		arch = hostArch()
+	}
+	if kernel == "" {
+		b, err := os.ReadFile("/proc/sys/kernel/osrelease")
+		if err != nil {
+			return fmt.Errorf("kernel release: %s", err)
+		}
+		kernel = strings.TrimSpace(string(b))
+	}
+
+	dirs, err := archiveDirs()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dirs {
+		e, ok := d.Lookup(kernel)
+		if !ok {
+			continue
+		}
+		fmt.Println(e.Path)
+		if lookupOut != "" {
+			return archive.ExtractBTF(e.Path, lookupOut)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no BTF file found for %s (%s/%s/%s)", kernel, distro, release, arch)
+}
+
+// hostDistro returns the distribution and release of the running system, as
+// named in the archive, out of /etc/os-release
+func hostDistro() (string, string, error) {
+	f, err := os.Open("/etc/os-release")
+	if err != nil {
+		return "", "", fmt.Errorf("os-release: %s", err)
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), "=")
+		if !ok {
+			continue
+		}
+		vars[k] = strings.Trim(v, `"'`)
+	}
+	if err := s.Err(); err != nil {
+		return "", "", fmt.Errorf("os-release: %s", err)
+	}
+
+	id := vars["ID"]
+	switch id {
+	case "ubuntu", "debian":
+		return id, vars["VERSION_CODENAME"], nil
+	case "fedora", "sles":
+		return id, vars["VERSION_ID"], nil
+	case "centos", "ol", "rhel", "amzn":
+		major, _, _ := strings.Cut(vars["VERSION_ID"], ".")
+		return id, major, nil
+	}
+
+	return "", "", fmt.Errorf("unsupported distribution %q", id)
+}
+
+// hostArch returns the architecture of the running system, as named in the
+// archive
+func hostArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	default:
+		return runtime.GOARCH
+	}
+}
@@ -2,172 +2,266 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/aquasecurity/btfhub/pkg/archive"
 	"github.com/aquasecurity/btfhub/pkg/job"
+	"github.com/aquasecurity/btfhub/pkg/kernel"
 	"github.com/aquasecurity/btfhub/pkg/repo"
 )
 
-var distroReleases = map[string][]string{
-	"ubuntu": {"xenial", "bionic", "focal"},
-	"debian": {"stretch", "buster", "bullseye"},
-	"fedora": {"24", "25", "26", "27", "28", "29", "30", "31"},
-	"centos": {"7", "8"},
-	"ol":     {"7", "8"},
-	"rhel":   {"7", "8"},
-	"amzn":   {"1", "2"},
-	"sles":   {"12.3", "12.5", "15.1", "15.2", "15.3", "15.4"},
-}
+var version string
 
-type repoFunc func() repo.Repository
+// command is a btfhub subcommand
+type command struct {
+	name  string
+	args  string // arguments synopsis
+	short string // one line description
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, args []string) error
+}
 
-var repoCreators = map[string]repoFunc{
-	"ubuntu": repo.NewUbuntuRepo,
-	"debian": repo.NewDebianRepo,
-	"fedora": repo.NewFedoraRepo,
-	"centos": repo.NewCentOSRepo,
-	"ol":     repo.NewOracleRepo,
-	"rhel":   repo.NewRHELRepo,
-	"amzn":   repo.NewAmazonRepo,
-	"sles":   repo.NewSUSERepo,
+var commands = []*command{
+	updateCmd,
+	listDistrosCmd,
+	listKernelsCmd,
+	statusCmd,
+	verifyCmd,
+	lookupCmd,
+	indexCmd,
+	gcCmd,
+	serveCmd,
+	diffCmd,
+	checkObjectCmd,
+	btfgenCmd,
 }
 
+// Global flags (shared by all commands)
+
 var distro, release, arch string
 var numWorkers int
-var force bool
+var archiveDir string
+
+func globalFlags(fs *flag.FlagSet) {
+	distros := strings.Join(repo.DistroNames(), ",")
+	fs.StringVar(&distro, "distro", "", "distribution ("+distros+")")
+	fs.StringVar(&distro, "d", "", "distribution ("+distros+")")
+	fs.StringVar(&release, "release", "", "distribution release, requires specifying distribution")
+	fs.StringVar(&release, "r", "", "distribution release, requires specifying distribution")
+	archs := strings.Join(kernelArchNames(), ",")
+	fs.StringVar(&arch, "arch", "", "architecture ("+archs+")")
+	fs.StringVar(&arch, "a", "", "architecture ("+archs+")")
+	fs.IntVar(&numWorkers, "workers", 0, "number of concurrent workers (defaults to runtime.NumCPU() - 1)")
+	fs.IntVar(&numWorkers, "j", 0, "number of concurrent workers (defaults to runtime.NumCPU() - 1)")
+	fs.StringVar(&archiveDir, "archive-dir", "archive", "directory of the published BTF archive")
+}
 
-func init() {
-	flag.StringVar(&distro, "distro", "", "distribution to update (ubuntu,debian,centos,fedora,ol,rhel,amazon,sles)")
-	flag.StringVar(&distro, "d", "", "distribution to update (ubuntu,debian,centos,fedora,ol,rhel,amazon,sles)")
-	flag.StringVar(&release, "release", "", "distribution release to update, requires specifying distribution")
-	flag.StringVar(&release, "r", "", "distribution release to update, requires specifying distribution")
-	flag.StringVar(&arch, "arch", "", "architecture to update (x86_64,arm64)")
-	flag.StringVar(&arch, "a", "", "architecture to update (x86_64,arm64)")
-	flag.IntVar(&numWorkers, "workers", 0, "number of concurrent workers (defaults to runtime.NumCPU() - 1)")
-	flag.IntVar(&numWorkers, "j", 0, "number of concurrent workers (defaults to runtime.NumCPU() - 1)")
-	flag.BoolVar(&force, "f", false, "force update regardless of existing files (defaults to false)")
+// scratchFlag adds the flag of the directory for intermediate files to the
+// commands that create or clean them up
+func scratchFlag(fs *flag.FlagSet) {
+	fs.StringVar(&scratchDir, "scratch-dir", "", "directory for downloaded packages and other intermediate files (defaults to the archive directory)")
 }
 
+var scratchDir string
+
 func main() {
-	flag.Parse()
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	if err := run(ctx); err != nil {
-		log.Fatal(err)
-	}
-}
+	args := os.Args[1:]
 
-func run(ctx context.Context) error {
+	// Keep "btfhub [flags]" working as "btfhub update [flags]"
 
-	if distro != "" {
-		if _, ok := distroReleases[distro]; !ok {
-			return fmt.Errorf("invalid distribution %s", distro)
-		}
-		if release != "" {
-			found := false
-			for _, r := range distroReleases[distro] {
-				found = r == release
-				if found {
-					break
-				}
-			}
-			if !found {
-				return fmt.Errorf("invalid release %s for %s", release, distro)
+	name := "update"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "help":
+		if len(args) > 0 {
+			if cmd := findCommand(args[0]); cmd != nil {
+				newFlagSet(cmd).Usage()
+				return
 			}
 		}
-	} else {
-		release = "" // no release if no distro is selected
+		usage()
+		return
+	case "version":
+		fmt.Println(version)
+		return
 	}
 
-	// Distributions
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
-	distros := []string{"ubuntu", "debian", "fedora", "centos", "ol"} // RHEL needs subscription
-	if distro != "" {
-		distros = []string{distro}
+	fs := newFlagSet(cmd)
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
 	}
 
-	// Architectures
+	if err := cmd.run(ctx, fs.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	archs := []string{"x86_64", "arm64"}
-	if arch != "" {
-		archs = []string{arch}
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
+	return nil
+}
 
-	// Environment
+// newFlagSet creates the flag set of a command (global and command flags)
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	globalFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: btfhub %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.short)
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-	basedir, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("pwd: %s", err)
+func usage() {
+	out := os.Stderr
+	fmt.Fprintf(out, "Usage: btfhub <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-14s %s\n", cmd.name, cmd.short)
 	}
-	archiveDir := path.Join(basedir, "archive")
+	fmt.Fprintf(out, "\nRun \"btfhub help <command>\" for the flags of a command.\n")
+	fmt.Fprintf(out, "Running btfhub without a command is the same as \"btfhub update\".\n")
+}
 
-	if numWorkers == 0 {
-		numWorkers = runtime.NumCPU() - 1
-		if numWorkers > 12 {
-			numWorkers = 12 // limit to 12 workers max (for bigger machines)
+// validateTarget checks the selected distribution, release and architecture
+// against the registered repositories
+func validateTarget() error {
+	return checkTarget(distro, release, arch)
+}
+
+// checkTarget checks the given distribution, release and architecture (empty
+// for all) against the registered repositories
+func checkTarget(distro, release, arch string) error {
+	if distro == "" {
+		if release != "" {
+			return errors.New("release requires specifying distribution")
 		}
+		if arch != "" && !slices.ContainsFunc(repo.Distros(), func(d *repo.Distro) bool { return d.HasArch(arch) }) {
+			return fmt.Errorf("invalid arch %s", arch)
+		}
+		return nil
 	}
 
-	// Workers: job consumers (pool)
+	d, err := repo.GetDistro(distro)
+	if err != nil {
+		return err
+	}
+	if release != "" && !d.HasRelease(release) {
+		return fmt.Errorf("invalid release %s for %s (valid: %s)", release, distro, strings.Join(d.Releases, ","))
+	}
+	if arch != "" && !d.HasArch(arch) {
+		return fmt.Errorf("invalid arch %s for %s (valid: %s)", arch, distro, strings.Join(d.Archs, ","))
+	}
+	if release != "" && arch != "" && !d.ReleaseHasArch(release, arch) {
+		return fmt.Errorf("%s %s is not published for %s (valid: %s)", distro, release, arch, strings.Join(d.ReleaseArchs[release], ","))
+	}
 
-	jobChan := make(chan job.Job)
-	consume, consCtx := errgroup.WithContext(ctx)
+	return nil
+}
 
-	log.Printf("Using %d workers\n", numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		consume.Go(func() error {
-			return job.StartWorker(consCtx, jobChan)
-		})
+// kernelArchNames returns the names of the supported architectures
+func kernelArchNames() []string {
+	var names []string
+	for _, a := range kernel.Archs {
+		names = append(names, a.Name)
 	}
+	return names
+}
 
-	// Workers: job producers (per distro, per release)
+// archiveRoot returns the absolute path of the archive directory
+func archiveRoot() (string, error) {
+	root, err := filepath.Abs(archiveDir)
+	if err != nil {
+		return "", fmt.Errorf("archive dir: %s", err)
+	}
+	return root, nil
+}
 
-	produce, prodCtx := errgroup.WithContext(ctx)
+// scratchRoot returns the absolute path of the scratch directory (the archive
+// directory if none was given)
+func scratchRoot() (string, error) {
+	if scratchDir == "" {
+		return archiveRoot()
+	}
+	root, err := filepath.Abs(scratchDir)
+	if err != nil {
+		return "", fmt.Errorf("scratch dir: %s", err)
+	}
+	return root, nil
+}
 
-	for _, d := range distros {
-		releases := distroReleases[d]
-		if release != "" {
-			releases = []string{release}
-		}
-		for _, r := range releases {
-			release := r
-			for _, a := range archs {
-				arch := a
-				distro := d
-				produce.Go(func() error {
-					// workDir example: ./archive/ubuntu/focal/x86_64
-					workDir := filepath.Join(archiveDir, distro, release, arch)
-					if err := os.MkdirAll(workDir, 0775); err != nil {
-						return fmt.Errorf("arch dir: %s", err)
-					}
-
-					// pick the repository creator and get the kernel packages
-					repo := repoCreators[distro]()
-
-					return repo.GetKernelPackages(prodCtx, workDir, release, arch, force, jobChan)
-				})
+// archiveDirs returns the archive directories of the selected distribution,
+// release and architecture
+func archiveDirs() ([]archive.Dir, error) {
+	if err := validateTarget(); err != nil {
+		return nil, err
+	}
+	root, err := archiveRoot()
+	if err != nil {
+		return nil, err
+	}
+	return archive.Dirs(root, distro, release, arch)
+}
 
-			}
-		}
+// workers returns the number of workers to use
+func workers() int {
+	if numWorkers > 0 {
+		return numWorkers
+	}
+	n := runtime.NumCPU() - 1
+	if n > 12 {
+		n = 12 // limit to 12 workers max (for bigger machines)
+	}
+	if n < 1 {
+		n = 1
 	}
+	return n
+}
 
-	// Cleanup
+// startWorkers starts the pool of workers consuming jobs from the returned
+// channel. Close the channel and wait on the errgroup to stop them.
+func startWorkers(ctx context.Context) (chan job.Job, *errgroup.Group) {
+	jobChan := make(chan job.Job)
+	consume, consCtx := errgroup.WithContext(ctx)
 
-	err = produce.Wait()
-	close(jobChan)
-	if err != nil {
-		return err
+	n := workers()
+	log.Printf("Using %d workers\n", n)
+	for i := 0; i < n; i++ {
+		consume.Go(func() error {
+			return job.StartWorker(consCtx, jobChan)
+		})
 	}
 
-	return consume.Wait()
+	return jobChan, consume
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+)
+
+var serveCmd = &command{
+	name:  "serve",
+	short: "serve the archive over HTTP",
+	flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&listenAddr, "listen", ":8080", "address to listen on")
+	},
+	run: runServe,
+}
+
+var listenAddr string
+
+func runServe(ctx context.Context, _ []string) error {
+	if err := validateTarget(); err != nil {
+		return err
+	}
+	root, err := archiveRoot()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              listenAddr,
+		Handler:           serveMux(root),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutCtx)
+	}()
+
+	log.Printf("INFO: serving %s on %s\n", root, listenAddr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// serveMux returns the handler of the archive in root
+func serveMux(root string) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// /archive/<distro>/<release>/<arch>/<kernel>.btf.tar.xz (or .btf.zst, .btf, .oci/)
+	mux.Handle("/archive/", http.StripPrefix("/archive/", http.FileServer(http.Dir(root))))
+
+	// /lookup?distro=ubuntu&release=focal&arch=x86_64&kernel=5.4.0-1-generic
+	mux.HandleFunc("/lookup", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if err := checkQuery(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		dirs, err := archive.Dirs(root, q.Get("distro"), q.Get("release"), q.Get("arch"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, d := range dirs {
+			if e, ok := d.Lookup(q.Get("kernel")); ok {
+				rel, _ := filepath.Rel(root, e.Path)
+				http.Redirect(w, r, "/archive/"+filepath.ToSlash(rel), http.StatusFound)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+
+	// /kernels?distro=ubuntu&release=focal&arch=x86_64
+	mux.HandleFunc("/kernels", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if err := checkQuery(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		dirs, err := archive.Dirs(root, q.Get("distro"), q.Get("release"), q.Get("arch"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entries := []archive.Entry{}
+		for _, d := range dirs {
+			e, err := d.Entries()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, e...)
+		}
+		for i := range entries {
+			rel, _ := filepath.Rel(root, entries[i].Path)
+			entries[i].Path = "/archive/" + filepath.ToSlash(rel)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
+
+	return mux
+}
+
+// checkQuery checks the target and the kernel of a request: they are joined
+// to archive paths, so they must not leave the archive
+func checkQuery(q url.Values) error {
+	for _, key := range []string{"distro", "release", "arch", "kernel"} {
+		if v := q.Get(key); strings.Contains(v, "/") || strings.Contains(v, "..") {
+			return fmt.Errorf("invalid %s %q", key, v)
+		}
+	}
+	return checkTarget(q.Get("distro"), q.Get("release"), q.Get("arch"))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeMux(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "ubuntu", "focal", "x86_64")
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "5.4.0-42-generic.btf.tar.xz"), []byte("btf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a tarball outside of the archive root
+	if err := os.WriteFile(filepath.Join(filepath.Dir(root), "outside.btf.tar.xz"), []byte("btf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(serveMux(root))
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for query, want := range map[string]int{
+		"/kernels?distro=ubuntu&release=focal&arch=x86_64":                        http.StatusOK,
+		"/kernels?distro=..&release=..&arch=tmp":                                  http.StatusBadRequest,
+		"/kernels?distro=ubuntu&release=focal&arch=riscv64":                       http.StatusBadRequest,
+		"/lookup?distro=ubuntu&release=focal&arch=x86_64&kernel=5.4.0-42-generic": http.StatusFound,
+		"/lookup?distro=ubuntu&release=focal&arch=x86_64&kernel=../../../outside": http.StatusBadRequest,
+		"/lookup?distro=ubuntu&release=focal&arch=x86_64&kernel=5.4.0-43-generic": http.StatusNotFound,
+		"/lookup?distro=centos&release=focal&arch=x86_64&kernel=5.4.0-42-generic": http.StatusBadRequest,
+	} {
+		resp, err := client.Get(srv.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status %d, expected %d", query, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+)
+
+var statusCmd = &command{
+	name:  "status",
+	short: "summarize the archive contents per distribution, release and architecture",
+	run:   runStatus,
+}
+
+func runStatus(_ context.Context, _ []string) error {
+	dirs, err := archiveDirs()
+	if err != nil {
+		return err
+	}
+
+	var totalBTFs, totalHasBTF, totalFailed, totalLeftovers int
+	var totalSize int64
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tBTF\tHASBTF\tFAILED\tLEFTOVERS\tSIZE")
+	for _, d := range dirs {
+		entries, err := d.Entries()
+		if err != nil {
+			return err
+		}
+		hasBTF, err := d.Markers(archive.HasBTFExt)
+		if err != nil {
+			return err
+		}
+		failed, err := d.Markers(archive.FailedExt)
+		if err != nil {
+			return err
+		}
+		leftovers, err := d.Leftovers()
+		if err != nil {
+			return err
+		}
+
+		var size int64
+		for _, e := range entries {
+			size += e.Size
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n",
+			d, len(entries), len(hasBTF), len(failed), len(leftovers), humanize.Bytes(uint64(size)))
+
+		totalBTFs += len(entries)
+		totalHasBTF += len(hasBTF)
+		totalFailed += len(failed)
+		totalLeftovers += len(leftovers)
+		totalSize += size
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%d\t%d\t%d\t%s\n",
+		totalBTFs, totalHasBTF, totalFailed, totalLeftovers, humanize.Bytes(uint64(totalSize)))
+
+	return tw.Flush()
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/job"
+	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/metrics"
+	"github.com/aquasecurity/btfhub/pkg/output"
+	"github.com/aquasecurity/btfhub/pkg/repo"
+	"github.com/aquasecurity/btfhub/pkg/report"
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+var updateCmd = &command{
+	name:  "update",
+	short: "fetch kernel packages and generate the missing BTF files",
+	flags: func(fs *flag.FlagSet) {
+		scratchFlag(fs)
+		fs.BoolVar(&force, "f", false, "force update regardless of existing files (defaults to false)")
+		fs.BoolVar(&regenerateIfStale, "regenerate-if-stale", false, "regenerate the existing BTF files generated by another pahole version or flags (or an unknown one)")
+		fs.StringVar(&kernelName, "kernel", "", "only process the given kernel (BTF file name or package version)")
+		fs.StringVar(&minVersion, "min-version", "", "only process kernel packages with this version or newer")
+		fs.StringVar(&maxVersion, "max-version", "", "only process kernel packages with this version or older")
+		fs.StringVar(&flavors, "flavor", "", "only process kernels of the given flavors (e.g. generic,aws,azure,default,uek)")
+		fs.StringVar(&pkgRegex, "package-regex", "", "only process kernel packages whose name matches the given regex")
+		fs.BoolVar(&dryRun, "dry-run", false, "only print the plan of what would be processed, without running the commands changing the host (defaults to false)")
+		fs.StringVar(&planPath, "plan", "", "write the dry-run plan as JSON to the given file instead of printing it")
+		fs.StringVar(&reportPath, "report", "", "write a JSON report of the run to the given file")
+		fs.StringVar(&reportMDPath, "report-md", "", "write a markdown summary of the run to the given file")
+		fs.StringVar(&metricsAddr, "metrics-addr", "", "address to expose prometheus metrics on (e.g. :9090, disabled by default)")
+		fs.StringVar(&outputFormat, "output", "tar.xz", "output format of the BTF files ("+strings.Join(output.Names(), ",")+")")
+		fs.IntVar(&xzThreads, "xz-threads", 0, "xz blocks (1 MiB of BTF each) compressed in parallel per tar.xz file (defaults to the CPUs per worker)")
+		fs.StringVar(&ociRegistry, "oci-registry", "", "push the oci output to the given registry (e.g. localhost:5000/btfhub)")
+		fs.BoolVar(&ociPlainHTTP, "oci-plain-http", false, "use plain http to push to the oci registry")
+		fs.BoolVar(&dedup, "dedup", false, "deduplicate the BTF files, as links to the content addressed store of the archive (requires -output btf.zst, skips pahole for known kernel images)")
+		fs.BoolVar(&modules, "modules", false, "also generate split BTF files for the kernel modules in the distro allow-list")
+		fs.StringVar(&cacheDir, "cache-dir", "", "keep the downloaded packages in this cache directory, shared by all the distros and runs (no cache if empty)")
+		fs.StringVar(&cacheSize, "cache-size", "", "size limit of the package cache, least recently used packages are evicted (e.g. 500GB, no limit if empty)")
+		fs.StringVar(&indexCacheDir, "index-cache-dir", "", "keep the repository indexes in this directory, and only download and parse them again when changed upstream, updating the APT indexes with pdiffs where available (no cache if empty)")
+		fs.StringVar(&paholeImage, "pahole-image", "", "run pahole in a container of the given image, pinned by digest (e.g. quay.io/org/pahole@sha256:...)")
+		fs.StringVar(&paholeCPUs, "pahole-cpus", "", "cpu limit of the pahole containers (e.g. 2)")
+		fs.StringVar(&paholeMemory, "pahole-memory", "", "memory limit of the pahole containers (e.g. 4g)")
+		fs.StringVar(&containerRuntime, "container-runtime", "podman", "container runtime running the pahole containers (podman,docker)")
+		fs.StringVar(&httpConfig.Proxy, "http-proxy", "", "proxy of the HTTP fetches (from HTTP_PROXY, HTTPS_PROXY and NO_PROXY if empty)")
+		fs.StringVar(&httpConfig.CAFile, "http-ca-file", "", "PEM bundle of CAs to trust on top of the system ones (e.g. of an internal mirror)")
+		fs.StringVar(&httpConfig.CertFile, "http-cert", "", "PEM client certificate of the HTTP fetches (requires -http-key)")
+		fs.StringVar(&httpConfig.KeyFile, "http-key", "", "PEM key of the client certificate")
+		fs.StringVar(&httpConfig.NetrcFile, "netrc", "", "netrc file with the credentials (basic auth) of the repository hosts (machine entries, only sent over https)")
+		fs.StringVar(&httpConfig.UserAgent, "user-agent", "btfhub", "User-Agent of the HTTP fetches")
+		fs.DurationVar(&httpConfig.ConnectTimeout, "http-connect-timeout", 30*time.Second, "timeout of the HTTP connections (0 for none)")
+		fs.DurationVar(&httpConfig.HeaderTimeout, "http-header-timeout", time.Minute, "timeout of the HTTP responses headers (0 for none)")
+		fs.StringVar(&hostLimits, "host-limits", "", "per-host (or per-repository, host/path) caps of the concurrent requests and their bandwidth per second, * for each of the other hosts (e.g. ddebs.ubuntu.com=2/50MB,archives.fedoraproject.org/pub/archive=2,*=8)")
+		fs.BoolVar(&noGC, "no-gc", false, "do not remove stale intermediate files before the run")
+		fs.DurationVar(&gcMaxAge, "gc-max-age", 72*time.Hour, "remove intermediate files older than this before the run (0 keeps them)")
+	},
+	run: runUpdate,
+}
+
+var force bool
+var regenerateIfStale bool
+var dryRun bool
+var kernelName, minVersion, maxVersion, flavors, pkgRegex string
+var planPath string
+var metricsAddr string
+var reportPath, reportMDPath string
+var noGC bool
+var dedup bool
+var modules bool
+var outputFormat, ociRegistry string
+var xzThreads int
+var ociPlainHTTP bool
+var cacheDir, cacheSize string
+var indexCacheDir string
+var httpConfig utils.HTTPConfig
+var hostLimits string
+var paholeImage, paholeCPUs, paholeMemory, containerRuntime string
+
+func runUpdate(ctx context.Context, _ []string) error {
+	err := update(ctx)
+
+	report.Default.Finish()
+	if dryRun {
+		return err // dry-runs have no side effects (no reports)
+	}
+	if rerr := report.Default.WriteFiles(reportPath, reportMDPath); rerr != nil {
+		log.Printf("ERROR: %s\n", rerr)
+	}
+
+	return err
+}
+
+func update(ctx context.Context) error {
+
+	if err := validateTarget(); err != nil {
+		return err
+	}
+
+	// Distributions
+
+	var distros []*repo.Distro
+	if distro != "" {
+		d, _ := repo.GetDistro(distro)
+		distros = []*repo.Distro{d}
+	} else {
+		for _, d := range repo.Distros() {
+			if d.Default {
+				distros = append(distros, d)
+			}
+		}
+	}
+
+	// HTTP client (repositories and oci registry), limited per host
+
+	limits, err := utils.ParseHostLimits(hostLimits)
+	if err != nil {
+		return err
+	}
+	utils.DefaultHostLimiter = utils.NewHostLimiter(limits)
+	httpConfig.Limiter = utils.DefaultHostLimiter
+
+	client, err := utils.NewHTTPClient(httpConfig)
+	if err != nil {
+		return err
+	}
+	utils.HTTPClient = client
+
+	// Output format
+
+	enc, err := output.New(outputFormat)
+	if err != nil {
+		return err
+	}
+	if txz, ok := enc.(output.TarXZ); ok {
+		txz.Concurrency = xzConcurrency()
+		enc = txz
+	}
+	if _, zst := enc.(output.Zstd); dedup && !zst {
+		// only the btf.zst files can be links to the store blobs: in other
+		// formats, every BTF file would be stored twice
+		return fmt.Errorf("dedup requires the %s output format", output.Zstd{}.Name())
+	}
+	if oci, ok := enc.(*output.OCI); ok {
+		oci.Registry = ociRegistry
+		oci.PlainHTTP = ociPlainHTTP
+		oci.Client = client
+	} else if ociRegistry != "" {
+		return errors.New("oci-registry requires the oci output format")
+	}
+	output.Default = enc
+
+	// Filters
+
+	filter, err := newFilter()
+	if err != nil {
+		return err
+	}
+
+	// Environment
+
+	archiveBase, err := archiveRoot()
+	if err != nil {
+		return err
+	}
+	scratchBase, err := scratchRoot()
+	if err != nil {
+		return err
+	}
+
+	// Commands (pahole optionally in a container, version checked)
+
+	var runner utils.CommandRunner = utils.SudoRunner{Runner: utils.LocalRunner{}}
+	var dryRunner *utils.DryRunRunner
+	if dryRun {
+		dryRunner = &utils.DryRunRunner{Runner: runner} // queries only
+		runner = dryRunner
+	}
+	paholeRun, err := paholeRunner(runner, archiveBase, scratchBase)
+	if err != nil {
+		return err
+	}
+	var pahole *job.Pahole
+	if !dryRun || regenerateIfStale {
+		if pahole, err = job.NewPahole(ctx, paholeRun); err != nil {
+			return fmt.Errorf("pahole: %s", err)
+		}
+		log.Printf("DEBUG: pahole %s %s\n", pahole.Version, strings.Join(pahole.Flags, " "))
+	}
+
+	// Garbage collection (intermediate files left by interrupted runs)
+
+	if !noGC && !dryRun {
+		if err := sweep(ctx, gcMaxAge, false); err != nil {
+			return err
+		}
+	}
+
+	// Content addressed store
+
+	var store *archive.Store
+	if dedup {
+		store = archive.NewStore(archiveBase)
+	}
+
+	// Package cache
+
+	var cache *archive.PackageCache
+	if cacheDir != "" {
+		var maxSize uint64
+		if cacheSize != "" {
+			if maxSize, err = humanize.ParseBytes(cacheSize); err != nil {
+				return fmt.Errorf("cache size: %s", err)
+			}
+		}
+		cache = archive.NewPackageCache(cacheDir, int64(maxSize))
+	}
+
+	// Repository index cache
+
+	utils.DefaultIndexCache = nil
+	if indexCacheDir != "" {
+		utils.DefaultIndexCache = utils.NewIndexCache(indexCacheDir)
+	}
+
+	// Metrics
+
+	if metricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(ctx, metricsAddr); err != nil {
+				log.Printf("ERROR: metrics: %s\n", err)
+			}
+		}()
+	}
+
+	// Workers: job consumers (pool)
+
+	jobChan, consume := startWorkers(ctx)
+
+	// Workers: job producers (per distro, per release, per arch)
+
+	produce, prodCtx := errgroup.WithContext(ctx)
+
+	var plans []*repo.Plan
+	var plansMtx sync.Mutex
+
+	for _, d := range distros {
+		releases := d.Releases
+		if release != "" {
+			releases = []string{release}
+		}
+		archs := d.Archs
+		if arch != "" {
+			archs = []string{arch}
+		}
+		for _, release := range releases {
+			for _, arch := range archs {
+				if !d.ReleaseHasArch(release, arch) {
+					log.Printf("INFO: %s %s does not have %s packages\n", d.Name, release, arch)
+					continue
+				}
+				produce.Go(func() error {
+					// workDir example: ./archive/ubuntu/focal/x86_64
+					workDir := filepath.Join(archiveBase, d.Name, release, arch)
+					// scratch example: /tmp/btfhub/ubuntu/focal/x86_64
+					scratch := filepath.Join(scratchBase, d.Name, release, arch)
+					if !dryRun {
+						if err := os.MkdirAll(workDir, 0775); err != nil {
+							return fmt.Errorf("arch dir: %s", err)
+						}
+						if err := os.MkdirAll(scratch, 0775); err != nil {
+							return fmt.Errorf("scratch dir: %s", err)
+						}
+					}
+
+					// create the repository and get the kernel packages
+					rep := d.New(runner)
+
+					plan, err := rep.GetKernelPackages(prodCtx, workDir, release, arch, force)
+					if err != nil {
+						return err
+					}
+					plan.ScratchDir = scratch
+					plan.Store = store
+					plan.Cache = cache
+					plan.Pahole = pahole
+					if modules {
+						plan.Modules = d.Modules
+					}
+					plan.Filter(filter)
+					if regenerateIfStale {
+						if err := plan.MarkStale(pahole); err != nil {
+							return err
+						}
+					}
+
+					if dryRun {
+						plansMtx.Lock()
+						plans = append(plans, plan)
+						plansMtx.Unlock()
+						return nil
+					}
+
+					return repo.ExecutePlan(prodCtx, plan, jobChan)
+				})
+			}
+		}
+	}
+
+	// Cleanup
+
+	err = produce.Wait()
+	close(jobChan)
+	if err != nil {
+		return err
+	}
+
+	if err := consume.Wait(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		for _, c := range dryRunner.Commands() {
+			log.Printf("INFO: dry-run, not run: %s\n", c)
+		}
+		return writePlans(plans)
+	}
+
+	return nil
+}
+
+// newFilter creates the kernel package filter from the command line flags
+func newFilter() (*repo.Filter, error) {
+	filter := &repo.Filter{
+		Kernel:     kernelName,
+		MinVersion: kernel.NewKernelVersion(minVersion),
+		MaxVersion: kernel.NewKernelVersion(maxVersion),
+	}
+
+	if !filter.MinVersion.IsZero() && !filter.MaxVersion.IsZero() && filter.MaxVersion.Less(filter.MinVersion) {
+		return nil, fmt.Errorf("max version %s is older than min version %s", maxVersion, minVersion)
+	}
+
+	for _, f := range strings.Split(flavors, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			filter.Flavors = append(filter.Flavors, f)
+		}
+	}
+
+	if pkgRegex != "" {
+		re, err := regexp.Compile(pkgRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package regex: %s", err)
+		}
+		filter.PackageRegex = re
+	}
+
+	return filter, nil
+}
+
+// paholeRunner returns the runner of pahole: the given one, or a container
+// runner (with the archive and scratch directories mounted) if an image was
+// given
+func paholeRunner(runner utils.CommandRunner, archiveBase string, scratchBase string) (utils.CommandRunner, error) {
+	if paholeImage == "" {
+		if paholeCPUs != "" || paholeMemory != "" {
+			return nil, errors.New("pahole-cpus and pahole-memory require pahole-image")
+		}
+		return runner, nil
+	}
+
+	cr, err := utils.NewContainerRunner(runner, containerRuntime, paholeImage)
+	if err != nil {
+		return nil, err
+	}
+	cr.CPUs = paholeCPUs
+	cr.Memory = paholeMemory
+	cr.Volumes = []string{archiveBase}
+	if scratchBase != archiveBase {
+		cr.Volumes = append(cr.Volumes, scratchBase)
+	}
+
+	return cr, nil
+}
+
+// writePlans prints the plans, or writes them as JSON if a plan file was given
+func writePlans(plans []*repo.Plan) error {
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Target.String() < plans[j].Target.String()
+	})
+
+	if planPath == "" {
+		return repo.WritePlans(os.Stdout, plans)
+	}
+
+	f, err := os.Create(planPath)
+	if err != nil {
+		return fmt.Errorf("create plan: %s", err)
+	}
+	if err := repo.WritePlansJSON(f, plans); err != nil {
+		f.Close()
+		return fmt.Errorf("write plan: %s", err)
+	}
+
+	return f.Close()
+}
+
+// xzConcurrency returns the number of xz blocks compressed in parallel per
+// tar.xz file: the CPUs left to each worker, if not given
+func xzConcurrency() int {
+	if xzThreads > 0 {
+		return xzThreads
+	}
+	n := runtime.NumCPU() / workers()
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/fakerepo"
+	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/metrics"
+	"github.com/aquasecurity/btfhub/pkg/output"
+	"github.com/aquasecurity/btfhub/pkg/repo"
+)
+
+func TestMain(m *testing.M) {
+	fakerepo.Main() // the fake tools run the test binary
+	os.Exit(m.Run())
+}
+
+// fakeDistro fakes the repository of a release with a single kernel
+type fakeDistro struct {
+	distro, release, arch string
+	kernel                string // BTF file name (uname -r)
+	setup                 func(t *testing.T, srv *fakerepo.Server, tools *fakerepo.Tools, vmlinux []byte)
+}
+
+var fakeDistros = []fakeDistro{
+	{
+		distro: "ubuntu", release: "focal", arch: "x86_64", kernel: "5.4.0-42-generic",
+		setup: func(t *testing.T, srv *fakerepo.Server, _ *fakerepo.Tools, vmlinux []byte) {
+			image := fakerepo.APTPackage{
+				Name:     "linux-image-5.4.0-42-generic",
+				Version:  "5.4.0-42.46",
+				Arch:     "amd64",
+				Filename: "pool/main/l/linux-signed/linux-image-5.4.0-42-generic_5.4.0-42.46_amd64.deb",
+				Size:     10_000,
+			}
+			ddeb := fakerepo.APTPackage{
+				Name:     "linux-image-unsigned-5.4.0-42-generic-dbgsym",
+				Version:  "5.4.0-42.46",
+				Arch:     "amd64",
+				Filename: "pool/main/l/linux/linux-image-unsigned-5.4.0-42-generic-dbgsym_5.4.0-42.46_amd64.ddeb",
+				Size:     20_000_000,
+			}
+			addAPTRelease(srv, "http://archive.ubuntu.com/ubuntu", "focal", image)
+			addAPTRelease(srv, "http://ddebs.ubuntu.com", "focal", ddeb)
+			srv.Add("http://ddebs.ubuntu.com/"+ddeb.Filename, fakerepo.Deb(t, map[string][]byte{
+				"./usr/lib/debug/boot/vmlinux-5.4.0-42-generic": vmlinux,
+			}), "application/octet-stream")
+		},
+	},
+	{
+		distro: "debian", release: "bullseye", arch: "x86_64", kernel: "5.10.0-23-amd64",
+		setup: func(t *testing.T, srv *fakerepo.Server, _ *fakerepo.Tools, vmlinux []byte) {
+			dbg := fakerepo.APTPackage{
+				Name:     "linux-image-5.10.0-23-amd64-dbg",
+				Version:  "5.10.179-1",
+				Arch:     "amd64",
+				Filename: "pool/main/l/linux/linux-image-5.10.0-23-amd64-dbg_5.10.179-1_amd64.deb",
+				Size:     20_000_000,
+			}
+			srv.AddAPTPackages("http://ftp.debian.org/debian/dists/bullseye/main/binary-amd64/Packages.xz", dbg)
+			srv.AddAPTPackages("http://ftp.debian.org/debian/dists/bullseye-updates/main/binary-amd64/Packages.xz")
+			srv.AddAPTPackages("http://security.debian.org/debian-security/dists/bullseye-security/main/binary-amd64/Packages.xz")
+			srv.Add("http://ftp.debian.org/debian/"+dbg.Filename, fakerepo.Deb(t, map[string][]byte{
+				"./usr/lib/debug/boot/vmlinux-5.10.0-23-amd64": vmlinux,
+			}), "application/octet-stream")
+		},
+	},
+	{
+		distro: "fedora", release: "31", arch: "x86_64", kernel: "5.3.7-301.fc31.x86_64",
+		setup: func(t *testing.T, srv *fakerepo.Server, _ *fakerepo.Tools, vmlinux []byte) {
+			// no updates listing: it is logged and skipped
+			dir := "https://archives.fedoraproject.org/pub/archive/fedora/linux/releases/31/Everything/x86_64/debug/tree/Packages/k/"
+			addRPMListing(t, srv, dir, "kernel-debuginfo-5.3.7-301.fc31.x86_64.rpm", "5.3.7-301.fc31.x86_64", vmlinux)
+		},
+	},
+	{
+		distro: "fedora", release: "31", arch: "s390x", kernel: "5.3.7-301.fc31.s390x",
+		setup: func(t *testing.T, srv *fakerepo.Server, _ *fakerepo.Tools, vmlinux []byte) {
+			dir := "https://archives.fedoraproject.org/pub/archive/fedora/linux/releases/31/Everything/s390x/debug/tree/Packages/k/"
+			addRPMListing(t, srv, dir, "kernel-debuginfo-5.3.7-301.fc31.s390x.rpm", "5.3.7-301.fc31.s390x", vmlinux)
+		},
+	},
+	{
+		distro: "centos", release: "8", arch: "ppc64le", kernel: "4.18.0-80.el8.ppc64le",
+		setup: func(t *testing.T, srv *fakerepo.Server, _ *fakerepo.Tools, vmlinux []byte) {
+			dir := "http://mirror.facebook.net/centos-debuginfo/8/ppc64le/Packages/"
+			addRPMListing(t, srv, dir, "kernel-debuginfo-4.18.0-80.el8.ppc64le.rpm", "4.18.0-80.el8.ppc64le", vmlinux)
+		},
+	},
+	{
+		distro: "centos", release: "8", arch: "x86_64", kernel: "4.18.0-80.el8.x86_64",
+		setup: func(t *testing.T, srv *fakerepo.Server, _ *fakerepo.Tools, vmlinux []byte) {
+			dir := "http://mirror.facebook.net/centos-debuginfo/8/x86_64/Packages/"
+			addRPMListing(t, srv, dir, "kernel-debuginfo-4.18.0-80.el8.x86_64.rpm", "4.18.0-80.el8.x86_64", vmlinux)
+		},
+	},
+	{
+		distro: "ol", release: "8", arch: "x86_64", kernel: "5.4.17-2136.300.7.el8uek.x86_64",
+		setup: func(t *testing.T, srv *fakerepo.Server, _ *fakerepo.Tools, vmlinux []byte) {
+			dir := "https://oss.oracle.com/ol8/debuginfo/"
+			addRPMListing(t, srv, dir, "kernel-uek-debuginfo-5.4.17-2136.300.7.el8uek.x86_64.rpm", "5.4.17-2136.300.7.el8uek.x86_64", vmlinux)
+		},
+	},
+	{
+		distro: "rhel", release: "8", arch: "x86_64", kernel: "4.18.0-80.el8.x86_64",
+		setup: func(t *testing.T, _ *fakerepo.Server, tools *fakerepo.Tools, vmlinux []byte) {
+			name := "kernel-debuginfo-4.18.0-80.el8.x86_64"
+			tools.Outputs["yum search"] = name + " : Debug information for package kernel\n"
+			tools.Packages[name] = writeRPM(t, name+".rpm", "4.18.0-80.el8.x86_64", vmlinux)
+		},
+	},
+	{
+		distro: "amzn", release: "2", arch: "x86_64", kernel: "4.14.301-224.520.amzn2.x86_64",
+		setup: func(t *testing.T, _ *fakerepo.Server, tools *fakerepo.Tools, vmlinux []byte) {
+			tools.Outputs["repoquery kernel-debuginfo"] = "kernel-debuginfo-0:4.14.301-224.520.amzn2.x86_64\n"
+			name := "kernel-debuginfo-4.14.301-224.520.amzn2.x86_64"
+			tools.Packages[name] = writeRPM(t, name+".rpm", "4.14.301-224.520.amzn2.x86_64", vmlinux)
+		},
+	},
+	{
+		distro: "sles", release: "15.3", arch: "x86_64", kernel: "5.3.18-150300.59.5-default",
+		setup: func(t *testing.T, _ *fakerepo.Server, tools *fakerepo.Tools, vmlinux []byte) {
+			alias := "Basesystem_Module_x86_64:SLE-Module-Basesystem15-SP3-Debuginfo-Pool"
+			name := "SLE-Module-Basesystem15-SP3-Debuginfo-Pool"
+			tools.Outputs["zypper repos"] = fmt.Sprintf("# | Alias | Name | Enabled\n1 | %s | %s | Yes\n", alias, name)
+			tools.Outputs["zypper search"] = fmt.Sprintf("  | kernel-default-debuginfo | package | 5.3.18-150300.59.5.1 | x86_64 | %s\n", name)
+			tools.Packages["kernel-default-debuginfo=5.3.18-150300.59.5.1"] = writeRPM(t,
+				"kernel-default-debuginfo-5.3.18-150300.59.5.1.x86_64.rpm", "5.3.18-150300.59.5-default", vmlinux)
+
+			zyppDir := repo.ZyppPackagesDir
+			repo.ZyppPackagesDir = t.TempDir()
+			t.Cleanup(func() { repo.ZyppPackagesDir = zyppDir })
+			tools.ZyppDir = filepath.Join(repo.ZyppPackagesDir, alias, "x86_64")
+		},
+	},
+}
+
+// addAPTRelease serves the main, updates and universe Packages indexes of the
+// release, with the given packages in main
+func addAPTRelease(srv *fakerepo.Server, repoURL string, release string, pkgs ...fakerepo.APTPackage) {
+	srv.AddAPTPackages(repoURL+"/dists/"+release+"/main/binary-amd64/Packages.xz", pkgs...)
+	srv.AddAPTPackages(repoURL + "/dists/" + release + "-updates/main/binary-amd64/Packages.xz")
+	srv.AddAPTPackages(repoURL + "/dists/" + release + "-updates/universe/binary-amd64/Packages.xz")
+}
+
+// addRPMListing serves a directory listing with a single kernel debuginfo rpm
+func addRPMListing(t *testing.T, srv *fakerepo.Server, dirURL string, name string, kernel string, vmlinux []byte) {
+	srv.AddListing(dirURL, "../", name)
+	srv.Add(dirURL+name, rpmWithVmlinux(t, kernel, vmlinux), "application/x-rpm")
+}
+
+// writeRPM writes a kernel debuginfo rpm to a file and returns its path
+func writeRPM(t *testing.T, name string, kernel string, vmlinux []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, rpmWithVmlinux(t, kernel, vmlinux), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// vmlinuxFor returns a fake vmlinux file of the kernel and architecture of the
+// fake distro
+func vmlinuxFor(d fakeDistro) []byte {
+	a, _ := kernel.GetArch(d.arch)
+	return fakerepo.VmlinuxFor(d.kernel, a.Machine, a.ByteOrder)
+}
+
+func rpmWithVmlinux(t *testing.T, kernel string, vmlinux []byte) []byte {
+	return fakerepo.RPM(t, map[string][]byte{
+		"./usr/lib/debug/lib/modules/" + kernel + "/vmlinux": vmlinux,
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	for _, d := range fakeDistros {
+		t.Run(d.distro+"/"+d.arch, func(t *testing.T) {
+			srv := fakerepo.NewServer(t)
+			tools := fakerepo.Tools{
+				Outputs:  map[string]string{},
+				Packages: map[string]string{},
+			}
+			d.setup(t, srv, &tools, vmlinuxFor(d))
+			fakerepo.InstallTools(t, tools)
+
+			dir := t.TempDir()
+			fs := newFlagSet(updateCmd)
+			err := fs.Parse([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir, "-j", "2"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := update(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(dir, d.distro, d.release, d.arch, d.kernel+archive.BTFExt)
+			data, err := archive.ReadBTF(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, _ := kernel.GetArch(d.arch)
+			if !bytes.Equal(data, fakerepo.BTFFor(a.ByteOrder)) {
+				t.Errorf("%s doesn't hold the BTF written by pahole", path)
+			}
+
+			m, err := archive.ReadManifest(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if me := m.Files[filepath.Base(path)]; me == nil || me.Pahole != fakerepo.PaholeVersion {
+				t.Errorf("%s: pahole version not recorded in the manifest", path)
+			}
+		})
+	}
+}
+
+func TestUpdateDryRunPlan(t *testing.T) {
+	d := fakeDistros[0] // ubuntu
+	srv := fakerepo.NewServer(t)
+	tools := fakerepo.Tools{}
+	d.setup(t, srv, &tools, fakerepo.Vmlinux(d.kernel))
+	fakerepo.InstallTools(t, tools)
+
+	ddebURL := "http://ddebs.ubuntu.com/pool/main/l/linux/linux-image-unsigned-5.4.0-42-generic-dbgsym_5.4.0-42.46_amd64.ddeb"
+	dir, planPath := t.TempDir(), filepath.Join(t.TempDir(), "plan.json")
+	btf := filepath.Join(dir, d.distro, d.release, d.arch, d.kernel+archive.BTFExt)
+
+	run := func(args ...string) {
+		fs := newFlagSet(updateCmd)
+		err := fs.Parse(append([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir}, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	planned := func() repo.PlanEntry {
+		b, err := os.ReadFile(planPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plans []*repo.Plan
+		if err := json.Unmarshal(b, &plans); err != nil {
+			t.Fatal(err)
+		}
+		if len(plans) != 1 || len(plans[0].Groups) != 1 || len(plans[0].Groups[0].Entries) != 1 {
+			t.Fatalf("plan: %s", b)
+		}
+		return *plans[0].Groups[0].Entries[0]
+	}
+
+	// new kernel: planned, nothing done
+
+	run("-dry-run", "-plan", planPath)
+
+	want := repo.PlanEntry{
+		Name:    "linux-image-unsigned-5.4.0-42-generic-dbgsym amd64",
+		Kernel:  d.kernel,
+		Flavor:  "generic",
+		Size:    20_000_000,
+		BTFPath: btf,
+		Action:  repo.ActionProcess,
+		Reason:  repo.ReasonNew,
+	}
+	if got := planned(); got != want {
+		t.Errorf("planned %+v, want %+v", got, want)
+	}
+	if des, err := os.ReadDir(dir); err != nil || len(des) != 0 {
+		t.Errorf("dry-run wrote %v in the archive (%v)", des, err)
+	}
+	if n := srv.Fetches(ddebURL); n != 0 {
+		t.Errorf("dry-run fetched the package %d times", n)
+	}
+
+	// BTF file generated: skipped
+
+	run()
+	run("-dry-run", "-plan", planPath)
+
+	want.Action, want.Reason = repo.ActionSkip, repo.ReasonExists
+	if got := planned(); got != want {
+		t.Errorf("planned %+v, want %+v", got, want)
+	}
+	if n := srv.Fetches(ddebURL); n != 1 {
+		t.Errorf("package fetched %d times", n)
+	}
+}
+
+func TestUpdateMetrics(t *testing.T) {
+	d := fakeDistros[0] // ubuntu
+	srv := fakerepo.NewServer(t)
+	tools := fakerepo.Tools{}
+	d.setup(t, srv, &tools, fakerepo.Vmlinux(d.kernel))
+	fakerepo.InstallTools(t, tools)
+
+	discovered := metrics.PackagesDiscovered.WithLabelValues(d.distro)
+	skipped := metrics.PackagesSkipped.WithLabelValues(d.distro, metrics.SkipExists)
+
+	dir := t.TempDir()
+	var downloaded []float64
+	for run := 0; run < 2; run++ {
+		before := []float64{
+			testutil.ToFloat64(discovered),
+			testutil.ToFloat64(skipped),
+			testutil.ToFloat64(metrics.BytesDownloaded),
+		}
+
+		fs := newFlagSet(updateCmd)
+		if err := fs.Parse([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir}); err != nil {
+			t.Fatal(err)
+		}
+		if err := update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// the package is discovered on both runs, and skipped on the second
+		// one
+
+		if n := testutil.ToFloat64(discovered) - before[0]; n != 1 {
+			t.Errorf("run %d: %v packages discovered", run, n)
+		}
+		if n := testutil.ToFloat64(skipped) - before[1]; n != float64(run) {
+			t.Errorf("run %d: %v packages skipped", run, n)
+		}
+		downloaded = append(downloaded, testutil.ToFloat64(metrics.BytesDownloaded)-before[2])
+	}
+
+	// the indexes are downloaded on both runs, the package on the first one
+	if downloaded[1] == 0 || downloaded[0] <= downloaded[1] {
+		t.Errorf("bytes downloaded: %v", downloaded)
+	}
+}
+
+func TestUpdateArchMismatch(t *testing.T) {
+	d := fakeDistros[slices.IndexFunc(fakeDistros, func(d fakeDistro) bool { return d.arch == "s390x" })]
+	srv := fakerepo.NewServer(t)
+	tools := fakerepo.Tools{}
+	d.setup(t, srv, &tools, fakerepo.Vmlinux(d.kernel)) // x86_64 vmlinux in an s390x package
+	fakerepo.InstallTools(t, tools)
+
+	dir := t.TempDir()
+	fs := newFlagSet(updateCmd)
+	err := fs.Parse([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, d.distro, d.release, d.arch, d.kernel+archive.BTFExt)
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("%s generated from an x86_64 vmlinux", path)
+	}
+}
+
+func TestUpdateReleaseMismatch(t *testing.T) {
+	// the separated debuginfo files have no banner: the release is checked
+	// against the path of the vmlinux file in the package
+
+	const kernel = "4.18.0-80.el8.x86_64"
+	srv := fakerepo.NewServer(t)
+	fakerepo.InstallTools(t, fakerepo.Tools{})
+	dir := "http://mirror.facebook.net/centos-debuginfo/8/x86_64/Packages/"
+	name := "kernel-debuginfo-" + kernel + ".rpm"
+	srv.AddListing(dir, "../", name)
+	srv.Add(dir+name, rpmWithVmlinux(t, "4.18.0-147.el8.x86_64", fakerepo.Vmlinux(kernel)), "application/x-rpm")
+
+	archiveDir := t.TempDir()
+	fs := newFlagSet(updateCmd)
+	err := fs.Parse([]string{"-distro", "centos", "-release", "8", "-arch", "x86_64", "-archive-dir", archiveDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(archiveDir, "centos", "8", "x86_64", kernel+archive.BTFExt)
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("%s generated from the vmlinux file of another release", path)
+	}
+}
+
+func TestUpdateCache(t *testing.T) {
+	d := fakeDistros[0] // ubuntu
+	srv := fakerepo.NewServer(t)
+	tools := fakerepo.Tools{}
+	d.setup(t, srv, &tools, fakerepo.Vmlinux(d.kernel))
+	fakerepo.InstallTools(t, tools)
+
+	cacheDir := t.TempDir()
+	run := func() string {
+		t.Helper()
+		dir := t.TempDir()
+		fs := newFlagSet(updateCmd)
+		err := fs.Parse([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir, "-cache-dir", cacheDir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return filepath.Join(dir, d.distro, d.release, d.arch, d.kernel+archive.BTFExt)
+	}
+
+	run()
+
+	// the package isn't downloaded again: a broken one would fail
+	srv.Add("http://ddebs.ubuntu.com/pool/main/l/linux/linux-image-unsigned-5.4.0-42-generic-dbgsym_5.4.0-42.46_amd64.ddeb",
+		[]byte("broken"), "application/octet-stream")
+
+	path := run()
+	data, err := archive.ReadBTF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, fakerepo.BTF()) {
+		t.Errorf("%s doesn't hold the BTF written by pahole", path)
+	}
+}
+
+func TestUpdateCacheReleases(t *testing.T) {
+	// the HWE kernel of bionic has the name of the focal kernel
+
+	const kernel = "5.4.0-42-generic"
+	versions := map[string]string{"focal": "5.4.0-42.46", "bionic": "5.4.0-42.46~18.04.1"}
+
+	for _, withSum := range []bool{false, true} {
+		t.Run(fmt.Sprintf("checksum=%t", withSum), func(t *testing.T) {
+			srv := fakerepo.NewServer(t)
+			fakerepo.InstallTools(t, fakerepo.Tools{})
+
+			ddebURLs := map[string]string{}
+			for release, version := range versions {
+				data := fakerepo.Deb(t, map[string][]byte{
+					"./usr/lib/debug/boot/vmlinux-" + kernel: fakerepo.Vmlinux(kernel),
+					"./usr/share/doc/" + release:             nil,
+				})
+				image := fakerepo.APTPackage{
+					Name:     "linux-image-" + kernel,
+					Version:  version,
+					Arch:     "amd64",
+					Filename: "pool/main/l/linux-signed/linux-image-" + kernel + "_" + version + "_amd64.deb",
+				}
+				ddeb := fakerepo.APTPackage{
+					Name:     "linux-image-unsigned-" + kernel + "-dbgsym",
+					Version:  version,
+					Arch:     "amd64",
+					Filename: "pool/main/l/linux/linux-image-unsigned-" + kernel + "-dbgsym_" + version + "_amd64.ddeb",
+					Size:     20_000_000,
+				}
+				if withSum {
+					ddeb.SHA256 = fmt.Sprintf("%x", sha256.Sum256(data))
+				}
+				addAPTRelease(srv, "http://archive.ubuntu.com/ubuntu", release, image)
+				addAPTRelease(srv, "http://ddebs.ubuntu.com", release, ddeb)
+				ddebURLs[release] = "http://ddebs.ubuntu.com/" + ddeb.Filename
+				srv.Add(ddebURLs[release], data, "application/octet-stream")
+			}
+
+			cacheDir := t.TempDir()
+			for _, release := range []string{"focal", "bionic"} {
+				fs := newFlagSet(updateCmd)
+				err := fs.Parse([]string{"-distro", "ubuntu", "-release", release, "-arch", "x86_64", "-archive-dir", t.TempDir(), "-cache-dir", cacheDir})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := update(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if n := srv.Fetches(ddebURLs[release]); n != 1 {
+					t.Errorf("%s package fetched %d times, expected once (not taken from the cache)", release, n)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateIndexCache(t *testing.T) {
+	d := fakeDistros[0] // ubuntu
+	srv := fakerepo.NewServer(t)
+	tools := fakerepo.Tools{}
+	d.setup(t, srv, &tools, fakerepo.Vmlinux(d.kernel))
+	fakerepo.InstallTools(t, tools)
+
+	var indexes []string
+	for _, repoURL := range []string{"http://archive.ubuntu.com/ubuntu", "http://ddebs.ubuntu.com"} {
+		srv.AddInRelease(repoURL+"/dists/focal", "main/binary-amd64/Packages.xz")
+		srv.AddInRelease(repoURL+"/dists/focal-updates", "main/binary-amd64/Packages.xz", "universe/binary-amd64/Packages.xz")
+		indexes = append(indexes,
+			repoURL+"/dists/focal/main/binary-amd64/Packages.xz",
+			repoURL+"/dists/focal-updates/main/binary-amd64/Packages.xz",
+			repoURL+"/dists/focal-updates/universe/binary-amd64/Packages.xz",
+		)
+	}
+
+	dir, indexCache := t.TempDir(), t.TempDir()
+	for run := 0; run < 2; run++ {
+		fs := newFlagSet(updateCmd)
+		err := fs.Parse([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir, "-index-cache-dir", indexCache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := archive.ReadBTF(filepath.Join(dir, d.distro, d.release, d.arch, d.kernel+archive.BTFExt)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the indexes are fetched once, by hash, and the InRelease files are not
+	// modified on the second run
+	for _, index := range indexes {
+		if n := srv.Fetches(index); n != 0 {
+			t.Errorf("%s fetched %d times", index, n)
+		}
+	}
+	if n := srv.Fetches("http://archive.ubuntu.com/ubuntu/dists/focal/InRelease"); n != 1 {
+		t.Errorf("InRelease fetched %d times", n)
+	}
+}
+
+func TestUpdateIndexPdiff(t *testing.T) {
+	d := fakeDistros[0] // ubuntu
+	srv := fakerepo.NewServer(t)
+	tools := fakerepo.Tools{}
+	d.setup(t, srv, &tools, fakerepo.Vmlinux(d.kernel))
+	fakerepo.InstallTools(t, tools)
+
+	ddeb := fakerepo.APTPackage{
+		Name:     "linux-image-unsigned-5.4.0-42-generic-dbgsym",
+		Version:  "5.4.0-42.46",
+		Arch:     "amd64",
+		Filename: "pool/main/l/linux/linux-image-unsigned-5.4.0-42-generic-dbgsym_5.4.0-42.46_amd64.ddeb",
+		Size:     20_000_000,
+	}
+	suiteURL := "http://ddebs.ubuntu.com/dists/focal"
+	index := "main/binary-amd64/Packages"
+
+	// the InRelease files list the uncompressed indexes too, and the debug
+	// package is not published yet
+
+	srv.AddAPTPackages(suiteURL + "/" + index + ".xz")
+	for _, repoURL := range []string{"http://archive.ubuntu.com/ubuntu", "http://ddebs.ubuntu.com"} {
+		srv.AddInRelease(repoURL+"/dists/focal", index, index+".xz")
+		srv.AddInRelease(repoURL+"/dists/focal-updates", index, index+".xz",
+			"universe/binary-amd64/Packages", "universe/binary-amd64/Packages.xz")
+	}
+
+	dir, indexCache := t.TempDir(), t.TempDir()
+	btf := filepath.Join(dir, d.distro, d.release, d.arch, d.kernel+archive.BTFExt)
+	run := func() {
+		fs := newFlagSet(updateCmd)
+		err := fs.Parse([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir, "-index-cache-dir", indexCache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run()
+	if _, err := os.Stat(btf); err == nil {
+		t.Fatal("BTF file generated without debug package")
+	}
+
+	// the debug package is published, with a pdiff of the index
+
+	srv.AddAPTPackages(suiteURL+"/"+index+".xz", ddeb)
+	srv.AddAPTPdiff(suiteURL + "/" + index) // from the empty index
+	srv.AddInRelease(suiteURL, index, index+".xz", index+".diff/Index")
+
+	run()
+	if _, err := archive.ReadBTF(btf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index is patched, not fetched again
+	for _, url := range []string{srv.ByHashURL(suiteURL, index+".xz"), suiteURL + "/" + index + ".xz", suiteURL + "/" + index} {
+		if n := srv.Fetches(url); n != 0 {
+			t.Errorf("%s fetched %d times", url, n)
+		}
+	}
+	if n := srv.Fetches(srv.ByHashURL(suiteURL, index+".diff/Index")); n != 1 {
+		t.Errorf("pdiff index fetched %d times", n)
+	}
+}
+
+func TestUpdateDedup(t *testing.T) {
+	d := fakeDistros[0] // ubuntu
+
+	// the btf.zst files are links to the store blobs, and a BTF file reused
+	// from the store has the toolchain of the blob; the OCI image layouts
+	// (no dedup) have their toolchain recorded too
+
+	tests := []struct {
+		format string
+		dedup  bool
+	}{
+		{"btf.zst", true},
+		{"oci", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			srv := fakerepo.NewServer(t)
+			tools := fakerepo.Tools{}
+			d.setup(t, srv, &tools, fakerepo.Vmlinux(d.kernel))
+			fakerepo.InstallTools(t, tools)
+
+			dir := t.TempDir()
+			enc, err := output.New(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, d.distro, d.release, d.arch, d.kernel+enc.Ext())
+			sum := fmt.Sprintf("%x", sha256.Sum256(fakerepo.BTF()))
+
+			for _, force := range []bool{false, true} { // generated, then reused
+				args := []string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", dir, "-output", tt.format}
+				if tt.dedup {
+					args = append(args, "-dedup")
+				}
+				if force {
+					args = append(args, "-f")
+				}
+				fs := newFlagSet(updateCmd)
+				if err := fs.Parse(args); err != nil {
+					t.Fatal(err)
+				}
+				if err := update(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+
+				m, err := archive.ReadManifest(filepath.Dir(path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				me := m.Files[filepath.Base(path)]
+				if me == nil || me.Pahole != fakerepo.PaholeVersion || tt.dedup && me.BTF != sum {
+					t.Fatalf("%s %v: manifest entry %+v", path, args, me)
+				}
+			}
+			if !tt.dedup {
+				return
+			}
+			if fi, err := os.Lstat(path); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("%s isn't a link to the store", path)
+			}
+			if _, err := os.Stat(archive.NewStore(dir).BlobPath(sum)); err != nil {
+				t.Errorf("BTF not stored: %s", err)
+			}
+		})
+	}
+
+	// in other formats, every BTF file would be stored twice
+
+	fs := newFlagSet(updateCmd)
+	if err := fs.Parse([]string{"-distro", d.distro, "-release", d.release, "-arch", d.arch, "-archive-dir", t.TempDir(), "-dedup"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := update(context.Background()); err == nil {
+		t.Error("dedup of tar.xz files: no error")
+	}
+}
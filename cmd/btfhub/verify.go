@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+var verifyCmd = &command{
+	name:  "verify",
+	short: "check the integrity of the archived BTF files",
+	run:   runVerify,
+}
+
+func runVerify(ctx context.Context, _ []string) error {
+	dirs, err := archiveDirs()
+	if err != nil {
+		return err
+	}
+
+	var checked, found int
+	for _, d := range dirs {
+		entries, err := d.Entries()
+		if err != nil {
+			return err
+		}
+		problems, err := d.Verify(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			log.Printf("ERROR: %s\n", p)
+		}
+		checked += len(entries)
+		found += len(problems)
+	}
+
+	log.Printf("INFO: verified %d BTF files in %d directories, %d problems\n", checked, len(dirs), found)
+	if found > 0 {
+		return fmt.Errorf("%d problems found", found)
+	}
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Extensions of the BTF files kept in the archive, one per output format (see
+// the output package). An OCI output is an image layout directory.
+const (
+	BTFExt  = ".btf.tar.xz" // the archive format, and the default one
+	ZstdExt = ".btf.zst"
+	OCIExt  = ".oci"
+	RawExt  = ".btf"
+)
+
+// OutputExts are the extensions of all the output formats, in lookup order.
+// The raw format comes last: older versions left uncompressed .btf files
+// behind, next to the .btf.tar.xz ones.
+var OutputExts = []string{BTFExt, ZstdExt, OCIExt, RawExt}
+
+// PartialBTFExt is the extension of the BTF files generated by pahole, before
+// they are written in the output format
+const PartialBTFExt = ".btf.part"
+
+// ModulesExt is the extension of the directories, next to the BTF files,
+// holding the split BTF files of the kernel modules (generated against the
+// BTF of the kernel): <kernel>.modules/<module>.btf.tar.xz
+const ModulesExt = ".modules"
+
+// ModulesScratchPrefix is the prefix of the directories the kernel modules
+// are extracted to, before their BTF files are generated
+const ModulesScratchPrefix = "modules-"
+
+// Extensions of the marker files kept next to the BTF files
+const (
+	HasBTFExt = ".hasbtf"
+	FailedExt = ".failed"
+)
+
+// Dir is an archive directory holding the BTF files of a distribution
+// release and architecture: <root>/<distro>/<release>/<arch>
+type Dir struct {
+	Distro  string `json:"distro"`
+	Release string `json:"release"`
+	Arch    string `json:"arch"`
+	Path    string `json:"-"`
+}
+
+func (d Dir) String() string {
+	return fmt.Sprintf("%s/%s/%s", d.Distro, d.Release, d.Arch)
+}
+
+// Entry is a BTF file kept in the archive
+type Entry struct {
+	Dir
+	Kernel  string    `json:"kernel"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+}
+
+// Dirs returns the archive directories found under root, optionally
+// restricted to the given distro, release and arch (empty matches all).
+func Dirs(root string, distro string, release string, arch string) ([]Dir, error) {
+	var dirs []Dir
+
+	distros, err := subdirs(root, distro)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range distros {
+		releases, err := subdirs(filepath.Join(root, d), release)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range releases {
+			archs, err := subdirs(filepath.Join(root, d, r), arch)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range archs {
+				dirs = append(dirs, Dir{
+					Distro:  d,
+					Release: r,
+					Arch:    a,
+					Path:    filepath.Join(root, d, r, a),
+				})
+			}
+		}
+	}
+
+	return dirs, nil
+}
+
+// subdirs returns the sorted names of the (non hidden) directories inside dir,
+// or only the given name if it is a directory inside dir. Symbolic links to
+// directories are followed.
+func subdirs(dir string, name string) ([]string, error) {
+	if name != "" {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil || !fi.IsDir() {
+			return nil, nil
+		}
+		return []string{name}, nil
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %s", err)
+	}
+
+	var names []string
+	for _, de := range des {
+		if strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(dir, de.Name()))
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		names = append(names, de.Name())
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Entries returns the BTF files of the directory sorted by kernel name (and
+// by output format, in the OutputExts order, for a kernel in several formats)
+func (d Dir) Entries() ([]Entry, error) {
+	entries, _, err := d.scan()
+	return entries, err
+}
+
+// Lookup returns the BTF file of the given kernel release (uname -r), in the
+// first output format found (see OutputExts)
+func (d Dir) Lookup(kernel string) (Entry, bool) {
+	for _, ext := range OutputExts {
+		p := filepath.Join(d.Path, kernel+ext)
+		fi, err := os.Stat(p)
+		if err != nil || fi.IsDir() != (ext == OCIExt) {
+			continue
+		}
+		return newEntry(d, kernel, p, fi), true
+	}
+	return Entry{}, false
+}
+
+// scan sorts the files of the directory out into BTF files and intermediate
+// files. A raw .btf file next to a BTF file of the same kernel in another
+// format is a leftover of older versions, unless listed in the manifest.
+func (d Dir) scan() ([]Entry, []string, error) {
+	des, err := os.ReadDir(d.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read dir: %s", err)
+	}
+
+	var entries, raw []Entry
+	var leftovers []string
+	for _, de := range des {
+		p := filepath.Join(d.Path, de.Name())
+		if IsLeftover(de.Name()) || (de.IsDir() && strings.HasPrefix(de.Name(), ModulesScratchPrefix)) {
+			leftovers = append(leftovers, p)
+			continue
+		}
+		kernel, ext, ok := outputKernel(de.Name())
+		if !ok {
+			continue
+		}
+		fi, err := os.Stat(p) // symbolic links (to blobs) are followed
+		if err != nil || fi.IsDir() != (ext == OCIExt) {
+			continue
+		}
+		if ext == RawExt {
+			raw = append(raw, newEntry(d, kernel, p, fi))
+		} else {
+			entries = append(entries, newEntry(d, kernel, p, fi))
+		}
+	}
+
+	if len(raw) > 0 {
+		archived := make(map[string]bool)
+		for _, e := range entries {
+			archived[e.Kernel] = true
+		}
+		var m *Manifest
+		for _, e := range raw {
+			if archived[e.Kernel] {
+				if m == nil {
+					if m, err = ReadManifest(d.Path); err != nil {
+						return nil, nil, err
+					}
+				}
+				if m.Files[filepath.Base(e.Path)] == nil {
+					leftovers = append(leftovers, e.Path)
+					continue
+				}
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kernel != entries[j].Kernel {
+			return entries[i].Kernel < entries[j].Kernel
+		}
+		return entries[i].format() < entries[j].format()
+	})
+	sort.Strings(leftovers)
+
+	return entries, leftovers, nil
+}
+
+// outputKernel returns the kernel name and the output format extension of the
+// name of a BTF file
+func outputKernel(name string) (string, string, bool) {
+	if strings.HasPrefix(name, ".") {
+		return "", "", false
+	}
+	for _, ext := range OutputExts {
+		if kernel, ok := strings.CutSuffix(name, ext); ok && kernel != "" {
+			return kernel, ext, true
+		}
+	}
+	return "", "", false
+}
+
+// format returns the index of the output format of the entry in OutputExts
+func (e Entry) format() int {
+	_, ext, _ := outputKernel(filepath.Base(e.Path))
+	return slices.Index(OutputExts, ext)
+}
+
+func newEntry(d Dir, kernel string, path string, fi os.FileInfo) Entry {
+	size := fi.Size()
+	if fi.IsDir() {
+		size = int64(diskUsage(path))
+	}
+	return Entry{
+		Dir:     d,
+		Kernel:  kernel,
+		Path:    path,
+		Size:    size,
+		ModTime: fi.ModTime(),
+	}
+}
+
+// Markers returns the kernel names of the marker files with the given
+// extension (HasBTFExt or FailedExt)
+func (d Dir) Markers(ext string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(d.Path, "*"+ext))
+	if err != nil {
+		return nil, err
+	}
+	var kernels []string
+	for _, m := range matches {
+		kernels = append(kernels, strings.TrimSuffix(filepath.Base(m), ext))
+	}
+	return kernels, nil
+}
+
+// Leftovers returns the intermediate files (downloaded packages, extracted
+// vmlinux files, extracted kernel modules, temporary and uncompressed BTF
+// files) left behind in the directory
+func (d Dir) Leftovers() ([]string, error) {
+	_, leftovers, err := d.scan()
+	return leftovers, err
+}
+
+// TempPath returns the path of the temporary file used to write the given
+// archive file before renaming it into place
+func TempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+}
+
+// IsLeftover returns true if the given file name is an intermediate file of
+// the BTF generation (raw .btf files of older versions aside, see Leftovers)
+func IsLeftover(name string) bool {
+	switch {
+	case strings.HasPrefix(name, "vmlinux-"):
+		return true
+	case strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp"):
+		_, _, ok := outputKernel(strings.TrimSuffix(name[1:], ".tmp"))
+		return ok // temporary output (a directory for OCI)
+	case strings.HasSuffix(name, ".ddeb"),
+		strings.HasSuffix(name, ".deb"),
+		strings.HasSuffix(name, ".rpm"),
+		strings.HasSuffix(name, CachedPackageExt),
+		strings.HasSuffix(name, PartialBTFExt):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestEntries(t *testing.T) {
+	d := Dir{Distro: "ubuntu", Release: "focal", Arch: "x86_64", Path: t.TempDir()}
+
+	for _, f := range []string{"5.4.0-1" + BTFExt, "5.4.0-1" + RawExt, "5.4.0-2" + ZstdExt, "5.4.0-3" + RawExt, "5.4.0-4" + OCIExt} {
+		if err := os.WriteFile(filepath.Join(d.Path, f), nil, 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(d.Path, "5.4.0-5"+OCIExt), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := d.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, filepath.Base(e.Path))
+	}
+	// 5.4.0-1.btf is a leftover, and 5.4.0-4.oci not a directory
+	expected := []string{"5.4.0-1" + BTFExt, "5.4.0-2" + ZstdExt, "5.4.0-3" + RawExt, "5.4.0-5" + OCIExt}
+	if !slices.Equal(names, expected) {
+		t.Errorf("entries %v, expected %v", names, expected)
+	}
+
+	for kernel, name := range map[string]string{"5.4.0-1": "5.4.0-1" + BTFExt, "5.4.0-2": "5.4.0-2" + ZstdExt, "5.4.0-5": "5.4.0-5" + OCIExt, "5.4.0-4": ""} {
+		e, ok := d.Lookup(kernel)
+		if ok != (name != "") || (ok && filepath.Base(e.Path) != name) {
+			t.Errorf("lookup %s: %q, expected %q", kernel, e.Path, name)
+		}
+	}
+
+	// a raw BTF file listed in the manifest is archived along the tarball
+	m := &Manifest{Files: map[string]*ManifestEntry{"5.4.0-1" + RawExt: {}}}
+	if err := m.Write(d.Path); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = d.Entries(); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 || entries[0].Kernel != "5.4.0-1" || entries[1].Kernel != "5.4.0-1" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
@@ -0,0 +1,133 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataDog/zstd"
+	fastxz "github.com/therootcompany/xz"
+)
+
+// ReadBTF returns the BTF data of an archive file, decompressing it according
+// to its extension (.btf.tar.xz, .btf.zst, .oci image layout, or anything else
+// read as it is)
+func ReadBTF(path string) ([]byte, error) {
+	if strings.HasSuffix(path, OCIExt) {
+		return readOCI(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch {
+	case strings.HasSuffix(path, BTFExt):
+		xr, err := fastxz.NewReader(f, 0)
+		if err != nil {
+			return nil, fmt.Errorf("xz reader: %s", err)
+		}
+		tr := tar.NewReader(xr)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil, fmt.Errorf("no BTF file in %s", path)
+				}
+				return nil, fmt.Errorf("tar reader next: %s", err)
+			}
+			if hdr.Typeflag == tar.TypeReg && strings.HasSuffix(hdr.Name, ".btf") {
+				return io.ReadAll(tr)
+			}
+		}
+	case strings.HasSuffix(path, ZstdExt):
+		zr := zstd.NewReader(f)
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+
+	return io.ReadAll(f)
+}
+
+// ExtractBTF writes the BTF data of an archive file (see ReadBTF) to out
+func ExtractBTF(path string, out string) error {
+	data, err := ReadBTF(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, data, 0664)
+}
+
+// readOCI returns the BTF data of an OCI image layout directory, the single
+// (zstd compressed) layer of its single manifest
+func readOCI(dir string) ([]byte, error) {
+	var index struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	if err := readOCIJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) != 1 {
+		return nil, fmt.Errorf("%s: expected a single manifest, found %d", dir, len(index.Manifests))
+	}
+
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	p, err := ociBlobPath(dir, index.Manifests[0].Digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := readOCIJSON(p, &manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("%s: expected a single layer, found %d", dir, len(manifest.Layers))
+	}
+
+	if p, err = ociBlobPath(dir, manifest.Layers[0].Digest); err != nil {
+		return nil, err
+	}
+	layer, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := zstd.Decompress(nil, layer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: zstd: %s", p, err)
+	}
+
+	return data, nil
+}
+
+// ociBlobPath returns the path of the blob with the given digest
+// (sha256:<hex>) inside an OCI image layout directory
+func ociBlobPath(dir string, digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || sum == "" || strings.ContainsAny(sum, `/\.`) {
+		return "", fmt.Errorf("%s: invalid digest %q", dir, digest)
+	}
+	return filepath.Join(dir, "blobs", "sha256", sum), nil
+}
+
+func readOCIJSON(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// CachedPackageExt is the extension of the packages taken out of the cache
+// for an extraction
+const CachedPackageExt = ".cached-pkg"
+
+// PackageCache is a content addressed cache of the downloaded kernel packages,
+// shared by all the distributions, runs and archives, so regenerating the BTF
+// files doesn't download the packages again:
+//
+//	blobs/sha256/<package sha256>
+//	keys/sha256/<key sha256> (holds the package checksum)
+//
+// The packages are keyed by their checksum if known before the download (see
+// ChecksumKey), or else by distribution, release, name and version. The total
+// size of the blobs is kept under MaxSize (if not zero) by evicting the least
+// recently used ones (the modification time of a blob is its last use).
+type PackageCache struct {
+	Root    string
+	MaxSize int64
+
+	mu sync.Mutex // serializes the evictions
+}
+
+// NewPackageCache returns the package cache kept in the given directory
+func NewPackageCache(root string, maxSize int64) *PackageCache {
+	return &PackageCache{Root: root, MaxSize: maxSize}
+}
+
+// checksumKeyPrefix prefixes the keys naming the checksum of the package
+const checksumKeyPrefix = "sha256:"
+
+// ChecksumKey returns the key of a package of the given sha256 checksum. A
+// package put under such a key must have that checksum.
+func ChecksumKey(sum string) string {
+	return checksumKeyPrefix + sum
+}
+
+func (c *PackageCache) blobPath(sum string) string {
+	return filepath.Join(c.Root, "blobs", "sha256", sum)
+}
+
+func (c *PackageCache) keyPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.Root, "keys", "sha256", hex.EncodeToString(sum[:]))
+}
+
+// Get writes the package cached under the given key to dest (as a hard link
+// if possible, so dest must only be read), and reports whether it was cached.
+// A blob that doesn't match its checksum is removed, and is a miss.
+func (c *PackageCache) Get(key string, dest string) (bool, error) {
+	b, err := os.ReadFile(c.keyPath(key))
+	if err != nil {
+		return false, nil
+	}
+	want := strings.TrimSpace(string(b))
+	blob := c.blobPath(want)
+
+	sum, err := utils.FileSHA256(blob)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil // evicted
+		}
+		return false, err
+	}
+	if sum != want {
+		os.Remove(blob)
+		return false, fmt.Errorf("cached package %s is corrupted (checksum %s), removed", want, sum)
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(blob, now, now); err != nil {
+		return false, err
+	}
+	if err := linkOrCopy(blob, dest); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Put adds a copy of the package file to the cache under the given key (a
+// link would let the writes to the file, e.g. a download again, change the
+// blob), and evicts the least recently used packages if the cache is over its
+// size
+func (c *PackageCache) Put(key string, path string) error {
+	sum, err := utils.FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if want, ok := strings.CutPrefix(key, checksumKeyPrefix); ok && want != sum {
+		return fmt.Errorf("package checksum %s doesn't match %s", sum, want)
+	}
+
+	blob := c.blobPath(sum)
+	if _, err := os.Stat(blob); err != nil {
+		if err := os.MkdirAll(filepath.Dir(blob), 0775); err != nil {
+			return err
+		}
+		// through a temporary file of its own: the cache is shared by the
+		// concurrent runs, which may put the same package
+		f, err := utils.CreateTemp(blob)
+		if err != nil {
+			return err
+		}
+		tmp := f.Name()
+		err = copyTo(f, path)
+		if err == nil {
+			err = os.Chmod(tmp, 0664)
+		}
+		if err == nil {
+			err = os.Rename(tmp, blob)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	now := time.Now()
+	if err := os.Chtimes(blob, now, now); err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomic(c.keyPath(key), []byte(sum+"\n")); err != nil {
+		return err
+	}
+
+	return c.evict()
+}
+
+// evict removes the least recently used blobs until the cache fits in MaxSize
+// (the keys of the removed blobs are misses from then on)
+func (c *PackageCache) evict() error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type blob struct {
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+
+	var blobs []blob
+	var total int64
+
+	err := filepath.WalkDir(filepath.Join(c.Root, "blobs"), func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() || strings.HasSuffix(de.Name(), ".tmp") {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, blob{path: path, size: fi.Size(), lastUse: fi.ModTime()})
+		total += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].lastUse.Before(blobs[j].lastUse)
+	})
+
+	for _, b := range blobs {
+		if total <= c.MaxSize {
+			break
+		}
+		if err := os.Remove(b.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		total -= b.size
+	}
+
+	return nil
+}
+
+// linkOrCopy hard links the file to dest, or copies it if it can't be linked
+// (e.g. another file system)
+func linkOrCopy(src string, dest string) error {
+	os.Remove(dest)
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	return copyFile(src, dest)
+}
+
+// copyFile copies the file to dest
+func copyFile(src string, dest string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	return copyTo(out, src)
+}
+
+// copyTo copies the file to out, and closes out
+func copyTo(out *os.File, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	defer in.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
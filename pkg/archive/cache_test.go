@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+func TestPackageCache(t *testing.T) {
+	c := NewPackageCache(t.TempDir(), 10)
+	dir := t.TempDir()
+
+	put := func(key string, data string, lastUse time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, "pkg.rpm")
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Put(key, path); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(path)
+		// make the use order deterministic
+		sum, _ := os.ReadFile(c.keyPath(key))
+		os.Chtimes(c.blobPath(string(bytes.TrimSpace(sum))), lastUse, lastUse)
+	}
+	get := func(key string) (string, bool) {
+		t.Helper()
+		dest := filepath.Join(dir, filepath.Base(key)+CachedPackageExt)
+		ok, err := c.Get(key, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return "", false
+		}
+		data, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), true
+	}
+
+	if _, ok := get("centos/a"); ok {
+		t.Fatal("empty cache hit")
+	}
+
+	now := time.Now()
+	put("centos/a", "aaaa", now.Add(-3*time.Hour))
+	put("ol/a", "aaaa", now.Add(-3*time.Hour)) // same blob
+	put("centos/b", "bbbb", now.Add(-2*time.Hour))
+	if data, ok := get("ol/a"); !ok || data != "aaaa" {
+		t.Fatalf("ol/a: %q %t", data, ok)
+	}
+
+	// 12 bytes > 10: b is the least recently used (a was just used)
+
+	put("centos/c", "cccc", now.Add(-time.Hour))
+	if _, ok := get("centos/b"); ok {
+		t.Error("centos/b not evicted")
+	}
+	for _, key := range []string{"centos/a", "centos/c"} {
+		if _, ok := get(key); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+}
+
+func TestPackageCacheChecksumKey(t *testing.T) {
+	c := NewPackageCache(t.TempDir(), 0)
+	path := filepath.Join(t.TempDir(), "pkg.deb")
+	if err := os.WriteFile(path, []byte("aaaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := utils.FileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(ChecksumKey(strings.Repeat("0", 64)), path); err == nil {
+		t.Error("package put under the checksum of another one")
+	}
+	if err := c.Put(ChecksumKey(sum), path); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Get(ChecksumKey(sum), filepath.Join(t.TempDir(), "pkg"+CachedPackageExt)); !ok || err != nil {
+		t.Errorf("checksum key miss: %v", err)
+	}
+}
+
+func TestPackageCacheCorruption(t *testing.T) {
+	c := NewPackageCache(t.TempDir(), 0)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pkg.rpm")
+	if err := os.WriteFile(path, []byte("aaaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("centos/a", path); err != nil {
+		t.Fatal(err)
+	}
+
+	// downloading again into the same file doesn't change the cached package
+
+	if err := os.WriteFile(path, []byte("aa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "a"+CachedPackageExt)
+	if ok, err := c.Get("centos/a", dest); !ok || err != nil {
+		t.Fatalf("miss: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "aaaa" {
+		t.Fatalf("cached package changed to %q", data)
+	}
+
+	// a corrupted blob is a miss, and removed
+
+	sum, _ := os.ReadFile(c.keyPath("centos/a"))
+	blob := c.blobPath(string(bytes.TrimSpace(sum)))
+	os.Remove(dest) // the link to the blob
+	if err := os.WriteFile(blob, []byte("bad"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Get("centos/a", dest); ok || err == nil {
+		t.Errorf("corrupted package served: %t %v", ok, err)
+	}
+	if _, err := os.Stat(blob); err == nil {
+		t.Error("corrupted blob not removed")
+	}
+}
+
+func TestPackageCacheConcurrent(t *testing.T) {
+	c := NewPackageCache(t.TempDir(), 0)
+	path := filepath.Join(t.TempDir(), "pkg.rpm")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("package"), 100000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent runs putting the same package
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.Put("centos/a", path)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if ok, err := c.Get("centos/a", filepath.Join(t.TempDir(), "a"+CachedPackageExt)); !ok || err != nil {
+		t.Errorf("miss: %v", err)
+	}
+}
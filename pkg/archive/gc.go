@@ -0,0 +1,173 @@
+package archive
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ZyppCacheDir is where zypper keeps the downloaded SUSE packages
+const ZyppCacheDir = "/var/cache/zypp/packages"
+
+// Stale returns the intermediate files of the directory that belong to a
+// kernel whose BTF file is already archived in final (the directory itself,
+// unless a separate scratch directory is used), or that are older than maxAge
+// (if not zero).
+func (d Dir) Stale(final Dir, maxAge time.Duration) ([]string, error) {
+	var entries []Entry
+	if _, err := os.Stat(final.Path); err == nil {
+		if entries, err = final.Entries(); err != nil {
+			return nil, err
+		}
+	}
+	leftovers, err := d.Leftovers()
+	if err != nil {
+		return nil, err
+	}
+	if d.Path != final.Path {
+		// nothing is archived in a scratch directory: its raw .btf files
+		// were left behind by older versions
+		scratch, err := d.Entries()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range scratch {
+			if strings.HasSuffix(e.Path, RawExt) {
+				leftovers = append(leftovers, e.Path)
+			}
+		}
+	}
+
+	var stale []string
+	for _, l := range leftovers {
+		if archived(filepath.Base(l), entries) || olderThan(l, maxAge) {
+			stale = append(stale, l)
+		}
+	}
+
+	return stale, nil
+}
+
+// StaleCache returns the packages kept in a package manager cache directory
+// (e.g. ZyppCacheDir) that are older than maxAge. A missing directory holds no
+// stale packages.
+func StaleCache(dir string, maxAge time.Duration) ([]string, error) {
+	if maxAge == 0 {
+		return nil, nil
+	}
+
+	var stale []string
+	err := filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if de.IsDir() || !strings.HasSuffix(de.Name(), ".rpm") {
+			return nil
+		}
+		if olderThan(path, maxAge) {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+
+	return stale, err
+}
+
+// Remove removes the given files (or directories) and returns how many were
+// removed and the space reclaimed. Files that can't be removed are returned
+// as errors.
+func Remove(files []string) (int, uint64, []error) {
+	var removed int
+	var reclaimed uint64
+	var errs []error
+
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			continue // already gone
+		}
+		size := diskUsage(f)
+		if err := os.RemoveAll(f); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+		reclaimed += size
+	}
+
+	return removed, reclaimed, errs
+}
+
+// diskUsage returns the size of the file, or of the files inside the directory
+func diskUsage(path string) uint64 {
+	var size uint64
+	filepath.WalkDir(path, func(_ string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if fi, err := de.Info(); err == nil && fi.Mode().IsRegular() {
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	return size
+}
+
+// archived returns true if the intermediate file belongs to a kernel whose BTF
+// file is archived
+func archived(name string, entries []Entry) bool {
+	kernel, pkg := leftoverKernel(name)
+	if kernel == "" {
+		return false
+	}
+	for _, e := range entries {
+		if kernel == e.Kernel || (pkg && strings.HasSuffix(kernel, "-"+e.Kernel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// leftoverKernel returns the kernel name of an intermediate file, or, for a
+// downloaded package, its name (<kernel>, <package>-<kernel> or, for the
+// launchpad ones, <package>-<kernel>-dbgsym_<version>_<arch>) without the
+// extension, ending with the kernel name
+func leftoverKernel(name string) (string, bool) {
+	switch {
+	case strings.HasPrefix(name, "vmlinux-"):
+		return strings.TrimPrefix(name, "vmlinux-"), false
+	case strings.HasPrefix(name, ModulesScratchPrefix):
+		return strings.TrimPrefix(name, ModulesScratchPrefix), false
+	case strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp"):
+		kernel, _, _ := outputKernel(strings.TrimSuffix(name[1:], ".tmp"))
+		return kernel, false
+	case strings.HasSuffix(name, PartialBTFExt):
+		return strings.TrimSuffix(name, PartialBTFExt), false
+	case strings.HasSuffix(name, RawExt):
+		return strings.TrimSuffix(name, RawExt), false
+	}
+
+	for _, ext := range []string{".ddeb", ".deb", ".rpm"} {
+		if stem, ok := strings.CutSuffix(name, ext); ok {
+			stem, _, _ = strings.Cut(stem, "_")
+			return strings.TrimSuffix(stem, "-dbgsym"), true
+		}
+	}
+	return "", false
+}
+
+func olderThan(path string, maxAge time.Duration) bool {
+	if maxAge == 0 {
+		return false
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return time.Since(fi.ModTime()) > maxAge
+}
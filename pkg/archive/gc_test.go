@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStale(t *testing.T) {
+	d := Dir{Distro: "ubuntu", Release: "focal", Arch: "x86_64", Path: t.TempDir()}
+
+	files := []string{
+		"5.4.0-1" + BTFExt, // archived
+		"vmlinux-5.4.0-1",
+		"vmlinux-5.4.0-100-generic",
+		"kernel-debuginfo-5.4.0-1.rpm",
+		"linux-image-unsigned-5.4.0-1-dbgsym_5.4.0-1.1_amd64.ddeb",
+		"linux-image-unsigned-5.4.0-100-generic-dbgsym_5.4.0-100.113_amd64.ddeb",
+		".5.4.0-1" + BTFExt + ".tmp",
+		"5.4.0-100-generic" + PartialBTFExt,
+		"5.4.0-1" + RawExt,  // left behind by older versions
+		"5.4.0-2" + ZstdExt, // archived
+		".5.4.0-2" + ZstdExt + ".tmp",
+		"5.4.0-3" + RawExt, // archived
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(d.Path, f), nil, 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{ModulesScratchPrefix + "5.4.0-1", ModulesScratchPrefix + "5.4.0-100-generic", "5.4.0-4" + OCIExt, ".5.4.0-4" + OCIExt + ".tmp"} {
+		if err := os.Mkdir(filepath.Join(d.Path, dir), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale, err := d.Stale(d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range stale {
+		names = append(names, filepath.Base(s))
+	}
+	slices.Sort(names)
+
+	expected := []string{
+		".5.4.0-1" + BTFExt + ".tmp",
+		".5.4.0-2" + ZstdExt + ".tmp",
+		".5.4.0-4" + OCIExt + ".tmp",
+		"5.4.0-1" + RawExt,
+		"kernel-debuginfo-5.4.0-1.rpm",
+		"linux-image-unsigned-5.4.0-1-dbgsym_5.4.0-1.1_amd64.ddeb",
+		ModulesScratchPrefix + "5.4.0-1",
+		"vmlinux-5.4.0-1",
+	}
+	if !slices.Equal(names, expected) {
+		t.Errorf("stale %v, expected %v", names, expected)
+	}
+}
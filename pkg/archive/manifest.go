@@ -0,0 +1,148 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// ManifestName is the name of the manifest file kept in each archive directory
+const ManifestName = "manifest.json"
+
+// Manifest lists the checksums of the BTF files of an archive directory
+type Manifest struct {
+	Files map[string]*ManifestEntry `json:"files"` // map[file name]entry
+}
+
+// ManifestEntry describes a BTF file of an archive directory (an OCI image
+// layout directory has no checksum and size)
+type ManifestEntry struct {
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	BTF    string `json:"btf,omitempty"` // checksum of the BTF file (see Store)
+
+	// toolchain the BTF file was generated with (unknown if empty)
+	Pahole      string   `json:"pahole,omitempty"`       // version, like v1.25
+	PaholeFlags []string `json:"pahole_flags,omitempty"` // encoding flags
+}
+
+// manifestMu serializes the manifest updates of RecordFile
+var manifestMu sync.Mutex
+
+// ReadManifest reads the manifest of the given archive directory (an empty
+// manifest is returned if it does not exist)
+func ReadManifest(dir string) (*Manifest, error) {
+	m := &Manifest{Files: make(map[string]*ManifestEntry)}
+
+	b, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return m, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %s", dir, err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]*ManifestEntry)
+	}
+
+	return m, nil
+}
+
+// Write writes the manifest to the given archive directory
+func (m *Manifest) Write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so the manifest is replaced atomically
+	tmp := filepath.Join(dir, "."+ManifestName+".tmp")
+	if err := os.WriteFile(tmp, append(b, '\n'), 0664); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ManifestName))
+}
+
+// Index computes the checksums of all the BTF files of the directory and
+// updates the manifest with them (entries of missing files are removed, and
+// OCI image layout directories are listed without checksum)
+func (d Dir) Index() (*Manifest, error) {
+	m, err := ReadManifest(d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := d.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*ManifestEntry)
+	for _, e := range entries {
+		me, ok := m.Files[filepath.Base(e.Path)]
+		if !ok {
+			me = &ManifestEntry{}
+		}
+		files[filepath.Base(e.Path)] = me
+		if strings.HasSuffix(e.Path, OCIExt) {
+			me.SHA256, me.Size = "", 0
+			continue
+		}
+		sum, err := utils.FileSHA256(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		me.SHA256 = sum
+		me.Size = e.Size
+	}
+	m.Files = files
+
+	return m, m.Write(d.Path)
+}
+
+// RecordFile updates the entry of the given archive file in the manifest of its
+// directory: its checksum and size, then the given update. Directories (OCI
+// image layouts) are listed without checksum and size.
+func RecordFile(path string, update func(me *ManifestEntry)) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	dir := filepath.Dir(path)
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	me := &ManifestEntry{}
+	if !fi.IsDir() {
+		sum, err := utils.FileSHA256(path) // symbolic links (to blobs) are followed
+		if err != nil {
+			return err
+		}
+		me.SHA256, me.Size = sum, fi.Size()
+	}
+	update(me)
+	m.Files[filepath.Base(path)] = me
+
+	return m.Write(dir)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataDog/zstd"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// StoreDir is the directory, inside the archive, of the content addressed
+// store
+const StoreDir = ".store"
+
+// BlobExt is the extension of the blobs of the store (zstd compressed BTF)
+const BlobExt = ZstdExt
+
+// Store is a content addressed store of BTF files, shared by all the
+// distributions of the archive. Blobs are named after the sha256 checksum of
+// the (uncompressed) BTF file:
+//
+//	.store/blobs/sha256/<btf sha256>.btf.zst
+//	.store/blobs/sha256/<btf sha256>.json (toolchain of the BTF file)
+//	.store/vmlinux/sha256/<vmlinux sha256> (holds the BTF checksum)
+//
+// The vmlinux entries map the kernel images to the BTF generated out of them,
+// so pahole only has to run once per kernel image. The toolchain of a blob is
+// copied to the manifest entries referencing it, so a reused BTF file is only
+// stale if the blob is.
+type Store struct {
+	Root string
+}
+
+// NewStore returns the store of the given archive directory
+func NewStore(archiveRoot string) *Store {
+	return &Store{Root: filepath.Join(archiveRoot, StoreDir)}
+}
+
+// BlobPath returns the path of the blob with the given checksum
+func (s *Store) BlobPath(sum string) string {
+	return filepath.Join(s.Root, "blobs", "sha256", sum+BlobExt)
+}
+
+func (s *Store) toolchainPath(sum string) string {
+	return filepath.Join(s.Root, "blobs", "sha256", sum+".json")
+}
+
+func (s *Store) vmlinuxPath(sum string) string {
+	return filepath.Join(s.Root, "vmlinux", "sha256", sum)
+}
+
+// Toolchain is the toolchain a BTF file was generated with
+type Toolchain struct {
+	Pahole      string   `json:"pahole,omitempty"`       // version, like v1.25
+	PaholeFlags []string `json:"pahole_flags,omitempty"` // encoding flags
+}
+
+// Put adds the BTF file, generated with the given toolchain, to the store (if
+// not there yet) and returns its checksum. The toolchain of a blob is the last
+// one that generated it.
+func (s *Store) Put(btfPath string, tc Toolchain) (string, error) {
+	data, err := os.ReadFile(btfPath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256Hex(data)
+
+	blob := s.BlobPath(sum)
+	if _, err := os.Stat(blob); err != nil {
+		compressed, err := zstd.CompressLevel(nil, data, zstd.BestCompression)
+		if err != nil {
+			return "", fmt.Errorf("zstd: %s", err)
+		}
+		if err := utils.WriteFileAtomic(blob, compressed); err != nil {
+			return "", fmt.Errorf("store blob: %s", err)
+		}
+	}
+
+	b, err := json.Marshal(tc)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.WriteFileAtomic(s.toolchainPath(sum), b); err != nil {
+		return "", fmt.Errorf("store toolchain: %s", err)
+	}
+
+	return sum, nil
+}
+
+// Toolchain returns the toolchain the blob with the given checksum was
+// generated with (zero if unknown, e.g. stored by an older version)
+func (s *Store) Toolchain(sum string) Toolchain {
+	var tc Toolchain
+	if b, err := os.ReadFile(s.toolchainPath(sum)); err == nil {
+		json.Unmarshal(b, &tc)
+	}
+	return tc
+}
+
+// Get writes the BTF file with the given checksum to out
+func (s *Store) Get(sum string, out string) error {
+	compressed, err := os.ReadFile(s.BlobPath(sum))
+	if err != nil {
+		return err
+	}
+	data, err := zstd.Decompress(nil, compressed)
+	if err != nil {
+		return fmt.Errorf("zstd: %s", err)
+	}
+	if sha256Hex(data) != sum {
+		return fmt.Errorf("blob %s is corrupted", sum)
+	}
+	return os.WriteFile(out, data, 0664)
+}
+
+// Lookup returns the checksum of the BTF file generated out of the vmlinux
+// file with the given checksum, if any
+func (s *Store) Lookup(vmlinuxSum string) (string, bool) {
+	b, err := os.ReadFile(s.vmlinuxPath(vmlinuxSum))
+	if err != nil {
+		return "", false
+	}
+	sum := strings.TrimSpace(string(b))
+	if _, err := os.Stat(s.BlobPath(sum)); err != nil {
+		return "", false
+	}
+	return sum, true
+}
+
+// Link records that the BTF file with the given checksum was generated out of
+// the vmlinux file with the given checksum
+func (s *Store) Link(vmlinuxSum string, btfSum string) error {
+	return utils.WriteFileAtomic(s.vmlinuxPath(vmlinuxSum), []byte(btfSum+"\n"))
+}
+
+// Symlink creates a symbolic link, at path, to the blob with the given
+// checksum (the blobs can be used as .btf.zst files)
+func (s *Store) Symlink(sum string, path string) error {
+	target, err := filepath.Rel(filepath.Dir(path), s.BlobPath(sum))
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, path)
+}
+
+// Reference records, in the manifest of its directory, that the given archive
+// file (a link to a blob, see Symlink) holds the BTF file with the given
+// checksum, with the toolchain of the blob
+func (s *Store) Reference(path string, btfSum string) error {
+	tc := s.Toolchain(btfSum)
+	return RecordFile(path, func(me *ManifestEntry) {
+		me.BTF = btfSum
+		me.Pahole = tc.Pahole
+		me.PaholeFlags = tc.PaholeFlags
+	})
+}
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+func TestStore(t *testing.T) {
+	root := t.TempDir()
+	s := NewStore(root)
+
+	btf := filepath.Join(t.TempDir(), "a.btf")
+	if err := os.WriteFile(btf, []byte("BTF data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := Toolchain{Pahole: "v1.25", PaholeFlags: []string{"--btf_gen_floats"}}
+	sum, err := s.Put(btf, Toolchain{Pahole: "v1.22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := s.Put(btf, tc); err != nil || again != sum {
+		t.Fatalf("second put: %s %v", again, err)
+	}
+
+	if _, ok := s.Lookup("vmlinux"); ok {
+		t.Fatal("unexpected vmlinux entry")
+	}
+	if err := s.Link("vmlinux", sum); err != nil {
+		t.Fatal(err)
+	}
+	found, ok := s.Lookup("vmlinux")
+	if !ok || found != sum {
+		t.Fatalf("lookup: %s %t", found, ok)
+	}
+
+	out := filepath.Join(t.TempDir(), "b.btf")
+	if err := s.Get(sum, out); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); !bytes.Equal(data, []byte("BTF data")) {
+		t.Fatalf("unexpected contents: %q", data)
+	}
+
+	// references: a symbolic link to the blob, listed in the manifest with
+	// the last toolchain of the blob
+
+	dir := filepath.Join(root, "ubuntu", "focal", "x86_64")
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "5.4.0-1-generic"+BlobExt)
+	if err := s.Symlink(sum, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reference(link, sum); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, ok := m.Files[filepath.Base(link)]
+	if !ok || me.BTF != sum || me.Pahole != tc.Pahole || !slices.Equal(me.PaholeFlags, tc.PaholeFlags) {
+		t.Fatalf("unexpected manifest: %+v", m.Files)
+	}
+	blobSum, err := utils.FileSHA256(s.BlobPath(sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.SHA256 != blobSum {
+		t.Errorf("manifest checksum %s, expected %s", me.SHA256, blobSum)
+	}
+}
+
+func TestStoreConcurrent(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	btf := filepath.Join(t.TempDir(), "a.btf")
+	if err := os.WriteFile(btf, bytes.Repeat([]byte("BTF data"), 100000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// identical BTF files of several distros, generated at the same time
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sum, err := s.Put(btf, Toolchain{Pahole: "v1.25"})
+			if err == nil {
+				err = s.Link("vmlinux", sum)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if _, ok := s.Lookup("vmlinux"); !ok {
+		t.Error("vmlinux entry not found")
+	}
+}
@@ -0,0 +1,237 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+	fastxz "github.com/therootcompany/xz"
+
+	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// Problem is an issue found while verifying an archive directory
+type Problem struct {
+	Path string `json:"path"`
+	Err  string `json:"error"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Err)
+}
+
+// Verify checks all the BTF files of the directory (see VerifyEntry), cross
+// checks them against the manifest (if there is one) and reports orphaned
+// .hasbtf markers and leftover intermediate files.
+func (d Dir) Verify(ctx context.Context) ([]Problem, error) {
+	var problems []Problem
+	problem := func(path string, format string, args ...interface{}) {
+		problems = append(problems, Problem{Path: path, Err: fmt.Sprintf(format, args...)})
+	}
+
+	m, err := ReadManifest(d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := d.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	archived := make(map[string]string) // map[kernel]BTF file name
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		archived[e.Kernel] = filepath.Base(e.Path)
+
+		sum, err := VerifyEntry(e)
+		if err != nil {
+			problem(e.Path, "%s", err)
+		}
+		if len(m.Files) == 0 || sum == "" {
+			continue
+		}
+		me, ok := m.Files[filepath.Base(e.Path)]
+		switch {
+		case !ok:
+			problem(e.Path, "missing from %s", ManifestName)
+		case me.SHA256 != sum:
+			problem(e.Path, "checksum %s does not match %s (%s)", sum, ManifestName, me.SHA256)
+		}
+	}
+
+	// manifest entries without a BTF file
+
+	var names []string
+	for name := range m.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(d.Path, name)); err != nil {
+			problem(filepath.Join(d.Path, name), "listed in %s but missing", ManifestName)
+		}
+	}
+
+	// .hasbtf markers of kernels that were archived anyway
+
+	hasBTF, err := d.Markers(HasBTFExt)
+	if err != nil {
+		return nil, err
+	}
+	for _, kernel := range hasBTF {
+		if name, ok := archived[kernel]; ok {
+			problem(filepath.Join(d.Path, kernel+HasBTFExt), "orphaned marker (%s exists)", name)
+		}
+	}
+
+	// intermediate files
+
+	leftovers, err := d.Leftovers()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range leftovers {
+		problem(l, "leftover intermediate file")
+	}
+
+	return problems, nil
+}
+
+// VerifyEntry checks that the BTF data of the entry can be parsed (and, for a
+// tarball, see verifyTarball). It returns the sha256 checksum of the file, or
+// an empty one for an OCI image layout directory (listed in the manifest
+// without checksum).
+func VerifyEntry(e Entry) (string, error) {
+	if strings.HasSuffix(e.Path, BTFExt) {
+		return verifyTarball(e)
+	}
+
+	data, err := ReadBTF(e.Path)
+	if err != nil {
+		return "", err
+	}
+	if err := checkBTF(data, e.Arch); err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(e.Path, OCIExt) {
+		return "", nil
+	}
+
+	return utils.FileSHA256(e.Path)
+}
+
+// verifyTarball checks that the tarball holds exactly one file, named after
+// the kernel release, that it was created with the reproducibility settings of
+// TarballBTF (mtime 0, root owner, read-only, sorted) and that the BTF data
+// can be parsed. It returns the sha256 checksum of the tarball.
+func verifyTarball(e Entry) (string, error) {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// checksum the whole file while reading it
+	h := sha256.New()
+	r := io.TeeReader(f, h)
+
+	xr, err := fastxz.NewReader(r, 0)
+	if err != nil {
+		return "", fmt.Errorf("xz reader: %s", err)
+	}
+
+	var names []string
+	var data []byte
+
+	tr := tar.NewReader(xr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", fmt.Errorf("tar reader next: %s", err)
+		}
+		if err := checkHeader(hdr); err != nil {
+			return "", err
+		}
+		if len(names) > 0 && names[len(names)-1] > hdr.Name {
+			return "", fmt.Errorf("entries not sorted: %s after %s", hdr.Name, names[len(names)-1])
+		}
+		names = append(names, hdr.Name)
+		if data, err = io.ReadAll(tr); err != nil {
+			return "", fmt.Errorf("read %s: %s", hdr.Name, err)
+		}
+	}
+
+	if len(names) != 1 || names[0] != e.Kernel+".btf" {
+		return "", fmt.Errorf("expected a single %s.btf entry, found %v", e.Kernel, names)
+	}
+
+	if err := checkBTF(data, e.Arch); err != nil {
+		return "", fmt.Errorf("%s: %s", names[0], err)
+	}
+
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return "", fmt.Errorf("checksum: %s", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkHeader checks the tar header against the TarballBTF settings
+func checkHeader(hdr *tar.Header) error {
+	switch {
+	case hdr.Typeflag != tar.TypeReg:
+		return fmt.Errorf("%s: not a regular file", hdr.Name)
+	case hdr.ModTime.Unix() != 0:
+		return fmt.Errorf("%s: mtime is %d, not 0", hdr.Name, hdr.ModTime.Unix())
+	case hdr.Uid != 0 || hdr.Gid != 0:
+		return fmt.Errorf("%s: owner is %d:%d, not 0:0", hdr.Name, hdr.Uid, hdr.Gid)
+	case hdr.Mode&0777 != 0444:
+		return fmt.Errorf("%s: mode is %#o, not 0444", hdr.Name, hdr.Mode&0777)
+	}
+	return nil
+}
+
+// checkBTF checks the BTF data is in the byte order of the architecture of the
+// archive directory (if known), parses it and checks it holds at least one type
+func checkBTF(data []byte, arch string) error {
+	if a, ok := kernel.GetArch(arch); ok {
+		order, err := kernel.BTFByteOrder(data)
+		if err != nil {
+			return fmt.Errorf("parse BTF: %s", err)
+		}
+		if order != a.ByteOrder {
+			return fmt.Errorf("BTF byte order %s doesn't match %s (%s)", order, arch, a.ByteOrder)
+		}
+	}
+
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("parse BTF: %s", err)
+	}
+	for typ, err := range spec.All() {
+		if err != nil {
+			return fmt.Errorf("parse BTF: %s", err)
+		}
+		if _, void := typ.(*btf.Void); !void {
+			return nil
+		}
+	}
+	return errors.New("BTF holds no types")
+}
@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/aquasecurity/btfhub/pkg/pkg"
+)
+
+const testKernel = "5.4.0-1-generic"
+
+func writeBTFTarball(t *testing.T, dir string, order binary.ByteOrder) {
+	b, err := btf.NewBuilder([]btf.Type{&btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Marshal(nil, &btf.MarshalOptions{Order: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := filepath.Join(t.TempDir(), testKernel+".btf")
+	if err := os.WriteFile(raw, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.TarballBTF(context.Background(), raw, filepath.Join(dir, testKernel+BTFExt)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	root := t.TempDir()
+	d := Dir{Distro: "ubuntu", Release: "focal", Arch: "x86_64", Path: filepath.Join(root, "ubuntu", "focal", "x86_64")}
+	if err := os.MkdirAll(d.Path, 0775); err != nil {
+		t.Fatal(err)
+	}
+	writeBTFTarball(t, d.Path, binary.LittleEndian)
+	if _, err := d.Index(); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := d.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	// orphaned marker, leftover and checksum mismatch
+
+	for _, name := range []string{testKernel + HasBTFExt, "vmlinux-" + testKernel} {
+		if err := os.WriteFile(filepath.Join(d.Path, name), nil, 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := ReadManifest(d.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Files[testKernel+BTFExt].SHA256 = "00"
+	if err := m.Write(d.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err = d.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"does not match", "orphaned marker", "leftover"}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for i, p := range problems {
+		if !strings.Contains(p.Err, expected[i]) {
+			t.Errorf("problem %d: expected %q, got %q", i, expected[i], p.Err)
+		}
+	}
+}
+
+func TestVerifyByteOrder(t *testing.T) {
+	root := t.TempDir()
+	for arch, order := range map[string]binary.ByteOrder{"s390x": binary.LittleEndian, "x86_64": binary.BigEndian} {
+		d := Dir{Distro: "sles", Release: "15.3", Arch: arch, Path: filepath.Join(root, "sles", "15.3", arch)}
+		if err := os.MkdirAll(d.Path, 0775); err != nil {
+			t.Fatal(err)
+		}
+		writeBTFTarball(t, d.Path, order)
+
+		problems, err := d.Verify(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0].Err, "byte order") {
+			t.Errorf("%s: expected a byte order problem, got %v", arch, problems)
+		}
+	}
+}
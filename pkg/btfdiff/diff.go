@@ -0,0 +1,394 @@
+// Package btfdiff compares the BTF of two kernels: the types added and
+// removed, the changed struct and union layouts, and the changed function
+// prototypes.
+package btfdiff
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// Diff holds the differences between two BTF specs (A and B). Types are named
+// after their C declaration kind, e.g. "struct task_struct" or "func
+// vfs_read".
+type Diff struct {
+	A       string    `json:"a"`
+	B       string    `json:"b"`
+	Added   []string  `json:"added"`
+	Removed []string  `json:"removed"`
+	Changed []*Change `json:"changed"`
+}
+
+// Change describes a type found in both specs, but declared differently
+type Change struct {
+	Type    string          `json:"type"`
+	OldSize uint32          `json:"old_size,omitempty"` // structs and unions
+	NewSize uint32          `json:"new_size,omitempty"`
+	Members []*MemberChange `json:"members,omitempty"`
+	Old     string          `json:"old,omitempty"` // prototypes and typedefs
+	New     string          `json:"new,omitempty"`
+}
+
+// MemberChange describes a struct or union member added (Old is nil), removed
+// (New is nil), renamed, moved, resized or retyped
+type MemberChange struct {
+	Old *Member `json:"old,omitempty"`
+	New *Member `json:"new,omitempty"`
+}
+
+// Member is a struct or union member. Members of anonymous structs and
+// unions are flattened into their parent (offsets are relative to it).
+type Member struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Offset   uint32 `json:"offset"`             // in bits
+	Size     int    `json:"size"`               // in bytes
+	Bitfield uint32 `json:"bitfield,omitempty"` // in bits
+}
+
+// Options restrict the compared types
+type Options struct {
+	// Types, if not empty, lists the names of the only types compared (CO-RE
+	// flavors, as in task_struct___old, are matched by their base name)
+	Types []string
+}
+
+// Compare returns the differences between the a and b specs
+func Compare(a *btf.Spec, b *btf.Spec, opts Options) (*Diff, error) {
+	typesA, err := index(a, opts)
+	if err != nil {
+		return nil, err
+	}
+	typesB, err := index(b, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{Added: []string{}, Removed: []string{}, Changed: []*Change{}}
+
+	for name, ta := range typesA {
+		tb, ok := typesB[name]
+		if !ok {
+			d.Removed = append(d.Removed, name)
+			continue
+		}
+		if c := compareType(name, ta, tb); c != nil {
+			d.Changed = append(d.Changed, c)
+		}
+	}
+	for name := range typesB {
+		if _, ok := typesA[name]; !ok {
+			d.Added = append(d.Added, name)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool {
+		return d.Changed[i].Type < d.Changed[j].Type
+	})
+
+	return d, nil
+}
+
+// Empty returns true if no differences were found
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// index returns the named types of the spec compared by the diff, keyed by
+// their declaration (the first one wins if declared more than once)
+func index(spec *btf.Spec, opts Options) (map[string]btf.Type, error) {
+	types := map[string]btf.Type{}
+
+	for typ, err := range spec.All() {
+		if err != nil {
+			return nil, fmt.Errorf("parse BTF: %s", err)
+		}
+		name := typ.TypeName()
+		if name == "" {
+			continue
+		}
+		if len(opts.Types) > 0 && !slices.Contains(opts.Types, BaseName(name)) {
+			continue
+		}
+		var kind string
+		switch typ.(type) {
+		case *btf.Struct:
+			kind = "struct"
+		case *btf.Union:
+			kind = "union"
+		case *btf.Enum:
+			kind = "enum"
+		case *btf.Typedef:
+			kind = "typedef"
+		case *btf.Func:
+			kind = "func"
+		default:
+			continue
+		}
+		key := kind + " " + name
+		if _, ok := types[key]; !ok {
+			types[key] = typ
+		}
+	}
+
+	return types, nil
+}
+
+// BaseName strips the CO-RE flavor (triple underscore suffix) of a type name
+func BaseName(name string) string {
+	if i := strings.Index(name, "___"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func compareType(name string, a btf.Type, b btf.Type) *Change {
+	switch ta := a.(type) {
+	case *btf.Struct:
+		tb := b.(*btf.Struct)
+		return compareLayout(name, ta.Size, ta.Members, tb.Size, tb.Members)
+	case *btf.Union:
+		tb := b.(*btf.Union)
+		return compareLayout(name, ta.Size, ta.Members, tb.Size, tb.Members)
+	case *btf.Typedef:
+		oldType, newType := typeName(ta.Type), typeName(b.(*btf.Typedef).Type)
+		if oldType != newType {
+			return &Change{Type: name, Old: oldType, New: newType}
+		}
+	case *btf.Func:
+		oldProto, newProto := prototype(ta), prototype(b.(*btf.Func))
+		if oldProto != newProto {
+			return &Change{Type: name, Old: oldProto, New: newProto}
+		}
+	}
+	return nil // enums only compared by name
+}
+
+// compareLayout compares the members of two structs (or unions), matching
+// them by name. A removed member and an added one with the same offset and
+// type are reported as a rename.
+func compareLayout(name string, oldSize uint32, oldMembers []btf.Member, newSize uint32, newMembers []btf.Member) *Change {
+	c := &Change{Type: name}
+	if oldSize != newSize {
+		c.OldSize, c.NewSize = oldSize, newSize
+	}
+
+	olds := flatten(oldMembers, 0)
+	news := flatten(newMembers, 0)
+
+	byName := map[string]*Member{}
+	for _, m := range news {
+		byName[m.Name] = m
+	}
+
+	var removed []*Member
+	matched := map[string]bool{}
+
+	for _, o := range olds {
+		n, ok := byName[o.Name]
+		if !ok {
+			removed = append(removed, o)
+			continue
+		}
+		matched[n.Name] = true
+		if *o != *n {
+			c.Members = append(c.Members, &MemberChange{Old: o, New: n})
+		}
+	}
+
+	var added []*Member
+	for _, n := range news {
+		if !matched[n.Name] {
+			added = append(added, n)
+		}
+	}
+
+	// Renames: same offset, size and type, different name
+
+	for _, o := range removed {
+		i := slices.IndexFunc(added, func(n *Member) bool {
+			return n.Offset == o.Offset && n.Size == o.Size && n.Bitfield == o.Bitfield && n.Type == o.Type
+		})
+		if i < 0 {
+			c.Members = append(c.Members, &MemberChange{Old: o})
+			continue
+		}
+		c.Members = append(c.Members, &MemberChange{Old: o, New: added[i]})
+		added = slices.Delete(added, i, i+1)
+	}
+	for _, n := range added {
+		c.Members = append(c.Members, &MemberChange{New: n})
+	}
+
+	if c.OldSize == c.NewSize && len(c.Members) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(c.Members, func(i, j int) bool {
+		return c.Members[i].offset() < c.Members[j].offset()
+	})
+
+	return c
+}
+
+func (mc *MemberChange) offset() uint32 {
+	if mc.New != nil {
+		return mc.New.Offset
+	}
+	return mc.Old.Offset
+}
+
+// flatten returns the named members, descending into the anonymous structs
+// and unions
+func flatten(members []btf.Member, base btf.Bits) []*Member {
+	var flat []*Member
+	for _, m := range members {
+		if m.Name == "" {
+			switch t := btf.UnderlyingType(m.Type).(type) {
+			case *btf.Struct:
+				flat = append(flat, flatten(t.Members, base+m.Offset)...)
+				continue
+			case *btf.Union:
+				flat = append(flat, flatten(t.Members, base+m.Offset)...)
+				continue
+			}
+		}
+		size, _ := btf.Sizeof(m.Type)
+		flat = append(flat, &Member{
+			Name:     m.Name,
+			Type:     typeName(m.Type),
+			Offset:   uint32(base + m.Offset),
+			Size:     size,
+			Bitfield: uint32(m.BitfieldSize),
+		})
+	}
+	return flat
+}
+
+// prototype returns the C prototype of a function
+func prototype(fn *btf.Func) string {
+	proto, ok := fn.Type.(*btf.FuncProto)
+	if !ok {
+		return fn.Name + "()"
+	}
+	var params []string
+	for _, p := range proto.Params {
+		params = append(params, strings.TrimSpace(typeName(p.Type)+" "+p.Name))
+	}
+	return fmt.Sprintf("%s %s(%s)", typeName(proto.Return), fn.Name, strings.Join(params, ", "))
+}
+
+// typeName returns the C name of a type (anonymous types are not expanded)
+func typeName(t btf.Type) string {
+	switch t := t.(type) {
+	case nil, *btf.Void:
+		return "void"
+	case *btf.Pointer:
+		return typeName(t.Target) + " *"
+	case *btf.Const:
+		return "const " + typeName(t.Type)
+	case *btf.Volatile:
+		return "volatile " + typeName(t.Type)
+	case *btf.Restrict:
+		return typeName(t.Type) + " restrict"
+	case *btf.TypeTag:
+		return typeName(t.Type)
+	case *btf.Array:
+		return fmt.Sprintf("%s[%d]", typeName(t.Type), t.Nelems)
+	case *btf.Struct:
+		return "struct " + orAnon(t.Name)
+	case *btf.Union:
+		return "union " + orAnon(t.Name)
+	case *btf.Enum:
+		return "enum " + orAnon(t.Name)
+	case *btf.Fwd:
+		return fmt.Sprintf("%s %s", t.Kind, t.Name)
+	case *btf.FuncProto:
+		var params []string
+		for _, p := range t.Params {
+			params = append(params, typeName(p.Type))
+		}
+		return fmt.Sprintf("%s (*)(%s)", typeName(t.Return), strings.Join(params, ", "))
+	}
+	return t.TypeName()
+}
+
+func orAnon(name string) string {
+	if name == "" {
+		return "{...}"
+	}
+	return name
+}
+
+// WriteText writes the differences in a human readable form
+func (d *Diff) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", d.A, d.B)
+	for _, name := range d.Removed {
+		fmt.Fprintf(&b, "- %s\n", name)
+	}
+	for _, name := range d.Added {
+		fmt.Fprintf(&b, "+ %s\n", name)
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&b, "~ %s\n", c.Type)
+		if c.Old != "" || c.New != "" {
+			fmt.Fprintf(&b, "    - %s\n    + %s\n", c.Old, c.New)
+		}
+		if c.OldSize != c.NewSize {
+			fmt.Fprintf(&b, "    size %d -> %d\n", c.OldSize, c.NewSize)
+		}
+		for _, mc := range c.Members {
+			fmt.Fprintf(&b, "    %s\n", mc)
+		}
+	}
+	if d.Empty() {
+		fmt.Fprintf(&b, "no differences\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (mc *MemberChange) String() string {
+	o, n := mc.Old, mc.New
+	switch {
+	case o == nil:
+		return fmt.Sprintf("+ %s", n)
+	case n == nil:
+		return fmt.Sprintf("- %s", o)
+	}
+
+	var changes []string
+	if o.Name != n.Name {
+		changes = append(changes, fmt.Sprintf("renamed to %s", n.Name))
+	}
+	if o.Offset != n.Offset {
+		changes = append(changes, fmt.Sprintf("offset %d -> %d", o.Offset, n.Offset))
+	}
+	if o.Size != n.Size {
+		changes = append(changes, fmt.Sprintf("size %d -> %d", o.Size, n.Size))
+	}
+	if o.Bitfield != n.Bitfield {
+		changes = append(changes, fmt.Sprintf("bitfield %d -> %d", o.Bitfield, n.Bitfield))
+	}
+	if o.Type != n.Type {
+		changes = append(changes, fmt.Sprintf("type %s -> %s", o.Type, n.Type))
+	}
+	return fmt.Sprintf("~ %s: %s", o.Name, strings.Join(changes, ", "))
+}
+
+func (m *Member) String() string {
+	if m.Bitfield != 0 {
+		return fmt.Sprintf("%s %s:%d (offset %d)", m.Type, m.Name, m.Bitfield, m.Offset)
+	}
+	return fmt.Sprintf("%s %s (offset %d, size %d)", m.Type, m.Name, m.Offset, m.Size)
+}
@@ -0,0 +1,91 @@
+package btfdiff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func loadSpec(t *testing.T, types ...btf.Type) *btf.Spec {
+	t.Helper()
+	b, err := btf.NewBuilder(types, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestCompare(t *testing.T) {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	u64 := &btf.Int{Name: "u64", Size: 8}
+
+	a := loadSpec(t,
+		&btf.Struct{Name: "task", Size: 16, Members: []btf.Member{
+			{Name: "pid", Type: u32, Offset: 0},
+			{Name: "flags", Type: u32, Offset: 32},
+			{Name: "start", Type: u64, Offset: 64},
+		}},
+		&btf.Struct{Name: "gone", Size: 4, Members: []btf.Member{{Name: "x", Type: u32}}},
+		&btf.Func{Name: "vfs_read", Linkage: btf.GlobalFunc, Type: &btf.FuncProto{
+			Return: u32,
+			Params: []btf.FuncParam{{Name: "count", Type: u32}},
+		}},
+	)
+	b := loadSpec(t,
+		&btf.Struct{Name: "task", Size: 24, Members: []btf.Member{
+			{Name: "pid", Type: u32, Offset: 0},
+			{Name: "state", Type: u32, Offset: 32},
+			{Name: "start", Type: u64, Offset: 128},
+		}},
+		&btf.Struct{Name: "new", Size: 4, Members: []btf.Member{{Name: "x", Type: u32}}},
+		&btf.Func{Name: "vfs_read", Linkage: btf.GlobalFunc, Type: &btf.FuncProto{
+			Return: u32,
+			Params: []btf.FuncParam{{Name: "count", Type: u64}},
+		}},
+	)
+
+	d, err := Compare(a, b, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(d.Added, ",") != "struct new" || strings.Join(d.Removed, ",") != "struct gone" {
+		t.Fatalf("added %v, removed %v", d.Added, d.Removed)
+	}
+	if len(d.Changed) != 2 {
+		t.Fatalf("unexpected changes: %+v", d.Changed)
+	}
+
+	fn, task := d.Changed[0], d.Changed[1]
+	if fn.Type != "func vfs_read" || fn.Old != "u32 vfs_read(u32 count)" || fn.New != "u32 vfs_read(u64 count)" {
+		t.Errorf("unexpected prototype change: %+v", fn)
+	}
+	if task.OldSize != 16 || task.NewSize != 24 || len(task.Members) != 2 {
+		t.Fatalf("unexpected layout change: %+v", task)
+	}
+	if mc := task.Members[0]; mc.Old.Name != "flags" || mc.New.Name != "state" {
+		t.Errorf("expected flags renamed to state, got %s", mc)
+	}
+	if mc := task.Members[1]; mc.Old.Offset != 64 || mc.New.Offset != 128 {
+		t.Errorf("expected start moved, got %s", mc)
+	}
+
+	// restricted to the given types
+
+	d, err = Compare(a, b, Options{Types: []string{"task"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Changed) != 1 {
+		t.Errorf("unexpected restricted diff: %+v", d)
+	}
+}
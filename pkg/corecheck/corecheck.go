@@ -0,0 +1,142 @@
+// Package corecheck checks the CO-RE relocations of BPF objects against the
+// BTF of the archived kernels, to find the kernels an object won't load on.
+package corecheck
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+// Relocation is a CO-RE relocation of a BPF object (the ones shared by
+// several programs, e.g. in a common subprogram, are only listed once)
+type Relocation struct {
+	Program     string `json:"program"`     // first program using it
+	Instruction int    `json:"instruction"` // index in the program
+	Desc        string `json:"relocation"`
+
+	ins  asm.Instruction
+	relo *btf.CORERelocation
+	typ  btf.Type // local type (in the object BTF)
+}
+
+func (r *Relocation) String() string {
+	return fmt.Sprintf("%s[%d]: %s", r.Program, r.Instruction, r.Desc)
+}
+
+// Object is a BPF object and its CO-RE relocations
+type Object struct {
+	Path        string
+	Relocations []*Relocation
+
+	byteOrder binary.ByteOrder
+	types     *btf.Spec
+}
+
+// Load parses a BPF object (.BTF and .BTF.ext sections) and returns its CO-RE
+// relocations
+func Load(path string) (*Object, error) {
+	spec, err := ebpf.LoadCollectionSpec(path)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %s", path, err)
+	}
+	if spec.Types == nil {
+		return nil, fmt.Errorf("%s has no BTF", path)
+	}
+
+	obj := &Object{
+		Path:      path,
+		byteOrder: spec.ByteOrder,
+		types:     spec.Types,
+	}
+
+	var names []string
+	for name := range spec.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		for i, ins := range spec.Programs[name].Instructions {
+			relo := btf.CORERelocationMetadata(&ins)
+			if relo == nil {
+				continue
+			}
+			desc := relo.String()
+			if seen[desc] {
+				continue
+			}
+			seen[desc] = true
+			typ, err := localType(spec.Types, desc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			obj.Relocations = append(obj.Relocations, &Relocation{
+				Program:     name,
+				Instruction: i,
+				Desc:        desc,
+				ins:         ins,
+				relo:        relo,
+				typ:         typ,
+			})
+		}
+	}
+
+	return obj, nil
+}
+
+// localType returns the type of the object BTF a relocation refers to, out of
+// its description (the only place the type ID is exposed)
+func localType(types *btf.Spec, desc string) (btf.Type, error) {
+	i := strings.LastIndex(desc, "local_id=")
+	if i < 0 {
+		return nil, fmt.Errorf("no local type in %s", desc)
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(desc[i+len("local_id="):], ")"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("local type of %s: %s", desc, err)
+	}
+	return types.TypeByID(btf.TypeID(id))
+}
+
+// Types returns the types the CO-RE relocations of the object refer to, as
+// compiled in the object (named after the kernel ones, with an optional
+// ___flavor suffix)
+func (o *Object) Types() []btf.Type {
+	var types []btf.Type
+	seen := map[btf.Type]bool{}
+	for _, r := range o.Relocations {
+		if !seen[r.typ] {
+			seen[r.typ] = true
+			types = append(types, r.typ)
+		}
+	}
+	return types
+}
+
+// Unresolved returns the relocations of the object that can't be resolved
+// against the given kernel BTF (the ones libbpf would poison)
+func (o *Object) Unresolved(kernel *btf.Spec) []*Relocation {
+	var unresolved []*Relocation
+
+	for _, r := range o.Relocations {
+		fixups, err := btf.CORERelocate([]*btf.CORERelocation{r.relo}, []*btf.Spec{kernel}, o.byteOrder, o.types.TypeID)
+		if err != nil {
+			unresolved = append(unresolved, r)
+			continue
+		}
+		ins := r.ins
+		if err := fixups[0].Apply(&ins); err != nil || ins.Constant == btf.COREBadRelocationSentinel {
+			unresolved = append(unresolved, r)
+		}
+	}
+
+	return unresolved
+}
@@ -0,0 +1,63 @@
+package corecheck
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// The test objects come from the cilium/ebpf test data (relocs_read.c and
+// relocs_read_tgt.c, little endian)
+
+func TestTypes(t *testing.T) {
+	obj, err := Load("testdata/relocs_read-el.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, typ := range obj.Types() {
+		names = append(names, typ.TypeName())
+	}
+	slices.Sort(names)
+	if expected := []string{"bits", "nonexist", "nonexist_enum", "s"}; !slices.Equal(names, expected) {
+		t.Errorf("relocation types %v, expected %v", names, expected)
+	}
+}
+
+func TestUnresolved(t *testing.T) {
+	obj, err := Load("testdata/relocs_read-el.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obj.Relocations) == 0 {
+		t.Fatal("no relocations found")
+	}
+
+	target, err := btf.LoadSpec("testdata/relocs_read_tgt-el.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := obj.Unresolved(target); len(u) != 0 {
+		t.Errorf("unexpected unresolved relocations: %v", u)
+	}
+
+	// a kernel without the types the object reads
+
+	b, err := btf.NewBuilder([]btf.Type{&btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := obj.Unresolved(empty); len(u) == 0 {
+		t.Error("expected unresolved relocations")
+	}
+}
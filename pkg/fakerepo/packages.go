@@ -0,0 +1,253 @@
+package fakerepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/cavaliergopher/cpio"
+	"github.com/cilium/ebpf/btf"
+)
+
+// vmlinuxAddr is the address of the .rodata section of the fake vmlinux files
+const vmlinuxAddr = 0xffffffff81000000
+
+// Vmlinux returns a tiny ELF vmlinux file of the given release: a .rodata
+// section with the linux_banner and its symbol, but no .BTF section
+func Vmlinux(release string) []byte {
+	return VmlinuxFor(release, elf.EM_X86_64, binary.LittleEndian)
+}
+
+// VmlinuxFor returns a Vmlinux file of another machine and byte order (e.g.
+// elf.EM_S390 and binary.BigEndian for s390x)
+func VmlinuxFor(release string, machine elf.Machine, order binary.ByteOrder) []byte {
+	banner := []byte(fmt.Sprintf("Linux version %s (fakerepo) #1 SMP\n\x00", release))
+	strtab := []byte("\x00linux_banner\x00")
+	shstrtab := []byte("\x00.rodata\x00.symtab\x00.strtab\x00.shstrtab\x00")
+
+	var symtab bytes.Buffer
+	binary.Write(&symtab, order, elf.Sym64{}) // null symbol
+	binary.Write(&symtab, order, elf.Sym64{
+		Name:  1,
+		Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT),
+		Shndx: 1,
+		Value: vmlinuxAddr,
+		Size:  uint64(len(banner)),
+	})
+
+	// ELF header, section data, section headers
+
+	const ehsize = 64
+	rodataOff := uint64(ehsize)
+	symtabOff := rodataOff + uint64(len(banner))
+	strtabOff := symtabOff + uint64(symtab.Len())
+	shstrtabOff := strtabOff + uint64(len(strtab))
+	shOff := shstrtabOff + uint64(len(shstrtab))
+
+	sections := []elf.Section64{
+		{},
+		{
+			Name: 1, Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC),
+			Addr: vmlinuxAddr, Off: rodataOff, Size: uint64(len(banner)), Addralign: 1,
+		},
+		{
+			Name: 9, Type: uint32(elf.SHT_SYMTAB), Off: symtabOff, Size: uint64(symtab.Len()),
+			Link: 3, Info: 1, Addralign: 8, Entsize: 24,
+		},
+		{
+			Name: 17, Type: uint32(elf.SHT_STRTAB), Off: strtabOff, Size: uint64(len(strtab)), Addralign: 1,
+		},
+		{
+			Name: 25, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOff, Size: uint64(len(shstrtab)), Addralign: 1,
+		},
+	}
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shOff,
+		Ehsize:    ehsize,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  4,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	if order == binary.BigEndian {
+		hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	}
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var out bytes.Buffer
+	binary.Write(&out, order, hdr)
+	out.Write(banner)
+	out.Write(symtab.Bytes())
+	out.Write(strtab)
+	out.Write(shstrtab)
+	binary.Write(&out, order, sections)
+
+	return out.Bytes()
+}
+
+// BTF returns the raw BTF data written by the fake pahole
+func BTF() []byte {
+	return BTFFor(binary.LittleEndian)
+}
+
+// BTFFor returns the BTF data in the given byte order, written by the fake
+// pahole for a vmlinux file of that byte order
+func BTFFor(order binary.ByteOrder) []byte {
+	b, err := btf.NewBuilder([]btf.Type{&btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}}, nil)
+	if err != nil {
+		panic(err)
+	}
+	data, err := b.Marshal(nil, &btf.MarshalOptions{Order: order})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// Deb returns a deb (or ddeb) package holding the given files (by path, like
+// ./usr/lib/debug/boot/vmlinux-5.4.0-42-generic)
+func Deb(t testing.TB, files map[string][]byte) []byte {
+	t.Helper()
+
+	control := tarGz(t, map[string][]byte{
+		"./control": []byte("Package: fakerepo\nVersion: 1.0\nArchitecture: amd64\nDescription: fake package\n"),
+	})
+	data := tarGz(t, files)
+
+	var out bytes.Buffer
+	out.WriteString("!<arch>\n")
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", control},
+		{"data.tar.gz", data},
+	} {
+		fmt.Fprintf(&out, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", m.name, 0, 0, 0, "100644", len(m.data))
+		out.Write(m.data)
+		if len(m.data)%2 != 0 {
+			out.WriteByte('\n')
+		}
+	}
+
+	return out.Bytes()
+}
+
+func tarGz(t testing.TB, files map[string][]byte) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for _, name := range sortedNames(files) {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// RPM returns a rpm package holding the given files (by path, like
+// ./usr/lib/debug/lib/modules/4.18.0-80.el8.x86_64/vmlinux), with a gzip
+// compressed cpio payload
+func RPM(t testing.TB, files map[string][]byte) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	// Lead (v3 binary package)
+
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xED, 0xAB, 0xEE, 0xDB, 3, 0})
+	copy(lead[10:76], "fakerepo")
+	binary.BigEndian.PutUint16(lead[76:], 1) // linux
+	binary.BigEndian.PutUint16(lead[78:], 5) // header style signature
+	out.Write(lead)
+
+	// Signature (empty, so it needs no padding) and header (payload format
+	// and compression)
+
+	writeRPMHeader(&out, nil)
+	writeRPMHeader(&out, []rpmTag{
+		{1124, "cpio"}, // RPMTAG_PAYLOADFORMAT
+		{1125, "gzip"}, // RPMTAG_PAYLOADCOMPRESSOR
+	})
+
+	// Payload
+
+	gw := gzip.NewWriter(&out)
+	cw := cpio.NewWriter(gw)
+	for _, name := range sortedNames(files) {
+		hdr := &cpio.Header{Name: name, Mode: cpio.TypeReg | 0644, Size: int64(len(files[name]))}
+		if err := cw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cw.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Bytes()
+}
+
+// rpmTag is a string tag of a rpm header
+type rpmTag struct {
+	id    uint32
+	value string
+}
+
+func writeRPMHeader(out *bytes.Buffer, tags []rpmTag) {
+	var store bytes.Buffer
+	index := make([]byte, 0, 16*len(tags))
+	for _, tag := range tags {
+		index = binary.BigEndian.AppendUint32(index, tag.id)
+		index = binary.BigEndian.AppendUint32(index, 6) // string
+		index = binary.BigEndian.AppendUint32(index, uint32(store.Len()))
+		index = binary.BigEndian.AppendUint32(index, 1)
+		store.WriteString(tag.value)
+		store.WriteByte(0)
+	}
+
+	out.Write([]byte{0x8E, 0xAD, 0xE8, 0x01, 0, 0, 0, 0})
+	binary.Write(out, binary.BigEndian, uint32(len(tags)))
+	binary.Write(out, binary.BigEndian, uint32(store.Len()))
+	out.Write(index)
+	out.Write(store.Bytes())
+}
+
+func sortedNames(files map[string][]byte) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,299 @@
+// Package fakerepo fakes the distribution repositories and the command line
+// tools btfhub depends on, so the repositories can be tested end to end
+// without network access: an HTTP server serving package indexes, directory
+// listings and small packages holding tiny vmlinux files, and fake pahole,
+// yum and zypper binaries on PATH.
+package fakerepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/tarxz"
+)
+
+// Server serves the files of fake repositories, under any host: while the
+// server runs, all the requests of the default HTTP client and transport (so
+// of the clients of utils.NewHTTPClient too) go to it. The files
+// have an ETag (their checksum), and conditional requests are supported.
+type Server struct {
+	srv     *httptest.Server
+	mtx     sync.Mutex
+	files   map[string]file // by host and path
+	fetches map[string]int  // by host and path (not modified answers aside)
+}
+
+type file struct {
+	data        []byte
+	contentType string
+}
+
+// NewServer starts a server, closed (and the default HTTP client and
+// transport restored) when the test ends
+func NewServer(t testing.TB) *Server {
+	s := &Server{files: map[string]file{}, fetches: map[string]int{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	clientTransport, defaultTransport := http.DefaultClient.Transport, http.DefaultTransport
+	http.DefaultClient.Transport = &rewriter{srv: s.srv}
+	http.DefaultTransport = &rewriter{srv: s.srv}
+
+	t.Cleanup(func() {
+		http.DefaultClient.Transport = clientTransport
+		http.DefaultTransport = defaultTransport
+		s.srv.Close()
+	})
+
+	return s
+}
+
+// Add serves data at the given URL
+func (s *Server) Add(rawURL string, data []byte, contentType string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.files[u.Host+u.Path] = file{data: data, contentType: contentType}
+}
+
+// Fetches returns how many times the file at the given URL was served (not
+// counting the not modified answers to conditional requests)
+func (s *Server) Fetches(rawURL string) int {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.fetches[u.Host+u.Path]
+}
+
+// AddListing serves an HTML directory listing of the given file names at the
+// given directory URL (ending with a slash)
+func (s *Server) AddListing(dirURL string, names ...string) {
+	var b bytes.Buffer
+	b.WriteString("<html><body><pre>\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", name, name)
+	}
+	b.WriteString("</pre></body></html>\n")
+	s.Add(dirURL, b.Bytes(), "text/html")
+}
+
+// APTPackage is a package of an APT Packages index
+type APTPackage struct {
+	Name     string
+	Version  string
+	Arch     string
+	Filename string // relative to the repository
+	Size     uint64
+	SHA256   string // listed if not empty
+}
+
+// AddAPTPackages serves a xz compressed Packages index of the given packages
+// at the given URL, and the uncompressed index (the URL without .xz)
+func (s *Server) AddAPTPackages(rawURL string, pkgs ...APTPackage) {
+	index := aptPackages(pkgs...)
+
+	var out bytes.Buffer
+	xw, err := tarxz.NewXZWriter(&out, tarxz.Options{Level: 0})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := xw.Write(index); err != nil {
+		panic(err)
+	}
+	if err := xw.Close(); err != nil {
+		panic(err)
+	}
+
+	s.Add(rawURL, out.Bytes(), "application/x-xz")
+	s.Add(strings.TrimSuffix(rawURL, ".xz"), index, "text/plain")
+}
+
+// AddAPTPdiff serves the pdiff index (<index>.diff/Index) of an uncompressed
+// Packages index already served at the given URL, with a single (merged) patch
+// from the index of the given packages
+func (s *Server) AddAPTPdiff(rawURL string, from ...APTPackage) {
+	cur := s.data(rawURL)
+	old := aptPackages(from...)
+	script := edScript(old, cur)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(script)
+	zw.Close()
+
+	name := "T-2024-01-02-0000.00-F-2024-01-01-0000.00"
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "SHA256-Current: %x %d\n", sha256.Sum256(cur), len(cur))
+	fmt.Fprintf(&b, "SHA256-History:\n %x %d %s\n", sha256.Sum256(old), len(old), name)
+	fmt.Fprintf(&b, "SHA256-Patches:\n %x %d %s\n", sha256.Sum256(script), len(script), name)
+	fmt.Fprintf(&b, "SHA256-Download:\n %x %d %s.gz\n", sha256.Sum256(gz.Bytes()), gz.Len(), name)
+	b.WriteString("X-Patch-Precedence: merged\n")
+
+	s.Add(rawURL+".diff/Index", b.Bytes(), "text/plain")
+	s.Add(rawURL+".diff/"+name+".gz", gz.Bytes(), "application/octet-stream")
+}
+
+// aptPackages returns the uncompressed Packages index of the given packages
+func aptPackages(pkgs ...APTPackage) []byte {
+	var b bytes.Buffer
+	for _, p := range pkgs {
+		fmt.Fprintf(&b, "Package: %s\nArchitecture: %s\nVersion: %s\nFilename: %s\nSize: %d\n",
+			p.Name, p.Arch, p.Version, p.Filename, p.Size)
+		if p.SHA256 != "" {
+			fmt.Fprintf(&b, "SHA256: %s\n", p.SHA256)
+		}
+		b.WriteString("Description: fake package\n\n")
+	}
+	return b.Bytes()
+}
+
+// edScript returns an ed script (as written by diff --ed) turning old into
+// cur, with a single command replacing what is between their common first and
+// last lines
+func edScript(old []byte, cur []byte) []byte {
+	a, b := lines(old), lines(cur)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	removed, added := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	op := 'c'
+	switch {
+	case len(removed) == 0 && len(added) == 0:
+		return nil
+	case len(removed) == 0:
+		op = 'a'
+	case len(added) == 0:
+		op = 'd'
+	}
+
+	var out bytes.Buffer
+	switch {
+	case op == 'a':
+		fmt.Fprintf(&out, "%da\n", prefix)
+	case len(removed) == 1:
+		fmt.Fprintf(&out, "%d%c\n", prefix+1, op)
+	default:
+		fmt.Fprintf(&out, "%d,%d%c\n", prefix+1, prefix+len(removed), op)
+	}
+	if len(added) > 0 {
+		for _, l := range added {
+			out.WriteString(l)
+		}
+		out.WriteString(".\n")
+	}
+	return out.Bytes()
+}
+
+// lines splits data into lines, with their newline
+func lines(data []byte) []string {
+	var l []string
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) > 0 {
+			l = append(l, string(line))
+		}
+	}
+	return l
+}
+
+// ByHashURL returns the by-hash URL of an index served in an APT suite (see
+// AddInRelease)
+func (s *Server) ByHashURL(suiteURL string, indexPath string) string {
+	return fmt.Sprintf("%s/%s/by-hash/SHA256/%x", suiteURL, path.Dir(indexPath), sha256.Sum256(s.data(suiteURL+"/"+indexPath)))
+}
+
+// data returns the data served at the given URL
+func (s *Server) data(rawURL string) []byte {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	f, ok := s.files[u.Host+u.Path]
+	if !ok {
+		panic("not served: " + rawURL)
+	}
+	return f.data
+}
+
+// AddInRelease serves the InRelease file of an APT suite (URL ending with
+// dists/<suite>) listing the given indexes, already served (paths relative to
+// the suite, like main/binary-amd64/Packages.xz), and their by-hash copies
+func (s *Server) AddInRelease(suiteURL string, paths ...string) {
+	var b bytes.Buffer
+	b.WriteString("Origin: fakerepo\nAcquire-By-Hash: yes\nSHA256:\n")
+	for _, p := range paths {
+		data := s.data(suiteURL + "/" + p)
+		fmt.Fprintf(&b, " %x %d %s\n", sha256.Sum256(data), len(data), p)
+		s.Add(s.ByHashURL(suiteURL, p), data, "application/octet-stream")
+	}
+
+	s.Add(suiteURL+"/InRelease", b.Bytes(), "text/plain")
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	s.mtx.Lock()
+	f, ok := s.files[key]
+	s.mtx.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := fmt.Sprintf("\"%x\"", sha256.Sum256(f.data))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	s.mtx.Lock()
+	s.fetches[key]++
+	s.mtx.Unlock()
+
+	w.Header().Set("Content-Type", f.contentType)
+	w.Write(f.data)
+}
+
+// rewriter sends all the requests to the server, with the requested host as
+// the first element of the path
+type rewriter struct {
+	srv *httptest.Server
+}
+
+func (rw *rewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(rw.srv.URL)
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = u.Scheme
+	out.URL.Host = u.Host
+	out.URL.Path = "/" + req.URL.Host + req.URL.Path
+	out.URL.RawPath = ""
+	out.Host = u.Host
+
+	return rw.srv.Client().Transport.RoundTrip(out)
+}
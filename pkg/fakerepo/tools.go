@@ -0,0 +1,199 @@
+package fakerepo
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tools are the canned answers of the fake command line tools. The fake
+// pahole is PaholeVersion, and writes the BTF data of BTFFor the byte order of
+// its input (BTF if the input isn't an ELF file).
+type Tools struct {
+	// Outputs are the outputs of the commands, by tool and first argument
+	// that isn't a flag (e.g. "yum search", "zypper repos",
+	// "repoquery kernel-debuginfo"). Commands without an output succeed
+	// silently.
+	Outputs map[string]string
+
+	// Packages are the package files to download, by the package name given
+	// to yumdownloader or the name=version given to zypper install
+	Packages map[string]string
+
+	// ZyppDir is where zypper install --download-only puts the packages
+	ZyppDir string
+}
+
+// toolNames are the fake tools put on PATH
+var toolNames = []string{
+	"pahole",
+	"sudo",
+	"yum",
+	"yumdownloader",
+	"repoquery",
+	"subscription-manager",
+	"zypper",
+}
+
+// PaholeVersion is the version printed by the fake pahole
+const PaholeVersion = "v1.25"
+
+const (
+	toolEnv  = "BTFHUB_FAKE_TOOL"
+	toolsEnv = "BTFHUB_FAKE_TOOLS"
+)
+
+// InstallTools puts the fake tools first on PATH for the duration of the test.
+// They run the test binary, so the TestMain of the package must call Main.
+func InstallTools(t testing.TB, tools Tools) {
+	t.Helper()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	data, err := json.Marshal(tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolsPath := filepath.Join(dir, "tools.json")
+	if err := os.WriteFile(toolsPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range toolNames {
+		script := fmt.Sprintf("#!/bin/sh\nexport %s=%s %s=%s\nexec %s \"$@\"\n", toolEnv, name, toolsEnv, toolsPath, exe)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// Main runs the fake tool the test binary was started as, if any, and exits.
+// It must be called first from TestMain.
+func Main() {
+	name := os.Getenv(toolEnv)
+	if name == "" {
+		return
+	}
+
+	if err := runTool(name, os.Args[1:]); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "fake %s: %s\n", name, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func runTool(name string, args []string) error {
+	data, err := os.ReadFile(os.Getenv(toolsEnv))
+	if err != nil {
+		return err
+	}
+	var tools Tools
+	if err := json.Unmarshal(data, &tools); err != nil {
+		return err
+	}
+
+	var params []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			params = append(params, arg)
+		}
+	}
+
+	switch name {
+	case "sudo":
+		if len(args) == 0 {
+			return errors.New("no command")
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		return cmd.Run()
+
+	case "pahole":
+		if len(args) == 1 && args[0] == "--version" {
+			fmt.Println(PaholeVersion)
+			return nil
+		}
+		out := flagValue(args, "--btf_encode_detached")
+		if out == "" {
+			return errors.New("no --btf_encode_detached output")
+		}
+		data := BTF()
+		if ef, err := elf.Open(args[len(args)-1]); err == nil {
+			data = BTFFor(ef.ByteOrder) // like pahole, in the byte order of the input
+			ef.Close()
+		}
+		return os.WriteFile(out, data, 0644)
+
+	case "yumdownloader":
+		destdir := flagValue(args, "--destdir")
+		if destdir == "" || len(params) == 0 {
+			return errors.New("no package or destdir")
+		}
+		pkg := params[len(params)-1]
+		return copyPackage(tools, pkg, filepath.Join(destdir, pkg+".rpm"))
+
+	case "zypper":
+		if len(params) > 0 && params[0] == "install" {
+			pkg := params[len(params)-1]
+			src, ok := tools.Packages[pkg]
+			if !ok {
+				return fmt.Errorf("no package %s", pkg)
+			}
+			if err := os.MkdirAll(tools.ZyppDir, 0775); err != nil {
+				return err
+			}
+			return copyPackage(tools, pkg, filepath.Join(tools.ZyppDir, filepath.Base(src)))
+		}
+	}
+
+	key := name
+	if len(params) > 0 {
+		key += " " + params[0]
+	}
+	fmt.Print(tools.Outputs[key])
+
+	return nil
+}
+
+// flagValue returns the value of a flag given as "--flag value" or
+// "--flag=value"
+func flagValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+		if v, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func copyPackage(tools Tools, pkg string, dest string) error {
+	src, ok := tools.Packages[pkg]
+	if !ok {
+		return fmt.Errorf("no package %s", pkg)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dest, data, 0644)
+}
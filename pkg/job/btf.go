@@ -4,59 +4,270 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/metrics"
+	"github.com/aquasecurity/btfhub/pkg/output"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
+	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
 type BTFGenerationJob struct {
+	Target      report.Target
+	Kernel      string
 	VmlinuxPath string
 	BTFPath     string
-	BTFTarPath  string
+	OutPath     string
+	Encoder     output.Encoder // output.Default if nil
+	Store       *archive.Store // content addressed store (optional)
+	Pahole      *Pahole
+	Regenerate  bool // run pahole even if the store knows the kernel image
+
+	ModulesDir    string // extracted kernel modules (optional)
+	ModulesOutDir string // split BTF files of the kernel modules
 }
 
 // Do implements the Job interface, and is called by the worker. It generates a
-// BTF file from a vmlinux file, compresses it into a .tar.xz file, and removes
-// the vmlinux file.
-func (job *BTFGenerationJob) Do(ctx context.Context) error {
+// BTF file from a vmlinux file, writes it in the output format (a .tar.xz file
+// by default), and removes the vmlinux file. With a store, pahole is skipped
+// for kernel images seen before, and the output references the stored BTF.
+// The split BTF files of the extracted kernel modules are written before the
+// kernel one, so an archived kernel always has its modules done.
+func (job *BTFGenerationJob) Do(ctx context.Context) (err error) {
+
+	kernel := job.Kernel
+	start := time.Now()
+
+	defer func() {
+		report.Default.Elapsed(job.Target, time.Since(start))
+		if err != nil {
+			metrics.PackagesFailed.WithLabelValues(job.Target.Distro).Inc()
+			report.Default.Failed(job.Target, kernel, err)
+		}
+	}()
+
+	// Reuse the BTF file of an identical kernel image, if already generated
+
+	var vmlinuxSum, btfSum string
+
+	if job.Store != nil {
+		if vmlinuxSum, err = utils.FileSHA256(job.VmlinuxPath); err != nil {
+			return fmt.Errorf("vmlinux checksum: %s", err)
+		}
+		if sum, ok := job.Store.Lookup(vmlinuxSum); ok && !job.Regenerate {
+			if err := job.Store.Get(sum, job.BTFPath); err != nil {
+				log.Printf("ERROR: store: %s\n", err)
+			} else {
+				log.Printf("DEBUG: reusing BTF %s for %s\n", sum, kernel)
+				btfSum = sum
+			}
+		}
+	}
 
 	// Generate the BTF file from the vmlinux file
 
-	log.Printf("DEBUG: generating BTF from %s\n", job.VmlinuxPath)
-	btfGenStart := time.Now()
+	if btfSum == "" {
+		log.Printf("DEBUG: generating BTF from %s\n", job.VmlinuxPath)
+		btfGenStart := time.Now()
+
+		if err := job.Pahole.GenerateBTF(ctx, job.VmlinuxPath, job.BTFPath); err != nil {
+			os.Remove(job.BTFPath)
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("btf gen: %s", err)
+		}
+
+		// The BTF file must be in the byte order of the target kernel, not of
+		// the host running pahole, to be loaded by it
+		if err := checkByteOrder(job.BTFPath, job.Target.Arch); err != nil {
+			os.Remove(job.BTFPath)
+			return fmt.Errorf("btf gen: %s", err)
+		}
+
+		metrics.ObserveStage(metrics.StagePahole, btfGenStart)
+		log.Printf("DEBUG: finished generating BTF from %s in %s\n", job.VmlinuxPath, time.Since(btfGenStart))
 
-	if err := GenerateBTF(ctx, job.VmlinuxPath, job.BTFPath); err != nil {
-		os.Remove(job.BTFPath)
-		if errors.Is(err, context.Canceled) {
-			return nil
+		if job.Store != nil {
+			tc := archive.Toolchain{Pahole: job.Pahole.Version.String(), PaholeFlags: job.Pahole.Flags}
+			if btfSum, err = job.Store.Put(job.BTFPath, tc); err != nil {
+				return err
+			}
+			if err := job.Store.Link(vmlinuxSum, btfSum); err != nil {
+				return fmt.Errorf("store: %s", err)
+			}
 		}
-		return fmt.Errorf("btf gen: %s", err)
 	}
 
-	log.Printf("DEBUG: finished generating BTF from %s in %s\n", job.VmlinuxPath, time.Since(btfGenStart))
+	encoder := job.Encoder
+	if encoder == nil {
+		encoder = output.Default
+	}
+
+	// Generate the split BTF files of the kernel modules
 
-	// Compress BTF file into a .tar.xz file
+	if job.ModulesDir != "" {
+		if err := job.generateModules(ctx, encoder); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("modules btf gen: %s", err)
+		}
+	}
 
-	log.Printf("DEBUG: compressing BTF into %s\n", job.BTFTarPath)
-	tarCompressStart := time.Now()
+	// Encode BTF file into the output format (written to a temporary path, next
+	// to the final one, and renamed so a partial output is never visible)
 
-	if err := pkg.TarballBTF(ctx, job.BTFPath, job.BTFTarPath); err != nil {
-		os.Remove(job.BTFTarPath)
-		return fmt.Errorf("btf.tar.xz gen: %s", err)
+	log.Printf("DEBUG: encoding BTF into %s\n", job.OutPath)
+	encodeStart := time.Now()
+
+	tmpPath := archive.TempPath(job.OutPath)
+	artifact := output.Artifact{
+		Target:  job.Target,
+		Kernel:  kernel,
+		BTFPath: job.BTFPath,
+		OutPath: tmpPath,
+	}
+	if _, zst := encoder.(output.Zstd); zst && job.Store != nil {
+		err = job.Store.Symlink(btfSum, tmpPath) // the blobs are .btf.zst files
+	} else {
+		err = encoder.Encode(ctx, artifact)
+	}
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return fmt.Errorf("%s gen: %s", encoder.Name(), err)
 	}
+	if err := replace(tmpPath, job.OutPath); err != nil {
+		os.RemoveAll(tmpPath)
+		return fmt.Errorf("%s rename: %s", encoder.Name(), err)
+	}
+
+	metrics.ObserveStage(metrics.StageEncode, encodeStart)
+	log.Printf("DEBUG: finished encoding BTF into %s in %s\n", job.OutPath, time.Since(encodeStart))
 
-	log.Printf("DEBUG: finished compressing BTF into %s in %s\n", job.BTFTarPath, time.Since(tarCompressStart))
+	// Record the toolchain that generated the BTF file (the one of the blob,
+	// for a BTF file of the store) and, with a store, its checksum
+
+	if job.Store != nil {
+		err = job.Store.Reference(job.OutPath, btfSum)
+	} else {
+		err = archive.RecordFile(job.OutPath, func(me *archive.ManifestEntry) {
+			me.Pahole = job.Pahole.Version.String()
+			me.PaholeFlags = job.Pahole.Flags
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("manifest: %s", err)
+	}
 
 	// Remove valid files on success (keep files on fail to enable resuming)
 
 	os.Remove(job.BTFPath)
 	os.Remove(job.VmlinuxPath)
+	if job.ModulesDir != "" {
+		os.RemoveAll(job.ModulesDir)
+	}
+
+	report.Default.Generated(job.Target, kernel)
 
 	return nil
 }
 
+// generateModules generates the split BTF files of the extracted kernel
+// modules, against the BTF file of the kernel, and writes them in the output
+// format to ModulesOutDir. A failing module is logged and skipped (the BTF
+// file of the kernel is still useful without it).
+func (job *BTFGenerationJob) generateModules(ctx context.Context, encoder output.Encoder) error {
+	modules, err := filepath.Glob(filepath.Join(job.ModulesDir, "*"+pkg.ModuleExt))
+	if err != nil || len(modules) == 0 {
+		return err
+	}
+	if err := os.MkdirAll(job.ModulesOutDir, 0775); err != nil {
+		return err
+	}
+
+	for _, m := range modules {
+		module := strings.TrimSuffix(filepath.Base(m), pkg.ModuleExt)
+		btfPath := filepath.Join(job.ModulesDir, module+archive.PartialBTFExt)
+		outPath := filepath.Join(job.ModulesOutDir, module+encoder.Ext())
+		tmpPath := archive.TempPath(outPath)
+
+		log.Printf("DEBUG: generating BTF from %s\n", m)
+
+		err := job.Pahole.GenerateModuleBTF(ctx, job.BTFPath, m, btfPath)
+		if err == nil {
+			err = encoder.Encode(ctx, output.Artifact{
+				Target:  job.Target,
+				Kernel:  job.Kernel,
+				Module:  module,
+				BTFPath: btfPath,
+				OutPath: tmpPath,
+			})
+		}
+		if err == nil {
+			err = replace(tmpPath, outPath)
+		}
+		os.Remove(btfPath)
+		if err != nil {
+			os.RemoveAll(tmpPath)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("ERROR: %s: module %s: %s\n", job.Kernel, module, err)
+		}
+	}
+
+	return nil
+}
+
+// checkByteOrder checks that the BTF file is in the byte order of the given
+// architecture (unchecked if unknown)
+func checkByteOrder(btfPath string, arch string) error {
+	a, ok := kernel.GetArch(arch)
+	if !ok {
+		return nil
+	}
+
+	f, err := os.Open(btfPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return fmt.Errorf("read BTF header: %s", err)
+	}
+	order, err := kernel.BTFByteOrder(hdr)
+	if err != nil {
+		return err
+	}
+	if order != a.ByteOrder {
+		return fmt.Errorf("BTF byte order %s doesn't match %s (%s)", order, arch, a.ByteOrder)
+	}
+
+	return nil
+}
+
+// replace renames the temporary output into place. A directory (OCI image
+// layout) can't be renamed over, so an existing output one is removed first.
+func replace(tmpPath string, outPath string) error {
+	if fi, err := os.Lstat(outPath); err == nil && fi.IsDir() {
+		if err := os.RemoveAll(outPath); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpPath, outPath)
+}
+
 func (job *BTFGenerationJob) Reply() chan<- interface{} {
 	return nil
 }
@@ -0,0 +1,59 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/corecheck"
+)
+
+// CORECheckJob checks the CO-RE relocations of a BPF object against an
+// archived BTF file. It replies with a *CORECheckResult.
+type CORECheckJob struct {
+	Entry     archive.Entry
+	Object    string
+	ReplyChan chan interface{}
+}
+
+// CORECheckResult holds the relocations of a BPF object that can't be
+// resolved against an archived kernel
+type CORECheckResult struct {
+	Entry      archive.Entry
+	Unresolved []*corecheck.Relocation
+	Err        error
+}
+
+// Do implements the Job interface, and is called by the worker. The object is
+// loaded by every job, so no BTF spec is shared between the workers.
+func (job *CORECheckJob) Do(ctx context.Context) error {
+	result := &CORECheckResult{Entry: job.Entry}
+	result.Unresolved, result.Err = job.check()
+
+	job.ReplyChan <- result
+
+	return nil
+}
+
+func (job *CORECheckJob) check() ([]*corecheck.Relocation, error) {
+	obj, err := corecheck.Load(job.Object)
+	if err != nil {
+		return nil, err
+	}
+	data, err := archive.ReadBTF(job.Entry.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %s", job.Entry.Path, err)
+	}
+	kernel, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s", job.Entry.Path, err)
+	}
+	return obj.Unresolved(kernel), nil
+}
+
+func (job *CORECheckJob) Reply() chan<- interface{} {
+	return job.ReplyChan
+}
@@ -8,54 +8,101 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/metrics"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
 type KernelExtractionJob struct {
-	Pkg       pkg.Package
-	WorkDir   string
-	ReplyChan chan interface{}
-	Force     bool
+	Target     report.Target
+	Pkg        pkg.Package
+	WorkDir    string
+	ReplyChan  chan interface{}
+	Force      bool
+	Modules    []string // kernel modules to extract (allow-list)
+	ModulesDir string   // where the kernel modules are extracted to
+
+	Cache *archive.PackageCache // downloaded packages (optional)
+	Slot  *utils.HostSlot       // request slot of the download (optional)
 }
 
 // Do implements the Job interface, and is called by the worker. It downloads
-// the kernel package, extracts the vmlinux file, and replies with the path to
-// the vmlinux file in the reply channel.
+// the kernel package, extracts the vmlinux file (and the kernel modules in the
+// allow-list, if the package ships them), and replies with the path to the
+// vmlinux file in the reply channel.
 func (job *KernelExtractionJob) Do(ctx context.Context) error {
+	ctx = utils.WithHostSlot(ctx, job.Slot)
 
 	vmlinuxName := fmt.Sprintf("vmlinux-%s", job.Pkg.Filename())
 	vmlinuxPath := filepath.Join(job.WorkDir, vmlinuxName)
 
-	if !job.Force && utils.Exists(vmlinuxPath) {
-		job.ReplyChan <- vmlinuxPath // already extracted, reply with path
+	me, withModules := job.Pkg.(pkg.ModulesExtractor)
+	withModules = withModules && len(job.Modules) > 0
+
+	// already extracted (along with the kernel modules, if requested), reply
+	// with path
+
+	if !job.Force && utils.Exists(vmlinuxPath) && (!withModules || utils.Exists(job.ModulesDir)) {
+		job.ReplyChan <- vmlinuxPath
 		return nil
 	}
 
-	// Download the kernel package
+	start := time.Now()
+	defer func() {
+		report.Default.Elapsed(job.Target, time.Since(start))
+	}()
 
-	downloadStart := time.Now()
-	log.Printf("DEBUG: downloading %s\n", job.Pkg)
+	// Get the kernel package from the cache, or download it (and cache it)
 
-	kernPkgPath, err := job.Pkg.Download(ctx, job.WorkDir, job.Force)
+	kernPkgPath, cached, err := job.cached()
 	if err != nil {
-		os.Remove(kernPkgPath)
-		return err
+		log.Printf("ERROR: package cache: %s\n", err)
 	}
 
-	log.Printf("DEBUG: finished downloading %s in %s\n", job.Pkg, time.Since(downloadStart))
+	if !cached {
+		downloadStart := time.Now()
+		log.Printf("DEBUG: downloading %s\n", job.Pkg)
+
+		kernPkgPath, err = job.Pkg.Download(ctx, job.WorkDir, job.Force)
+		if err != nil {
+			os.Remove(kernPkgPath)
+			return err
+		}
+
+		metrics.ObserveStage(metrics.StageDownload, downloadStart)
+		if fi, err := os.Stat(kernPkgPath); err == nil {
+			report.Default.Downloaded(job.Target, uint64(fi.Size()))
+		}
+		log.Printf("DEBUG: finished downloading %s in %s\n", job.Pkg, time.Since(downloadStart))
+
+		if job.Cache != nil {
+			if err := job.Cache.Put(job.cacheKey(), kernPkgPath); err != nil {
+				log.Printf("ERROR: package cache: %s\n", err)
+			}
+		}
+	}
 
 	// Extract downloaded kernel package
 
 	extractStart := time.Now()
 	log.Printf("DEBUG: extracting vmlinux from %s\n", kernPkgPath)
 
-	err = job.Pkg.ExtractKernel(ctx, kernPkgPath, vmlinuxPath)
+	if withModules {
+		err = me.ExtractKernelModules(ctx, kernPkgPath, vmlinuxPath, job.Modules, job.ModulesDir)
+	} else {
+		err = job.Pkg.ExtractKernel(ctx, kernPkgPath, vmlinuxPath)
+	}
 	if err != nil {
 		os.Remove(vmlinuxPath)
+		if job.ModulesDir != "" {
+			os.RemoveAll(job.ModulesDir)
+		}
 		return fmt.Errorf("extracting vmlinux from %s: %s", vmlinuxPath, err)
 	}
 
+	metrics.ObserveStage(metrics.StageExtract, extractStart)
 	log.Printf("DEBUG: finished extracting from %s in %s\n", kernPkgPath, time.Since(extractStart))
 
 	os.Remove(kernPkgPath) // remove downloaded kernel package
@@ -67,6 +114,29 @@ func (job *KernelExtractionJob) Do(ctx context.Context) error {
 	return nil
 }
 
+// cacheKey is the key of the package in the cache: its checksum, if known, or
+// else its name and full version in the release of the distribution (package
+// names are only unique within a release, e.g. the Ubuntu HWE kernels)
+func (job *KernelExtractionJob) cacheKey() string {
+	if cp, ok := job.Pkg.(pkg.ChecksumPackage); ok && cp.Checksum() != "" {
+		return archive.ChecksumKey(cp.Checksum())
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", job.Target.Distro, job.Target.Release, job.Pkg, job.Pkg.Version())
+}
+
+// cached takes the package out of the cache, if there, and returns its path
+func (job *KernelExtractionJob) cached() (string, bool, error) {
+	if job.Cache == nil || job.Force {
+		return "", false, nil
+	}
+	path := filepath.Join(job.WorkDir, job.Pkg.Filename()+archive.CachedPackageExt)
+	ok, err := job.Cache.Get(job.cacheKey(), path)
+	if ok {
+		log.Printf("DEBUG: using cached package %s\n", job.Pkg)
+	}
+	return path, ok, err
+}
+
 func (job *KernelExtractionJob) Reply() chan<- interface{} {
 	return job.ReplyChan
 }
@@ -0,0 +1,51 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// MinCoreBTFJob generates a BTF file, tailored to a set of BPF objects, out of
+// an archived BTF file (bpftool gen min_core_btf).
+type MinCoreBTFJob struct {
+	ArchivePath string // archived BTF file, in any output format
+	OutPath     string
+	Objects     []string
+	Runner      utils.CommandRunner // runs bpftool
+}
+
+// Do implements the Job interface, and is called by the worker. It extracts
+// the archived BTF file into a temporary file and generates the tailored BTF
+// file from it.
+func (job *MinCoreBTFJob) Do(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(job.OutPath), 0775); err != nil {
+		return fmt.Errorf("output dir: %s", err)
+	}
+
+	fullBTFPath := job.OutPath + ".full"
+	defer os.Remove(fullBTFPath)
+
+	if err := archive.ExtractBTF(job.ArchivePath, fullBTFPath); err != nil {
+		return fmt.Errorf("extract %s: %s", job.ArchivePath, err)
+	}
+
+	args := append([]string{"gen", "min_core_btf", fullBTFPath, job.OutPath}, job.Objects...)
+	if _, err := job.Runner.Run(ctx, utils.Command{Name: "bpftool", Args: args}); err != nil {
+		os.Remove(job.OutPath)
+		return err
+	}
+
+	log.Printf("INFO: generated %s\n", job.OutPath)
+
+	return nil
+}
+
+func (job *MinCoreBTFJob) Reply() chan<- interface{} {
+	return nil
+}
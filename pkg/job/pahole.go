@@ -0,0 +1,115 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// PaholeVersion is a pahole (dwarves) version, like v1.25
+type PaholeVersion struct {
+	Major, Minor int
+}
+
+// ParsePaholeVersion parses the output of pahole --version (e.g. v1.25)
+func ParsePaholeVersion(s string) (PaholeVersion, error) {
+	var v PaholeVersion
+	s = strings.TrimSpace(s)
+	if _, err := fmt.Sscanf(strings.TrimPrefix(s, "v"), "%d.%d", &v.Major, &v.Minor); err != nil {
+		return v, fmt.Errorf("pahole version %q: %s", s, err)
+	}
+	return v, nil
+}
+
+func (v PaholeVersion) String() string {
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+// Less reports whether the version is older than the given one
+func (v PaholeVersion) Less(o PaholeVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	return v.Minor < o.Minor
+}
+
+// MinPaholeVersion is the oldest pahole able to write detached BTF files
+// (--btf_encode_detached)
+var MinPaholeVersion = PaholeVersion{1, 22}
+
+// paholeFlags are the encoding flags, by the version that introduced them
+var paholeFlags = []struct {
+	since PaholeVersion
+	flag  string
+}{
+	{PaholeVersion{1, 21}, "--btf_gen_floats"},
+	{PaholeVersion{1, 25}, "--skip_encoding_btf_inconsistent_proto"},
+	{PaholeVersion{1, 25}, "--btf_gen_optimized"},
+}
+
+// Pahole is the pahole toolchain generating the BTF files: its version, and
+// the encoding flags given to it (all the ones supported by the version)
+type Pahole struct {
+	Runner  utils.CommandRunner
+	Version PaholeVersion
+	Flags   []string
+}
+
+// NewPahole detects the version of the pahole run by the given runner, and
+// picks the encoding flags for it. Versions older than MinPaholeVersion are
+// refused.
+func NewPahole(ctx context.Context, runner utils.CommandRunner) (*Pahole, error) {
+	out, err := runner.Run(ctx, utils.Command{Name: "pahole", Args: []string{"--version"}})
+	if err != nil {
+		return nil, err
+	}
+	version, err := ParsePaholeVersion(out.String())
+	if err != nil {
+		return nil, err
+	}
+	if version.Less(MinPaholeVersion) {
+		return nil, fmt.Errorf("pahole %s is older than %s", version, MinPaholeVersion)
+	}
+
+	p := &Pahole{Runner: runner, Version: version}
+	for _, f := range paholeFlags {
+		if !version.Less(f.since) {
+			p.Flags = append(p.Flags, f.flag)
+		}
+	}
+
+	return p, nil
+}
+
+// Stale reports whether the BTF file of the given manifest entry wasn't
+// generated by this toolchain: by another version, with other flags, or by an
+// unknown one (no entry, or no recorded toolchain)
+func (p *Pahole) Stale(me *archive.ManifestEntry) bool {
+	if me == nil || me.Pahole != p.Version.String() {
+		return true
+	}
+	return !slices.Equal(me.PaholeFlags, p.Flags)
+}
+
+// GenerateBTF generates a BTF file from a vmlinux file
+func (p *Pahole) GenerateBTF(ctx context.Context, vmlinux string, out string) error {
+	return p.run(ctx, "--btf_encode_detached", out, vmlinux)
+}
+
+// GenerateModuleBTF generates the split BTF file of a kernel module, holding
+// only the types not found in the (base) BTF file of the kernel
+func (p *Pahole) GenerateModuleBTF(ctx context.Context, baseBTF string, module string, out string) error {
+	return p.run(ctx, "--btf_base", baseBTF, "--btf_encode_detached", out, module)
+}
+
+func (p *Pahole) run(ctx context.Context, args ...string) error {
+	_, err := p.Runner.Run(ctx, utils.Command{
+		Name: "pahole",
+		Args: append(append([]string(nil), p.Flags...), args...),
+	})
+	return err
+}
@@ -0,0 +1,50 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// versionRunner answers pahole --version with the given version
+type versionRunner string
+
+func (r versionRunner) Run(_ context.Context, _ utils.Command) (*bytes.Buffer, error) {
+	return bytes.NewBufferString(string(r) + "\n"), nil
+}
+
+func TestNewPahole(t *testing.T) {
+	tests := []struct {
+		version string
+		flags   []string
+		err     bool
+	}{
+		{version: "v1.21", err: true},
+		{version: "v1.22", flags: []string{"--btf_gen_floats"}},
+		{version: "v1.25", flags: []string{"--btf_gen_floats", "--skip_encoding_btf_inconsistent_proto", "--btf_gen_optimized"}},
+		{version: "v2.0", flags: []string{"--btf_gen_floats", "--skip_encoding_btf_inconsistent_proto", "--btf_gen_optimized"}},
+		{version: "unknown", err: true},
+	}
+	for _, tt := range tests {
+		p, err := NewPahole(context.Background(), versionRunner(tt.version))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error", tt.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.version, err)
+			continue
+		}
+		if p.Version.String() != tt.version {
+			t.Errorf("%s: version %s", tt.version, p.Version)
+		}
+		if !reflect.DeepEqual(p.Flags, tt.flags) {
+			t.Errorf("%s: flags %v, want %v", tt.version, p.Flags, tt.flags)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package job
 import (
 	"context"
 	"log"
+
+	"github.com/aquasecurity/btfhub/pkg/metrics"
 )
 
 func StartWorker(ctx context.Context, jobchan <-chan Job) error {
@@ -14,7 +16,9 @@ func StartWorker(ctx context.Context, jobchan <-chan Job) error {
 			if !ok {
 				return nil
 			}
+			metrics.ActiveWorkers.Inc()
 			err := job.Do(ctx)
+			metrics.ActiveWorkers.Dec()
 			if err != nil {
 				if ch := job.Reply(); ch != nil {
 					ch <- err
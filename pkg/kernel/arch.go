@@ -0,0 +1,62 @@
+package kernel
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+)
+
+// Arch is a kernel architecture, as named in the archive
+type Arch struct {
+	Name      string
+	Machine   elf.Machine
+	ByteOrder binary.ByteOrder
+}
+
+// Archs are the architectures BTF files can be generated for
+var Archs = []Arch{
+	{Name: "x86_64", Machine: elf.EM_X86_64, ByteOrder: binary.LittleEndian},
+	{Name: "arm64", Machine: elf.EM_AARCH64, ByteOrder: binary.LittleEndian},
+	{Name: "ppc64le", Machine: elf.EM_PPC64, ByteOrder: binary.LittleEndian},
+	{Name: "s390x", Machine: elf.EM_S390, ByteOrder: binary.BigEndian},
+}
+
+// GetArch returns the architecture of the given name
+func GetArch(name string) (Arch, bool) {
+	for _, a := range Archs {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Arch{}, false
+}
+
+// elfArch returns the name of the architecture of an ELF file (empty if
+// unknown). The byte order tells ppc64le from the big-endian ppc64.
+func elfArch(ef *elf.File) string {
+	for _, a := range Archs {
+		if a.Machine == ef.Machine && a.ByteOrder == ef.ByteOrder {
+			return a.Name
+		}
+	}
+	return ""
+}
+
+// btfMagic is the magic number of the BTF header, in the byte order of the
+// BTF data
+const btfMagic = 0xeb9f
+
+// BTFByteOrder returns the byte order of raw BTF data (only its header is
+// needed), told by the magic number
+func BTFByteOrder(data []byte) (binary.ByteOrder, error) {
+	if len(data) < 2 {
+		return nil, errors.New("BTF header too short")
+	}
+	switch {
+	case binary.LittleEndian.Uint16(data) == btfMagic:
+		return binary.LittleEndian, nil
+	case binary.BigEndian.Uint16(data) == btfMagic:
+		return binary.BigEndian, nil
+	}
+	return nil, errors.New("not BTF data (bad magic)")
+}
@@ -0,0 +1,23 @@
+package kernel
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/fakerepo"
+)
+
+func TestBTFByteOrder(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		got, err := BTFByteOrder(fakerepo.BTFFor(order))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != order {
+			t.Errorf("got %s, expected %s", got, order)
+		}
+	}
+	if _, err := BTFByteOrder([]byte("\x7fELF")); err == nil {
+		t.Error("ELF data must not be BTF")
+	}
+}
@@ -0,0 +1,160 @@
+package kernel
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Info describes a vmlinux file (kernel image or debuginfo file)
+type Info struct {
+	Arch      string // architecture of the ELF machine and byte order (empty if unknown)
+	BTF       bool   // has a .BTF section
+	BTFEmpty  bool   // the .BTF section has no data (stripped or empty)
+	BTFIDs    bool   // has a .BTF_ids section
+	Debuglink string // file named by .gnu_debuglink (separated debuginfo)
+	BuildID   string // GNU build ID (hex)
+	Release   string // release of the linux_banner ("uname -r")
+}
+
+// HasBTF tells if the kernel ships its own BTF. A .BTF section without data
+// (a debuginfo file keeps the section headers of the stripped image) still
+// means the running kernel has BTF.
+func (i *Info) HasBTF() bool {
+	return i.BTF
+}
+
+// Separated tells if the file is a separated debuginfo file
+func (i *Info) Separated() bool {
+	return i.Debuglink != ""
+}
+
+const bannerPrefix = "Linux version "
+
+// Inspect reads the architecture, the BTF sections, the build ID and the
+// release of the given vmlinux file (of either byte order). Compressed
+// sections are read uncompressed. The release is left empty if the banner
+// isn't in the file (no symbols, or no .rodata data in a debuginfo file).
+func Inspect(path string) (*Info, error) {
+	ef, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("elf open: %s", err)
+	}
+	defer ef.Close()
+
+	info := &Info{Arch: elfArch(ef)}
+
+	if s := ef.Section(".BTF"); s != nil {
+		info.BTF = true
+		info.BTFEmpty = s.Type == elf.SHT_NOBITS || s.Size == 0
+	}
+	info.BTFIDs = ef.Section(".BTF_ids") != nil
+
+	if s := ef.Section(".gnu_debuglink"); s != nil && s.Type != elf.SHT_NOBITS {
+		data, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("read .gnu_debuglink: %s", err)
+		}
+		name, _, _ := bytes.Cut(data, []byte{0})
+		info.Debuglink = string(name)
+	}
+
+	if info.BuildID, err = buildID(ef); err != nil {
+		return nil, fmt.Errorf("build id: %s", err)
+	}
+	if info.Release, err = release(ef); err != nil {
+		return nil, fmt.Errorf("linux_banner: %s", err)
+	}
+
+	return info, nil
+}
+
+// buildID returns the GNU build ID of the ELF notes (empty if none)
+func buildID(ef *elf.File) (string, error) {
+	for _, s := range ef.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return "", err
+		}
+		for len(data) >= 12 {
+			namesz := ef.ByteOrder.Uint32(data[0:4])
+			descsz := ef.ByteOrder.Uint32(data[4:8])
+			typ := ef.ByteOrder.Uint32(data[8:12])
+			data = data[12:]
+
+			nameEnd := align4(namesz)
+			descEnd := nameEnd + align4(descsz)
+			if uint64(len(data)) < descEnd {
+				return "", fmt.Errorf("truncated note in %s", s.Name)
+			}
+			name := string(bytes.TrimRight(data[:namesz], "\x00"))
+			if name == "GNU" && typ == 3 { // NT_GNU_BUILD_ID
+				return hex.EncodeToString(data[nameEnd : nameEnd+uint64(descsz)]), nil
+			}
+			data = data[descEnd:]
+		}
+	}
+	return "", nil
+}
+
+func align4(n uint32) uint64 {
+	return (uint64(n) + 3) &^ 3
+}
+
+// release returns the kernel release of the linux_banner symbol (empty if the
+// symbol or its data isn't in the file)
+func release(ef *elf.File) (string, error) {
+	syms, err := ef.Symbols()
+	if errors.Is(err, elf.ErrNoSymbols) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, sym := range syms {
+		if sym.Name != "linux_banner" {
+			continue
+		}
+		if sym.Section == elf.SHN_UNDEF || int(sym.Section) >= len(ef.Sections) {
+			return "", nil
+		}
+		s := ef.Sections[sym.Section]
+		if s.Type == elf.SHT_NOBITS || sym.Value < s.Addr {
+			return "", nil
+		}
+		size := sym.Size
+		if size == 0 || size > 512 {
+			size = 512
+		}
+
+		r := s.Open()
+		if _, err := r.Seek(int64(sym.Value-s.Addr), io.SeekStart); err != nil {
+			return "", err
+		}
+		banner := make([]byte, size)
+		n, err := io.ReadFull(r, banner)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", err
+		}
+		banner, _, _ = bytes.Cut(banner[:n], []byte{0})
+
+		rest, found := strings.CutPrefix(string(banner), bannerPrefix)
+		if !found {
+			return "", fmt.Errorf("unexpected banner %q", banner)
+		}
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			return fields[0], nil
+		}
+		return "", fmt.Errorf("unexpected banner %q", banner)
+	}
+
+	return "", nil
+}
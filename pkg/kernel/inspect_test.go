@@ -0,0 +1,69 @@
+package kernel
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/fakerepo"
+)
+
+// The test files are tiny static binaries with a linux_banner, built with
+// gcc -Wl,--build-id, with sections added by objcopy: vmlinux-btf.elf has
+// .BTF and .BTF_ids sections, vmlinux-debuglink.elf an empty .BTF section and
+// a .gnu_debuglink section
+
+func TestInspect(t *testing.T) {
+	const buildID = "93e2601ad7f11c9649977648496b27a64a28988d"
+	const release = "5.4.0-42-generic"
+
+	info, err := Inspect("testdata/vmlinux-btf.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Info{Arch: "x86_64", BTF: true, BTFIDs: true, BuildID: buildID, Release: release}
+	if *info != expected {
+		t.Errorf("got %+v, expected %+v", *info, expected)
+	}
+
+	info, err = Inspect("testdata/vmlinux-debuglink.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = Info{Arch: "x86_64", BTF: true, BTFEmpty: true, Debuglink: "vmlinux", BuildID: buildID, Release: release}
+	if *info != expected {
+		t.Errorf("got %+v, expected %+v", *info, expected)
+	}
+	if !info.HasBTF() || !info.Separated() {
+		t.Errorf("%+v must have BTF and be separated", *info)
+	}
+}
+
+func TestInspectArch(t *testing.T) {
+	const release = "5.3.18-150300.59.5-default"
+
+	for _, tt := range []struct {
+		machine elf.Machine
+		order   binary.ByteOrder
+		arch    string
+	}{
+		{elf.EM_S390, binary.BigEndian, "s390x"},
+		{elf.EM_PPC64, binary.LittleEndian, "ppc64le"},
+		{elf.EM_PPC64, binary.BigEndian, ""}, // ppc64 isn't supported
+		{elf.EM_AARCH64, binary.LittleEndian, "arm64"},
+	} {
+		path := filepath.Join(t.TempDir(), "vmlinux")
+		if err := os.WriteFile(path, fakerepo.VmlinuxFor(release, tt.machine, tt.order), 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := Inspect(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Arch != tt.arch || info.Release != release {
+			t.Errorf("%s %s: got arch %q release %q, expected %q %q", tt.machine, tt.order, info.Arch, info.Release, tt.arch, release)
+		}
+	}
+}
@@ -22,6 +22,17 @@ func (k Version) String() string {
 	return k.str
 }
 
+// MarshalText implements encoding.TextMarshaler (so versions can be cached)
+func (k Version) MarshalText() ([]byte, error) {
+	return []byte(k.str), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (k *Version) UnmarshalText(text []byte) error {
+	*k = NewKernelVersion(string(text))
+	return nil
+}
+
 func (k Version) Less(j Version) bool {
 	vi, vj := k.ints, j.ints
 	for x, vni := range vi {
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "btfhub"
+
+// Stages of the BTF generation pipeline (used as the "stage" label)
+const (
+	StageDownload = "download"
+	StageExtract  = "extract"
+	StagePahole   = "pahole"
+	StageEncode   = "encode" // output format encoding (e.g. tar.xz)
+)
+
+// Reasons for skipping a package (used as the "reason" label)
+const (
+	SkipExists = "exists"
+	SkipFailed = "failed"
+	SkipHasBTF = "hasbtf"
+)
+
+var (
+	PackagesDiscovered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packages_discovered_total",
+		Help:      "Number of kernel packages discovered in the distribution repositories.",
+	}, []string{"distro"})
+
+	PackagesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packages_skipped_total",
+		Help:      "Number of kernel packages skipped, by reason.",
+	}, []string{"distro", "reason"})
+
+	PackagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packages_failed_total",
+		Help:      "Number of kernel packages that failed to be processed.",
+	}, []string{"distro"})
+
+	BytesDownloaded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Number of bytes downloaded from the distribution repositories.",
+	})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Time spent in each stage of the BTF generation pipeline.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14), // 0.5s to ~68m
+	}, []string{"stage"})
+
+	QueuedJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queued_jobs",
+		Help:      "Number of jobs waiting to be picked up by a worker.",
+	})
+
+	ActiveWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_workers",
+		Help:      "Number of workers currently running a job.",
+	})
+)
+
+// ObserveStage records the time elapsed since start for the given stage
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// Serve exposes the metrics at /metrics on the given address until the
+// context is canceled.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutCtx)
+	}()
+
+	log.Printf("INFO: serving metrics at %s/metrics\n", addr)
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
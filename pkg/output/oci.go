@@ -0,0 +1,282 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/DataDog/zstd"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+)
+
+// OCI media types (https://github.com/opencontainers/image-spec)
+const (
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeEmpty    = "application/vnd.oci.empty.v1+json"
+	ArtifactTypeBTF   = "application/vnd.btfhub.btf.v1"
+	MediaTypeBTFZstd  = "application/vnd.btfhub.btf.v1+zstd"
+)
+
+// Annotations of the BTF artifacts
+const (
+	AnnotationTitle   = "org.opencontainers.image.title"
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	AnnotationDistro  = "io.btfhub.distro"
+	AnnotationRelease = "io.btfhub.release"
+	AnnotationArch    = "io.btfhub.arch"
+	AnnotationKernel  = "io.btfhub.kernel"
+	AnnotationModule  = "io.btfhub.module"
+)
+
+// OCI writes an OCI image layout directory per kernel, holding an artifact
+// with the zstd compressed BTF file as its single layer. If Registry is set,
+// the artifact is also pushed to <Registry>/<distro>/<release>/<arch>:<kernel>
+// (<kernel>.<module> for the split BTF files of the kernel modules).
+type OCI struct {
+	Registry  string // e.g. localhost:5000/btfhub
+	PlainHTTP bool   // use http instead of https to talk to the registry
+	Client    *http.Client
+}
+
+func (*OCI) Name() string { return "oci" }
+func (*OCI) Ext() string  { return archive.OCIExt }
+
+// Descriptor describes an OCI blob
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Data         []byte            `json:"data,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Index is an OCI image index
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+var emptyConfig = []byte("{}")
+
+// Encode writes the OCI image layout to the artifact output path (a directory)
+// and pushes it to the registry, if one is configured
+func (o *OCI) Encode(ctx context.Context, a Artifact) error {
+	btf, err := os.ReadFile(a.BTFPath)
+	if err != nil {
+		return err
+	}
+	layer, err := zstd.CompressLevel(nil, btf, zstd.BestCompression)
+	if err != nil {
+		return fmt.Errorf("zstd: %s", err)
+	}
+
+	tag := Tag(a.Kernel)
+	if a.Module != "" {
+		tag = Tag(a.Kernel + "." + a.Module)
+	}
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		ArtifactType:  ArtifactTypeBTF,
+		Config:        descriptor(MediaTypeEmpty, emptyConfig),
+		Layers:        []Descriptor{descriptor(MediaTypeBTFZstd, layer)},
+		Annotations: map[string]string{
+			AnnotationDistro:  a.Target.Distro,
+			AnnotationRelease: a.Target.Release,
+			AnnotationArch:    a.Target.Arch,
+			AnnotationKernel:  a.Kernel,
+		},
+	}
+	if a.Module != "" {
+		manifest.Annotations[AnnotationModule] = a.Module
+	}
+	manifest.Config.Data = emptyConfig
+	manifest.Layers[0].Annotations = map[string]string{AnnotationTitle: a.Name() + ".btf.zst"}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestDesc := descriptor(MediaTypeManifest, manifestJSON)
+	manifestDesc.ArtifactType = ArtifactTypeBTF
+	manifestDesc.Annotations = map[string]string{AnnotationRefName: tag}
+
+	index, err := json.Marshal(Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeIndex,
+		Manifests:     []Descriptor{manifestDesc},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Image layout
+
+	blobs := map[string][]byte{
+		manifest.Config.Digest:    emptyConfig,
+		manifest.Layers[0].Digest: layer,
+		manifestDesc.Digest:       manifestJSON,
+	}
+	if err := os.MkdirAll(filepath.Join(a.OutPath, "blobs", "sha256"), 0775); err != nil {
+		return err
+	}
+	for digest, data := range blobs {
+		p := filepath.Join(a.OutPath, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+		if err := os.WriteFile(p, data, 0664); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(a.OutPath, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0664); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(a.OutPath, "index.json"), index, 0664); err != nil {
+		return err
+	}
+
+	if o.Registry == "" {
+		return nil
+	}
+
+	// Registry
+
+	repository := strings.Join([]string{o.Registry, a.Target.Distro, a.Target.Release, a.Target.Arch}, "/")
+	for _, d := range []Descriptor{manifest.Config, manifest.Layers[0]} {
+		if err := o.pushBlob(ctx, repository, d.Digest, blobs[d.Digest]); err != nil {
+			return fmt.Errorf("push %s: %s", repository, err)
+		}
+	}
+	if err := o.pushManifest(ctx, repository, tag, manifestJSON); err != nil {
+		return fmt.Errorf("push %s:%s: %s", repository, tag, err)
+	}
+
+	return nil
+}
+
+// pushBlob uploads a blob to the repository, unless it is already there
+func (o *OCI) pushBlob(ctx context.Context, repository string, digest string, data []byte) error {
+	base, name := o.splitRepository(repository)
+
+	resp, err := o.do(ctx, http.MethodHead, base+"/v2/"+name+"/blobs/"+digest, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = o.do(ctx, http.MethodPost, base+"/v2/"+name+"/blobs/uploads/", nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start upload: %s", resp.Status)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("upload location: %s", err)
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = o.do(ctx, http.MethodPut, location.String(), data, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload %s: %s", digest, resp.Status)
+	}
+
+	return nil
+}
+
+// pushManifest uploads the manifest to the repository with the given tag
+func (o *OCI) pushManifest(ctx context.Context, repository string, tag string, manifest []byte) error {
+	base, name := o.splitRepository(repository)
+
+	resp, err := o.do(ctx, http.MethodPut, base+"/v2/"+name+"/manifests/"+tag, manifest, MediaTypeManifest)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// splitRepository splits host[:port]/name into the registry base URL and the
+// repository name
+func (o *OCI) splitRepository(repository string) (string, string) {
+	host, name, _ := strings.Cut(repository, "/")
+	scheme := "https"
+	if o.PlainHTTP {
+		scheme = "http"
+	}
+	return (&url.URL{Scheme: scheme, Host: host}).String(), name
+}
+
+func (o *OCI) do(ctx context.Context, method string, url string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func descriptor(mediaType string, data []byte) Descriptor {
+	sum := sha256.Sum256(data)
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+}
+
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Tag returns the OCI tag of a kernel release (invalid characters replaced)
+func Tag(kernel string) string {
+	tag := invalidTagChars.ReplaceAllString(kernel, "_")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
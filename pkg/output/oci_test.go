@@ -0,0 +1,135 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/report"
+)
+
+// fakeRegistry implements the push side of the OCI distribution API
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case r.Method == http.MethodHead && strings.Contains(path, "/blobs/"):
+		if _, ok := f.blobs[path[strings.LastIndex(path, "/")+1:]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/v2/"+path+"upload-1?state=x")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && strings.Contains(path, "/blobs/uploads/"):
+		data, _ := io.ReadAll(r.Body)
+		if r.URL.Query().Get("state") != "x" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blobs[r.URL.Query().Get("digest")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && strings.Contains(path, "/manifests/"):
+		data, _ := io.ReadAll(r.Body)
+		f.manifests[path] = data
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestExt(t *testing.T) {
+	for _, name := range Names() {
+		enc, err := New(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(archive.OutputExts, enc.Ext()) {
+			t.Errorf("%s: extension %s missing from archive.OutputExts", name, enc.Ext())
+		}
+	}
+}
+
+func TestOCIEncode(t *testing.T) {
+	reg := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	btf := filepath.Join(dir, "5.4.0-1-generic.btf.part")
+	if err := os.WriteFile(btf, []byte("BTF data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oci := &OCI{Registry: strings.TrimPrefix(srv.URL, "http://") + "/btfhub", PlainHTTP: true}
+	a := Artifact{
+		Target:  report.Target{Distro: "ubuntu", Release: "focal", Arch: "x86_64"},
+		Kernel:  "5.4.0-1-generic",
+		BTFPath: btf,
+		OutPath: filepath.Join(dir, "5.4.0-1-generic"+oci.Ext()),
+	}
+	if err := oci.Encode(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+
+	// image layout
+
+	b, err := os.ReadFile(filepath.Join(a.OutPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Annotations[AnnotationRefName] != "5.4.0-1-generic" {
+		t.Fatalf("unexpected index: %s", b)
+	}
+	digest := strings.TrimPrefix(index.Manifests[0].Digest, "sha256:")
+	manifest, err := os.ReadFile(filepath.Join(a.OutPath, "blobs", "sha256", digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := archive.ReadBTF(a.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "BTF data" {
+		t.Errorf("BTF layer %q, expected %q", data, "BTF data")
+	}
+
+	// registry
+
+	pushed, ok := reg.manifests["btfhub/ubuntu/focal/x86_64/manifests/5.4.0-1-generic"]
+	if !ok {
+		t.Fatalf("manifest not pushed: %v", reg.manifests)
+	}
+	if string(pushed) != string(manifest) {
+		t.Error("pushed manifest differs from the image layout one")
+	}
+	var m Manifest
+	if err := json.Unmarshal(pushed, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range append([]Descriptor{m.Config}, m.Layers...) {
+		if _, ok := reg.blobs[d.Digest]; !ok {
+			t.Errorf("blob %s not pushed", d.Digest)
+		}
+	}
+}
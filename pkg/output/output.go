@@ -0,0 +1,143 @@
+// Package output implements the formats the generated BTF files are written
+// in. The .btf.tar.xz format is the one of the archive, and the default.
+package output
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/zstd"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
+)
+
+// Artifact describes a generated BTF file to encode
+type Artifact struct {
+	Target  report.Target
+	Kernel  string // kernel release (uname -r)
+	Module  string // kernel module, for split BTF files (empty for the kernel)
+	BTFPath string // raw BTF file (input)
+	OutPath string // encoded file (output), ending with the encoder extension
+}
+
+// Name returns the name of the BTF file of the artifact (without extension):
+// the kernel release, or the module name for split BTF files
+func (a Artifact) Name() string {
+	if a.Module != "" {
+		return a.Module
+	}
+	return a.Kernel
+}
+
+// Encoder writes a raw BTF file in an output format
+type Encoder interface {
+	// Name is the name of the format (as given in the command line)
+	Name() string
+	// Ext is the extension of the encoded files (e.g. .btf.tar.xz)
+	Ext() string
+	// Encode writes the BTF file of the artifact to its output path
+	Encode(ctx context.Context, a Artifact) error
+}
+
+// Default is the encoder used for the generated BTF files
+var Default Encoder = TarXZ{}
+
+// Names returns the names of the available formats
+func Names() []string {
+	names := []string{TarXZ{}.Name(), Raw{}.Name(), Zstd{}.Name(), (&OCI{}).Name()}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the encoder of the given format
+func New(name string) (Encoder, error) {
+	switch name {
+	case TarXZ{}.Name():
+		return TarXZ{}, nil
+	case Raw{}.Name():
+		return Raw{}, nil
+	case Zstd{}.Name():
+		return Zstd{}, nil
+	case (&OCI{}).Name():
+		return &OCI{}, nil
+	}
+	return nil, fmt.Errorf("invalid output format %s (valid: %s)", name, strings.Join(Names(), ","))
+}
+
+// TarXZ writes reproducible .btf.tar.xz files (the archive format). The xz
+// blocks of a file are compressed in parallel by up to Concurrency goroutines
+// (1 if not positive), see tarxz.TarballBlockSize.
+type TarXZ struct {
+	Concurrency int
+}
+
+func (TarXZ) Name() string { return "tar.xz" }
+func (TarXZ) Ext() string  { return archive.BTFExt }
+
+func (e TarXZ) Encode(ctx context.Context, a Artifact) error {
+	return pkg.TarballBTFAs(ctx, a.BTFPath, a.Name()+".btf", a.OutPath, e.Concurrency)
+}
+
+// Raw writes the BTF files as they are, so they can be mmap'ed
+type Raw struct{}
+
+func (Raw) Name() string { return "btf" }
+func (Raw) Ext() string  { return archive.RawExt }
+
+func (Raw) Encode(ctx context.Context, a Artifact) error {
+	return copyFile(a.BTFPath, a.OutPath, func(w io.Writer) io.WriteCloser {
+		return nopCloser{w}
+	})
+}
+
+// Zstd writes zstd compressed BTF files (faster to decompress than xz)
+type Zstd struct{}
+
+func (Zstd) Name() string { return "btf.zst" }
+func (Zstd) Ext() string  { return archive.ZstdExt }
+
+func (Zstd) Encode(ctx context.Context, a Artifact) error {
+	return copyFile(a.BTFPath, a.OutPath, func(w io.Writer) io.WriteCloser {
+		return zstd.NewWriterLevel(w, zstd.BestCompression)
+	})
+}
+
+// copyFile copies the in file to the out file through the writer returned by
+// wrap (closed before the out file)
+func copyFile(in string, out string, wrap func(io.Writer) io.WriteCloser) error {
+	inFile, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	outFile, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	w := wrap(outFile)
+	if _, err := io.Copy(w, inFile); err != nil {
+		w.Close()
+		outFile.Close()
+		return fmt.Errorf("write %s: %s", out, err)
+	}
+	if err := w.Close(); err != nil {
+		outFile.Close()
+		return fmt.Errorf("write %s: %s", out, err)
+	}
+
+	return outFile.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
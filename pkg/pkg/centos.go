@@ -16,6 +16,7 @@ type CentOSPackage struct {
 	KernelVersion kernel.Version
 	NameOfFile    string
 	URL           string
+	Size          uint64 // from the directory listing (0 if unknown)
 }
 
 func (pkg *CentOSPackage) Filename() string {
@@ -30,6 +31,16 @@ func (pkg *CentOSPackage) Version() kernel.Version {
 	return pkg.KernelVersion
 }
 
+// DownloadSize returns the size of the package (0 if unknown)
+func (pkg *CentOSPackage) DownloadSize() uint64 {
+	return pkg.Size
+}
+
+// DownloadURL returns the URL of the package (see URLPackage)
+func (pkg *CentOSPackage) DownloadURL() string {
+	return pkg.URL
+}
+
 func (pkg *CentOSPackage) String() string {
 	return pkg.Name
 }
@@ -41,7 +52,7 @@ func (pkg *CentOSPackage) Download(ctx context.Context, dir string, force bool)
 		return rpmpath, nil
 	}
 
-	if err := utils.DownloadFile(ctx, pkg.URL, rpmpath); err != nil {
+	if err := utils.DownloadFileSize(ctx, pkg.URL, rpmpath, pkg.Size); err != nil {
 		os.Remove(rpmpath)
 		return "", fmt.Errorf("downloading rpm package: %s", err)
 	}
@@ -49,5 +60,11 @@ func (pkg *CentOSPackage) Download(ctx context.Context, dir string, force bool)
 }
 
 func (pkg *CentOSPackage) ExtractKernel(ctx context.Context, pkgpath string, vmlinuxPath string) error {
-	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath)
+	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename())
+}
+
+// ExtractKernelModules extracts the vmlinux file and the allowed kernel
+// modules (.ko.debug files) from the package
+func (pkg *CentOSPackage) ExtractKernelModules(ctx context.Context, pkgpath string, vmlinuxPath string, modules []string, modulesDir string) error {
+	return extractKernelModulesFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename(), modules, modulesDir)
 }
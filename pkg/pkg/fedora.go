@@ -16,6 +16,7 @@ type FedoraPackage struct {
 	KernelVersion kernel.Version
 	NameOfFile    string
 	URL           string
+	Size          uint64 // from the directory listing (0 if unknown)
 }
 
 func (pkg *FedoraPackage) Filename() string {
@@ -30,12 +31,28 @@ func (pkg *FedoraPackage) Version() kernel.Version {
 	return pkg.KernelVersion
 }
 
+// DownloadSize returns the size of the package (0 if unknown)
+func (pkg *FedoraPackage) DownloadSize() uint64 {
+	return pkg.Size
+}
+
+// DownloadURL returns the URL of the package (see URLPackage)
+func (pkg *FedoraPackage) DownloadURL() string {
+	return pkg.URL
+}
+
 func (pkg *FedoraPackage) String() string {
 	return pkg.Name
 }
 
 func (pkg *FedoraPackage) ExtractKernel(ctx context.Context, pkgpath string, vmlinuxPath string) error {
-	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath)
+	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename())
+}
+
+// ExtractKernelModules extracts the vmlinux file and the allowed kernel
+// modules (.ko.debug files) from the package
+func (pkg *FedoraPackage) ExtractKernelModules(ctx context.Context, pkgpath string, vmlinuxPath string, modules []string, modulesDir string) error {
+	return extractKernelModulesFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename(), modules, modulesDir)
 }
 
 func (pkg *FedoraPackage) Download(ctx context.Context, workDir string, force bool) (string, error) {
@@ -47,7 +64,7 @@ func (pkg *FedoraPackage) Download(ctx context.Context, workDir string, force bo
 		return rpmPath, nil
 	}
 
-	err := utils.DownloadFile(ctx, pkg.URL, rpmPath)
+	err := utils.DownloadFileSize(ctx, pkg.URL, rpmPath, pkg.Size)
 	if err != nil {
 		os.Remove(rpmPath)
 		return "", fmt.Errorf("downloading rpm package: %s", err)
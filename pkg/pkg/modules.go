@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// ModuleExt is the extension of the extracted kernel modules
+const ModuleExt = ".ko"
+
+// ModulesExtractor is implemented by the packages that also ship the debug
+// files of the kernel modules. The modules in the allow-list are extracted,
+// along with the vmlinux file, to modulesDir/<module>.ko.
+type ModulesExtractor interface {
+	ExtractKernelModules(ctx context.Context, pkgpath string, vmlinuxPath string, modules []string, modulesDir string) error
+}
+
+// ModuleName returns the name of a kernel module (as shown by lsmod) out of
+// the path of its (debug) file in a package, if the path is a kernel module
+func ModuleName(path string) (string, bool) {
+	if !strings.Contains(path, "/lib/modules/") {
+		return "", false
+	}
+	base := strings.TrimSuffix(filepath.Base(path), ".debug")
+	if !strings.HasSuffix(base, ModuleExt) {
+		return "", false
+	}
+	return strings.ReplaceAll(strings.TrimSuffix(base, ModuleExt), "-", "_"), true
+}
+
+// modulePath returns the path to extract the given package file to, if it is
+// a kernel module in the allow-list ("" otherwise)
+func modulePath(name string, modules []string, modulesDir string) string {
+	module, ok := ModuleName(name)
+	if !ok || !slices.Contains(modules, module) {
+		return ""
+	}
+	return filepath.Join(modulesDir, module+ModuleExt)
+}
+
+// extractKernelModulesFromRPM extracts the vmlinux file (checking that it is
+// of the given release, see utils.CheckVmlinuxRelease) and the allowed kernel
+// modules of a debuginfo rpm package in a single pass
+func extractKernelModulesFromRPM(ctx context.Context, pkgpath string, vmlinuxPath string, release string, modules []string, modulesDir string) error {
+	if err := os.MkdirAll(modulesDir, 0775); err != nil {
+		return err
+	}
+
+	var found string
+
+	err := utils.ExtractFromRPM(ctx, pkgpath, func(name string) (string, bool) {
+		if p := modulePath(name, modules, modulesDir); p != "" {
+			return p, false
+		}
+		if found == "" && strings.Contains(name, "vmlinux") {
+			found = name
+			return vmlinuxPath, false
+		}
+		return "", false
+	})
+	if err != nil {
+		return err
+	}
+	if found == "" {
+		return errors.New("vmlinux file not found in rpm")
+	}
+	return utils.CheckVmlinuxRelease(found, release)
+}
@@ -0,0 +1,24 @@
+package pkg
+
+import "testing"
+
+func TestModuleName(t *testing.T) {
+	tests := []struct {
+		path   string
+		module string
+		ok     bool
+	}{
+		{"./usr/lib/debug/lib/modules/5.4.0-1-generic/kernel/fs/xfs/xfs.ko", "xfs", true},
+		{"./usr/lib/debug/lib/modules/4.18.0-80.el8.x86_64/kernel/net/netfilter/nf_conntrack.ko.debug", "nf_conntrack", true},
+		{"/usr/lib/debug/lib/modules/5.3.18-57-default/kernel/net/bridge/br-netfilter.ko.debug", "br_netfilter", true},
+		{"/usr/lib/debug/lib/modules/4.18.0-80.el8.x86_64/vmlinux", "", false},
+		{"./usr/lib/debug/boot/vmlinux-5.4.0-1-generic", "", false},
+		{"./usr/share/doc/xfs.ko", "", false},
+	}
+	for _, tt := range tests {
+		module, ok := ModuleName(tt.path)
+		if module != tt.module || ok != tt.ok {
+			t.Errorf("%s: got %q %t, expected %q %t", tt.path, module, ok, tt.module, tt.ok)
+		}
+	}
+}
@@ -15,13 +15,21 @@ type Package interface {
 	Filename() string
 	BTFFilename() string
 	Version() kernel.Version
+	DownloadSize() uint64
 	Download(ctx context.Context, dir string, force bool) (string, error)
 	ExtractKernel(ctx context.Context, pkgpath string, vmlinuxPath string) error
 }
 
-func PackageBTFExists(p Package, workDir string) bool {
-	fp := filepath.Join(workDir, fmt.Sprintf("%s.btf.tar.xz", p.BTFFilename()))
-	return utils.Exists(fp)
+// URLPackage is a package downloaded from an URL (the others are downloaded
+// by package managers)
+type URLPackage interface {
+	DownloadURL() string
+}
+
+// ChecksumPackage is a package whose sha256 checksum is known before it is
+// downloaded (e.g. from an APT index)
+type ChecksumPackage interface {
+	Checksum() string
 }
 
 func PackageFailed(p Package, workDir string) bool {
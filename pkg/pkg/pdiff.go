@@ -0,0 +1,332 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+//
+// APT pdiffs
+//
+
+// aptPlainIndex returns the source of the uncompressed index (<suite>/<path>
+// without the .xz or .gz extension) of the given compressed index, making sure
+// the index cache holds it with the checksum of the InRelease file: already
+// cached, updated from the cached one with the pdiffs of the suite, if any
+// (<path>.diff/Index), or else fetched in full (src) and decompressed.
+func aptPlainIndex(ctx context.Context, rel *aptRelease, suiteURL string, indexPath string, src utils.IndexSource) (
+	utils.IndexSource, error,
+) {
+	c := utils.DefaultIndexCache
+
+	plainPath := strings.TrimSuffix(strings.TrimSuffix(indexPath, ".xz"), ".gz")
+	sum, ok := rel.SHA256[plainPath]
+	if plainPath == indexPath || !ok {
+		return src, nil
+	}
+	plain := utils.IndexSource{URL: suiteURL + "/" + plainPath, SHA256: sum}
+
+	old := c.Cached(plain.URL)
+	if old != nil && old.SHA256 == sum {
+		return plain, nil // unchanged
+	}
+	if _, ok := rel.SHA256[plainPath+".diff/Index"]; old != nil && ok {
+		err := aptPatchIndex(ctx, rel, suiteURL, plainPath, old, plain)
+		if err == nil {
+			return plain, nil
+		}
+		log.Printf("DEBUG: %s pdiffs: %s\n", plain.URL, err)
+	}
+
+	// Fetch the compressed index, and keep it uncompressed only
+
+	idx, err := c.Fetch(ctx, src)
+	if err != nil {
+		return src, err
+	}
+	rdr, err := idx.Open()
+	if err != nil {
+		return src, err
+	}
+	_, err = c.Put(plain, rdr)
+	rdr.Close()
+	if err != nil {
+		return src, err
+	}
+	if err := c.Remove(src.URL); err != nil {
+		return src, err
+	}
+
+	return plain, nil
+}
+
+// aptPdiffIndex is an APT pdiff index (<index>.diff/Index): the checksum of
+// the current index, and the patches (ed scripts) updating the previous ones
+// to it
+type aptPdiffIndex struct {
+	Current string     `json:"current"`
+	History []aptPdiff `json:"history"` // oldest first
+	Merged  bool       `json:"merged"`  // the patches update to the current index
+}
+
+// aptPdiff is a patch of an APT index, with the checksum of the index it
+// applies to, and of the patch (uncompressed and compressed)
+type aptPdiff struct {
+	Name     string `json:"name"`
+	Index    string `json:"index"`
+	SHA256   string `json:"sha256"`
+	Download string `json:"download"`
+}
+
+// patches returns the patches to apply in turn to the index with the given
+// checksum, to update it to the current one
+func (p *aptPdiffIndex) patches(sum string) ([]aptPdiff, error) {
+	i := slices.IndexFunc(p.History, func(d aptPdiff) bool { return d.Index == sum })
+	if i < 0 {
+		return nil, fmt.Errorf("no patch for %s", sum)
+	}
+	if p.Merged {
+		return p.History[i : i+1], nil
+	}
+	return p.History[i:], nil
+}
+
+// aptPatchIndex updates the cached uncompressed index (old) to the given one,
+// with the pdiffs of the suite
+func aptPatchIndex(ctx context.Context, rel *aptRelease, suiteURL string, plainPath string, old *utils.Index, plain utils.IndexSource) error {
+	c := utils.DefaultIndexCache
+
+	diffIndexPath := plainPath + ".diff/Index"
+	diffIndex := utils.IndexSource{URL: suiteURL + "/" + diffIndexPath, SHA256: rel.SHA256[diffIndexPath]}
+	if rel.ByHash {
+		diffIndex.ByHashURL = rel.byHashURL(suiteURL, diffIndexPath)
+	}
+	pdiffs, err := utils.ParseIndex(ctx, diffIndex, parseAPTPdiffIndex)
+	if err != nil {
+		return err
+	}
+	if pdiffs.Current != plain.SHA256 {
+		return fmt.Errorf("pdiffs of %s, not of %s", pdiffs.Current, plain.SHA256)
+	}
+	patches, err := pdiffs.patches(old.SHA256)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(old.Path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, patch := range patches {
+		script, err := aptFetchPdiff(ctx, suiteURL+"/"+plainPath+".diff/"+patch.Name+".gz", patch)
+		if err != nil {
+			return err
+		}
+		if lines, err = applyEdScript(lines, bytes.NewReader(script)); err != nil {
+			return fmt.Errorf("patch %s: %s", patch.Name, err)
+		}
+	}
+
+	_, err = c.Put(plain, bytes.NewReader(bytes.Join(lines, nil)))
+	return err
+}
+
+// aptFetchPdiff returns the uncompressed content of the given patch, checked
+// against its checksums. Patches aren't kept in the index cache.
+func aptFetchPdiff(ctx context.Context, patchURL string, patch aptPdiff) ([]byte, error) {
+	c := utils.DefaultIndexCache
+
+	idx, err := c.Fetch(ctx, utils.IndexSource{URL: patchURL, SHA256: patch.Download})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Remove(patchURL)
+
+	rdr, err := idx.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	script, err := io.ReadAll(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("patch %s: %s", patch.Name, err)
+	}
+	if sum := sha256.Sum256(script); patch.SHA256 != "" && hex.EncodeToString(sum[:]) != patch.SHA256 {
+		return nil, fmt.Errorf("patch %s: checksum %x, expected %s", patch.Name, sum, patch.SHA256)
+	}
+
+	return script, nil
+}
+
+// parseAPTPdiffIndex parses the SHA256 fields of an APT pdiff index
+func parseAPTPdiffIndex(rdr io.Reader) (*aptPdiffIndex, error) {
+	p := &aptPdiffIndex{}
+	patches := map[string]string{}   // map[name]checksum
+	downloads := map[string]string{} // map[name]checksum of the .gz file
+
+	bio := bufio.NewScanner(rdr)
+	field := ""
+
+	for bio.Scan() {
+		line := bio.Text()
+
+		if strings.HasPrefix(line, " ") {
+			fields := strings.Fields(line) // checksum size name
+			if len(fields) != 3 {
+				continue
+			}
+			switch field {
+			case "SHA256-History":
+				p.History = append(p.History, aptPdiff{Name: fields[2], Index: fields[0]})
+			case "SHA256-Patches":
+				patches[fields[2]] = fields[0]
+			case "SHA256-Download":
+				downloads[strings.TrimSuffix(fields[2], ".gz")] = fields[0]
+			}
+			continue
+		}
+
+		name, val, _ := strings.Cut(line, ":")
+		field = name
+		switch name {
+		case "SHA256-Current":
+			p.Current, _, _ = strings.Cut(strings.TrimSpace(val), " ")
+		case "X-Patch-Precedence":
+			p.Merged = strings.TrimSpace(val) == "merged"
+		}
+	}
+	if err := bio.Err(); err != nil {
+		return nil, err
+	}
+	if p.Current == "" {
+		return nil, fmt.Errorf("no SHA256-Current field")
+	}
+
+	for i := range p.History {
+		p.History[i].SHA256 = patches[p.History[i].Name]
+		p.History[i].Download = downloads[p.History[i].Name]
+	}
+
+	return p, nil
+}
+
+// edCommand is an append (a), change (c) or delete (d) command of an ed
+// script, of the lines from start to end (after line start, for append)
+type edCommand struct {
+	op         byte
+	start, end int
+	text       [][]byte
+}
+
+// applyEdScript applies an ed script, as written by diff --ed (commands in
+// decreasing line order, no line made of a single dot), to the given lines
+// (with their newline)
+func applyEdScript(lines [][]byte, script io.Reader) ([][]byte, error) {
+	var cmds []edCommand
+
+	bio := bufio.NewReader(script)
+	for {
+		line, err := bio.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		cmd, err := parseEdCommand(strings.TrimSuffix(string(line), "\n"))
+		if err != nil {
+			return nil, err
+		}
+		if cmd.op != 'd' {
+			for {
+				text, err := bio.ReadBytes('\n')
+				if err != nil {
+					return nil, fmt.Errorf("unterminated %c command", cmd.op)
+				}
+				if string(text) == ".\n" {
+					break
+				}
+				cmd.text = append(cmd.text, text)
+			}
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	// Apply the commands in increasing line order, copying the lines in
+	// between
+
+	slices.Reverse(cmds)
+
+	var out [][]byte
+	copied := 0 // lines
+	for _, cmd := range cmds {
+		first := cmd.start - 1 // first line replaced (0 based)
+		if cmd.op == 'a' {
+			first = cmd.start
+		}
+		if first < copied || cmd.end > len(lines) {
+			return nil, fmt.Errorf("%c command at line %d out of order or range", cmd.op, cmd.start)
+		}
+		out = append(out, lines[copied:first]...)
+		out = append(out, cmd.text...)
+		copied = max(first, cmd.end)
+	}
+	out = append(out, lines[copied:]...)
+
+	return out, nil
+}
+
+// parseEdCommand parses an ed command line: <start>[,<end>]{a,c,d}
+func parseEdCommand(line string) (edCommand, error) {
+	cmd := edCommand{}
+	if line == "" {
+		return cmd, fmt.Errorf("empty command")
+	}
+
+	cmd.op = line[len(line)-1]
+	if cmd.op != 'a' && cmd.op != 'c' && cmd.op != 'd' {
+		return cmd, fmt.Errorf("unsupported command %q", line)
+	}
+	start, end, hasEnd := strings.Cut(line[:len(line)-1], ",")
+
+	var err error
+	if cmd.start, err = strconv.Atoi(start); err != nil {
+		return cmd, fmt.Errorf("command %q: %s", line, err)
+	}
+	cmd.end = cmd.start
+	if hasEnd {
+		if cmd.end, err = strconv.Atoi(end); err != nil {
+			return cmd, fmt.Errorf("command %q: %s", line, err)
+		}
+	}
+	if cmd.op == 'a' {
+		if hasEnd {
+			return cmd, fmt.Errorf("unsupported command %q", line)
+		}
+		cmd.end = cmd.start // nothing replaced
+	}
+	if cmd.start < 0 || cmd.end < cmd.start || (cmd.op != 'a' && cmd.start == 0) {
+		return cmd, fmt.Errorf("invalid command %q", line)
+	}
+
+	return cmd, nil
+}
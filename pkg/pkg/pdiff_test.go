@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestApplyEdScript(t *testing.T) {
+	old := "a\nb\nc\nd\ne\n"
+
+	for _, tt := range []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"append first", "0a\nx\ny\n.\n", "x\ny\na\nb\nc\nd\ne\n"},
+		{"append last", "5a\nx\n.\n", "a\nb\nc\nd\ne\nx\n"},
+		{"change", "2,3c\nx\n.\n", "a\nx\nd\ne\n"},
+		{"delete", "5d\n1,2d\n", "c\nd\n"},
+		{"several", "5c\ny\n.\n3a\nx\n.\n1d\n", "b\nc\nx\nd\ny\n"},
+		{"empty", "", old},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := bytes.SplitAfter([]byte(old), []byte("\n"))
+			lines = lines[:len(lines)-1]
+
+			out, err := applyEdScript(lines, strings.NewReader(tt.script))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(bytes.Join(out, nil)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, script := range []string{
+		"1d\n5d\n",     // increasing order
+		"7d\n",         // out of range
+		"2a\nx\n",      // unterminated
+		"s/.//\n",      // unsupported
+		"1,2a\nx\n.\n", // invalid
+	} {
+		lines := bytes.SplitAfter([]byte(old), []byte("\n"))
+		if _, err := applyEdScript(lines[:len(lines)-1], strings.NewReader(script)); err == nil {
+			t.Errorf("%q applied", script)
+		}
+	}
+}
+
+func TestParseAPTPdiffIndex(t *testing.T) {
+	index := `SHA256-Current: cur 30
+SHA256-History:
+ old1 10 T-2-F-1
+ old2 20 T-2-F-2
+SHA256-Patches:
+ p1 1 T-2-F-1
+ p2 2 T-2-F-2
+SHA256-Download:
+ d1 1 T-2-F-1.gz
+ d2 2 T-2-F-2.gz
+X-Patch-Precedence: merged
+`
+	p, err := parseAPTPdiffIndex(strings.NewReader(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Current != "cur" || !p.Merged || len(p.History) != 2 {
+		t.Fatalf("parsed %+v", p)
+	}
+
+	patches, err := p.patches("old1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := aptPdiff{Name: "T-2-F-1", Index: "old1", SHA256: "p1", Download: "d1"}
+	if len(patches) != 1 || patches[0] != want {
+		t.Errorf("patches %+v", patches)
+	}
+
+	p.Merged = false
+	if patches, _ := p.patches("old1"); len(patches) != 2 {
+		t.Errorf("unmerged patches %+v", patches)
+	}
+	if _, err := p.patches("other"); err == nil {
+		t.Errorf("patch for an unknown index")
+	}
+}
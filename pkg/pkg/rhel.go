@@ -16,6 +16,7 @@ type RHELPackage struct {
 	Architecture  string
 	KernelVersion kernel.Version
 	NameOfFile    string
+	Runner        utils.CommandRunner // runs yumdownloader
 }
 
 func (pkg *RHELPackage) Filename() string {
@@ -30,12 +31,23 @@ func (pkg *RHELPackage) Version() kernel.Version {
 	return pkg.KernelVersion
 }
 
+// DownloadSize returns the size of the package (0 if unknown)
+func (pkg *RHELPackage) DownloadSize() uint64 {
+	return 0
+}
+
 func (pkg *RHELPackage) String() string {
 	return pkg.Name
 }
 
 func (pkg *RHELPackage) ExtractKernel(ctx context.Context, pkgpath string, vmlinuxPath string) error {
-	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath)
+	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename())
+}
+
+// ExtractKernelModules extracts the vmlinux file and the allowed kernel
+// modules (.ko.debug files) from the package
+func (pkg *RHELPackage) ExtractKernelModules(ctx context.Context, pkgpath string, vmlinuxPath string, modules []string, modulesDir string) error {
+	return extractKernelModulesFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename(), modules, modulesDir)
 }
 
 func (pkg *RHELPackage) Download(ctx context.Context, dir string, force bool) (string, error) {
@@ -47,7 +59,7 @@ func (pkg *RHELPackage) Download(ctx context.Context, dir string, force bool) (s
 		return rpmpath, nil
 	}
 
-	err := yumDownload(ctx, pkg.Name, pkg.Architecture, dir)
+	err := yumDownload(ctx, pkg.Runner, pkg.Name, pkg.Architecture, dir)
 	if err != nil {
 		os.Remove(rpmpath)
 		return "", fmt.Errorf("rpm download: %s", err)
@@ -19,6 +19,7 @@ type SUSEPackage struct {
 	Repo          string
 	Flavor        string
 	Downloaddir   string
+	Runner        utils.CommandRunner // runs zypper
 }
 
 func (pkg *SUSEPackage) Filename() string {
@@ -33,13 +34,24 @@ func (pkg *SUSEPackage) Version() kernel.Version {
 	return pkg.KernelVersion
 }
 
+// DownloadSize returns the size of the package (0 if unknown)
+func (pkg *SUSEPackage) DownloadSize() uint64 {
+	return 0
+}
+
 func (pkg *SUSEPackage) String() string {
 	return fmt.Sprintf("%s-%s.%s", pkg.Name, pkg.KernelVersion.String(), pkg.Architecture)
 }
 
 func (pkg *SUSEPackage) ExtractKernel(ctx context.Context, pkgpath string, vmlinuxPath string) error {
 	// vmlinux at: /usr/lib/debug/boot/vmlinux-<ver>-<type>.debug
-	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath)
+	return utils.ExtractVmlinuxFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename())
+}
+
+// ExtractKernelModules extracts the vmlinux file and the allowed kernel
+// modules (.ko.debug files) from the package
+func (pkg *SUSEPackage) ExtractKernelModules(ctx context.Context, pkgpath string, vmlinuxPath string, modules []string, modulesDir string) error {
+	return extractKernelModulesFromRPM(ctx, pkgpath, vmlinuxPath, pkg.BTFFilename(), modules, modulesDir)
 }
 
 func (pkg *SUSEPackage) Download(ctx context.Context, _ string, force bool) (string, error) {
@@ -49,7 +61,7 @@ func (pkg *SUSEPackage) Download(ctx context.Context, _ string, force bool) (str
 		return rpmpath, nil
 	}
 
-	if err := zypperDownload(ctx, fmt.Sprintf("%s=%s", pkg.Name, pkg.KernelVersion.String())); err != nil {
+	if err := zypperDownload(ctx, pkg.Runner, fmt.Sprintf("%s=%s", pkg.Name, pkg.KernelVersion.String())); err != nil {
 		os.Remove(rpmpath)
 		return "", fmt.Errorf("zypper download: %s", err)
 	}
@@ -57,8 +69,8 @@ func (pkg *SUSEPackage) Download(ctx context.Context, _ string, force bool) (str
 	return rpmpath, nil
 }
 
-func zypperDownload(ctx context.Context, pkg string) error {
-	stdout, err := utils.RunZypperCMD(ctx, "-q", "install", "-y", "--no-recommends", "--download-only", pkg)
+func zypperDownload(ctx context.Context, runner utils.CommandRunner, pkg string) error {
+	stdout, err := utils.RunZypperCMD(ctx, runner, "-q", "install", "-y", "--no-recommends", "--download-only", pkg)
 	_, _ = fmt.Fprint(os.Stdout, stdout.String())
 	return err
 }
@@ -2,13 +2,12 @@ package pkg
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -26,8 +25,10 @@ type UbuntuPackage struct {
 	NameOfFile    string
 	URL           string
 	Size          uint64
+	SHA256        string // of the package, from the index (empty if unknown)
 	Release       string
-	Flavor        string // generic, gcp, aws, azure
+	Flavor        string              // generic, gcp, aws, azure
+	Runner        utils.CommandRunner `json:"-"` // runs pull-lp-ddebs (launchpad pseudo-packages)
 }
 
 func (pkg *UbuntuPackage) isValid() bool {
@@ -46,6 +47,28 @@ func (pkg *UbuntuPackage) Version() kernel.Version {
 	return pkg.KernelVersion
 }
 
+// DownloadSize returns the size of the package (0 if unknown)
+func (pkg *UbuntuPackage) DownloadSize() uint64 {
+	if pkg.Size == math.MaxUint64 {
+		return 0 // launchpad pseudo-package
+	}
+	return pkg.Size
+}
+
+// DownloadURL returns the URL of the package (see URLPackage), empty for the
+// launchpad pseudo-packages
+func (pkg *UbuntuPackage) DownloadURL() string {
+	if pkg.URL == "pull-lp-ddebs" {
+		return ""
+	}
+	return pkg.URL
+}
+
+// Checksum returns the sha256 checksum of the package (see ChecksumPackage)
+func (pkg *UbuntuPackage) Checksum() string {
+	return pkg.SHA256
+}
+
 func (pkg *UbuntuPackage) String() string {
 	return fmt.Sprintf("%s %s", pkg.Name, pkg.Architecture)
 }
@@ -86,10 +109,48 @@ func (pkg *UbuntuPackage) Download(ctx context.Context, dir string, force bool)
 // vmlinuxPath. It returns an error if the package is not a ddeb or if the
 // vmlinux file is not found.
 func (pkg *UbuntuPackage) ExtractKernel(ctx context.Context, pkgPath string, vmlinuxPath string) error {
+	return pkg.ExtractKernelModules(ctx, pkgPath, vmlinuxPath, nil, "")
+}
+
+// ExtractKernelModules extracts the vmlinux file and the allowed kernel
+// modules from the package (in a single pass)
+func (pkg *UbuntuPackage) ExtractKernelModules(ctx context.Context, pkgPath string, vmlinuxPath string, modules []string, modulesDir string) error {
 
 	vmlinuxName := fmt.Sprintf("vmlinux-%s", pkg.NameOfFile)
 	debpath := fmt.Sprintf("./usr/lib/debug/boot/%s", vmlinuxName)
 
+	if len(modules) > 0 {
+		if err := os.MkdirAll(modulesDir, 0775); err != nil {
+			return err
+		}
+	}
+
+	found := false
+
+	err := extractFromDeb(ctx, pkgPath, func(name string) (string, bool) {
+		if name == debpath {
+			found = true
+			return vmlinuxPath, len(modules) == 0
+		}
+		if len(modules) > 0 {
+			return modulePath(name, modules, modulesDir), false
+		}
+		return "", false
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s file not found in ddeb", debpath)
+	}
+	return nil
+}
+
+// extractFromDeb extracts the files of a deb package for which pick returns a
+// path (where the file is extracted to). It stops reading the package once
+// pick returns true (last file to extract).
+func extractFromDeb(ctx context.Context, pkgPath string, pick func(name string) (string, bool)) error {
+
 	ddeb, closer, err := deb.LoadFile(pkgPath)
 	if err != nil {
 		return fmt.Errorf("deb load: %s", err)
@@ -98,7 +159,7 @@ func (pkg *UbuntuPackage) ExtractKernel(ctx context.Context, pkgPath string, vml
 
 	rdr := ddeb.Data // tar reader for the deb package
 
-	// Iterate over the files in the deb package to find the vmlinux file
+	// Iterate over the files in the deb package to find the picked files
 
 	for {
 		if err := ctx.Err(); err != nil {
@@ -113,32 +174,41 @@ func (pkg *UbuntuPackage) ExtractKernel(ctx context.Context, pkgPath string, vml
 			return fmt.Errorf("deb reader next: %s", err)
 		}
 
-		// Found the vmlinux file, extract it
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		outPath, last := pick(hdr.Name)
+		if outPath == "" {
+			continue
+		}
 
-		if hdr.Name == debpath {
-			vmlinuxFile, err := os.Create(vmlinuxPath)
-			if err != nil {
-				return fmt.Errorf("create vmlinux file: %s", err)
-			}
-			counter := &utils.ProgressCounter{
-				Ctx:  ctx,
-				Op:   "Extract",
-				Name: hdr.Name,
-				Size: uint64(hdr.Size),
-			}
-			_, err = io.Copy(vmlinuxFile, io.TeeReader(rdr, counter))
-			if err != nil {
-				vmlinuxFile.Close()
-				os.Remove(vmlinuxPath)
-				return fmt.Errorf("copy file: %s", err)
-			}
-			vmlinuxFile.Close()
+		// Found a picked file, extract it
 
+		outFile, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("create %s: %s", outPath, err)
+		}
+		counter := &utils.ProgressCounter{
+			Ctx:  ctx,
+			Op:   "Extract",
+			Name: hdr.Name,
+			Size: uint64(hdr.Size),
+		}
+		_, err = io.Copy(outFile, io.TeeReader(rdr, counter))
+		if err != nil {
+			outFile.Close()
+			os.Remove(outPath)
+			return fmt.Errorf("copy file: %s", err)
+		}
+		outFile.Close()
+
+		if last {
 			return nil
 		}
 	}
 
-	return fmt.Errorf("%s file not found in ddeb", debpath)
+	return nil
 }
 
 // pullLaunchpadDdeb downloads a ddeb package from launchpad using pull-lp-ddebs
@@ -146,15 +216,14 @@ func (pkg *UbuntuPackage) pullLaunchpadDdeb(ctx context.Context, dir string, des
 
 	fmt.Printf("Downloading %s from launchpad\n", pkg.Name)
 
-	stdout := &bytes.Buffer{}
-	stderr := &bytes.Buffer{}
-	cmd := exec.CommandContext(ctx, "pull-lp-ddebs", "--arch", pkg.Architecture, pkg.Name, pkg.Release)
-	cmd.Dir = dir
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("pull-lp-ddebs: %s\n%s\n%s", err, stdout.String(), stderr.String())
+	stdout, err := pkg.Runner.Run(ctx, utils.Command{
+		Name:    "pull-lp-ddebs",
+		Args:    []string{"--arch", pkg.Architecture, pkg.Name, pkg.Release},
+		Dir:     dir,
+		Changes: true,
+	})
+	if err != nil {
+		return err
 	}
 
 	// pull-lp-ddebs will download the ddeb package to the current directory
@@ -175,10 +244,5 @@ func (pkg *UbuntuPackage) pullLaunchpadDdeb(ctx context.Context, dir string, des
 		return scan.Err()
 	}
 
-	errline := stderr.String()
-	if len(errline) > 0 {
-		return errors.New(strings.TrimSpace(errline))
-	}
-
 	return errors.New("download path not found in pull-lp-ddebs output")
 }
@@ -2,48 +2,72 @@ package pkg
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"log"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/tarxz"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
+// TarballBTF compresses the BTF file into a reproducible .tar.xz file (see
+// tarxz.WriteTarball)
 func TarballBTF(ctx context.Context, btf string, out string) error {
-	// Use external tool for performance reasons
-	return utils.RunCMD(ctx, filepath.Dir(btf), "tar",
-		"-cvJ",
-		"--sort=name",
-		"--owner=root:0",
-		"--group=root:0",
-		"--mode=a=r",
-		"--mtime=@0",
-		"-f",
-		out,
-		filepath.Base(btf),
-	)
+	return TarballBTFAs(ctx, btf, filepath.Base(btf), out, 1)
+}
+
+// TarballBTFAs is TarballBTF with the given name for the file in the tarball,
+// compressing up to concurrency xz blocks in parallel (the tarball doesn't
+// depend on it)
+func TarballBTFAs(ctx context.Context, btf string, name string, out string, concurrency int) error {
+	in, err := os.Open(btf)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	outFile, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	opts := tarxz.Options{
+		Level:       tarxz.DefaultLevel,
+		Concurrency: concurrency,
+	}
+	if err := tarxz.WriteTarball(ctx, outFile, name, in, fi.Size(), opts); err != nil {
+		outFile.Close()
+		return fmt.Errorf("tarball %s: %s", out, err)
+	}
+
+	return outFile.Close()
 }
 
 //
 // RHEL packages
 //
 
-func yumDownload(ctx context.Context, pkg string, arch string, destdir string) error {
-	stderr := &bytes.Buffer{}
-	binary, args := utils.SudoCMD("yumdownloader", "--archlist="+arch, "--destdir="+destdir, pkg)
-	cmd := exec.CommandContext(ctx, binary, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("yum download %s: %s\n%s", pkg, err, stderr.String())
+func yumDownload(ctx context.Context, runner utils.CommandRunner, pkg string, arch string, destdir string) error {
+	_, err := runner.Run(ctx, utils.Command{
+		Name:    "yumdownloader",
+		Args:    []string{"--archlist=" + arch, "--destdir=" + destdir, pkg},
+		Root:    true,
+		Changes: true,
+	})
+	if err != nil {
+		return fmt.Errorf("yum download %s: %s", pkg, err)
 	}
 
 	return nil
@@ -53,28 +77,129 @@ func yumDownload(ctx context.Context, pkg string, arch string, destdir string) e
 // Ubuntu packages
 //
 
-// GetPackageList downloads the Packages.xz file from the given repo and release
-func GetPackageList(ctx context.Context, repo string, release string, arch string) (
-	*bytes.Buffer, error,
+// GetAPTPackages returns the kernel packages of the Packages.xz indexes of
+// main, updates and universe, of the given repo and release
+func GetAPTPackages(ctx context.Context, repo string, release string, arch string) (
+	[]*UbuntuPackage, error,
 ) {
-	var err error
-	rawPkgs := &bytes.Buffer{}
+	var kernelPkgs []*UbuntuPackage
 
-	main := fmt.Sprintf("%s/dists/%s/main/binary-%s/Packages.xz", repo, release, arch)
-	updates := fmt.Sprintf("%s/dists/%s-updates/main/binary-%s/Packages.xz", repo, release, arch)
-	universe := fmt.Sprintf("%s/dists/%s-updates/universe/binary-%s/Packages.xz", repo, release, arch)
+	for _, index := range []struct{ name, url string }{
+		{"base", fmt.Sprintf("%s/dists/%s/main/binary-%s/Packages.xz", repo, release, arch)},
+		{"updates main", fmt.Sprintf("%s/dists/%s-updates/main/binary-%s/Packages.xz", repo, release, arch)},
+		{"updates universe", fmt.Sprintf("%s/dists/%s-updates/universe/binary-%s/Packages.xz", repo, release, arch)},
+	} {
+		pkgs, err := GetAPTIndexPackages(ctx, index.url, repo, release)
+		if err != nil {
+			return nil, fmt.Errorf("%s package list: %s", index.name, err)
+		}
+		kernelPkgs = append(kernelPkgs, pkgs...)
+	}
 
-	if err = utils.Download(ctx, main, rawPkgs); err != nil {
-		return nil, fmt.Errorf("download base package list: %s", err)
+	return kernelPkgs, nil
+}
+
+// GetAPTIndexPackages returns the kernel packages of the given APT Packages
+// index (see utils.ParseIndex). With an index cache, the Release file of the
+// suite tells whether the index changed, and where to get it by hash.
+func GetAPTIndexPackages(ctx context.Context, indexURL string, repoURL string, release string) (
+	[]*UbuntuPackage, error,
+) {
+	src := aptIndexSource(ctx, indexURL)
+	return utils.ParseIndex(ctx, src, func(rdr io.Reader) ([]*UbuntuPackage, error) {
+		return ParseAPTPackages(rdr, repoURL, release)
+	})
+}
+
+// aptRelease is the part of an APT Release file about the indexes
+type aptRelease struct {
+	ByHash bool              `json:"by_hash"`
+	SHA256 map[string]string `json:"sha256"` // map[index path]checksum
+}
+
+// aptIndexSource returns the source of an APT index (.../dists/<suite>/<path>),
+// with its checksum and by-hash URL taken from the InRelease file of the suite.
+// If the InRelease file lists the uncompressed index too, the source is the
+// uncompressed index, kept up to date in the cache (see aptPlainIndex). Without
+// an index cache, or the index in the InRelease file, it is the index URL only.
+func aptIndexSource(ctx context.Context, indexURL string) utils.IndexSource {
+	src := utils.IndexSource{URL: indexURL}
+
+	if utils.DefaultIndexCache == nil {
+		return src
+	}
+	base, rest, found := strings.Cut(indexURL, "/dists/")
+	if !found {
+		return src
 	}
-	if err = utils.Download(ctx, updates, rawPkgs); err != nil {
-		return nil, fmt.Errorf("download updates main package list: %s", err)
+	suite, indexPath, found := strings.Cut(rest, "/")
+	if !found {
+		return src
 	}
-	if err = utils.Download(ctx, universe, rawPkgs); err != nil {
-		return nil, fmt.Errorf("download updates universe package list: %s", err)
+	suiteURL := base + "/dists/" + suite
+
+	rel, err := utils.ParseIndex(ctx, utils.IndexSource{URL: suiteURL + "/InRelease"}, parseAPTRelease)
+	if err != nil {
+		log.Printf("DEBUG: %s: %s\n", suiteURL, err)
+		return src
+	}
+	sum, ok := rel.SHA256[indexPath]
+	if !ok {
+		return src
+	}
+
+	src.SHA256 = sum
+	if rel.ByHash {
+		src.ByHashURL = rel.byHashURL(suiteURL, indexPath)
+	}
+
+	plain, err := aptPlainIndex(ctx, rel, suiteURL, indexPath, src)
+	if err != nil {
+		log.Printf("DEBUG: %s: %s\n", indexURL, err)
+		return src
+	}
+
+	return plain
+}
+
+// byHashURL returns the by-hash URL of an index of the suite
+func (rel *aptRelease) byHashURL(suiteURL string, indexPath string) string {
+	return suiteURL + "/" + path.Dir(indexPath) + "/by-hash/SHA256/" + rel.SHA256[indexPath]
+}
+
+// parseAPTRelease parses the by-hash support and the SHA256 checksums of the
+// indexes of an APT (In)Release file
+func parseAPTRelease(rdr io.Reader) (*aptRelease, error) {
+	rel := &aptRelease{SHA256: map[string]string{}}
+
+	bio := bufio.NewScanner(rdr)
+	inSHA256 := false
+
+	for bio.Scan() {
+		line := bio.Text()
+
+		if strings.HasPrefix(line, " ") {
+			if !inSHA256 {
+				continue
+			}
+			fields := strings.Fields(line) // checksum size path
+			if len(fields) == 3 {
+				rel.SHA256[fields[2]] = fields[0]
+			}
+			continue
+		}
+
+		name, val, _ := strings.Cut(line, ":")
+		inSHA256 = name == "SHA256"
+		if name == "Acquire-By-Hash" {
+			rel.ByHash = strings.TrimSpace(val) == "yes"
+		}
+	}
+	if err := bio.Err(); err != nil {
+		return nil, err
 	}
 
-	return rawPkgs, nil
+	return rel, nil
 }
 
 func ParseAPTPackages(rawPkgs io.Reader, repoURL string, release string) (
@@ -127,6 +252,8 @@ func ParseAPTPackages(rawPkgs io.Reader, repoURL string, release string) (
 			if err == nil {
 				pkg.Size = sz
 			}
+		case "SHA256":
+			pkg.SHA256 = val
 		default:
 			continue
 		}
@@ -4,32 +4,30 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os/exec"
-	"sort"
 	"strings"
 
 	"golang.org/x/exp/maps"
 
-	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/kernel"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
 type AmazonRepo struct {
-	archs map[string]string
+	archs  map[string]string
+	runner utils.CommandRunner
 }
 
-func NewAmazonRepo() Repository {
+func NewAmazonRepo(runner utils.CommandRunner) Repository {
 	return &AmazonRepo{
 		archs: map[string]string{
 			"x86_64": "x86_64",
 			"arm64":  "aarch64",
 		},
+		runner: runner,
 	}
 }
 
@@ -39,47 +37,41 @@ func (d *AmazonRepo) GetKernelPackages(
 	release string,
 	arch string,
 	force bool,
-	jobChan chan<- job.Job,
-) error {
+) (*Plan, error) {
+	target := report.Target{Distro: "amzn", Release: release, Arch: arch}
+
 	altArch := d.archs[arch]
-	searchOut, err := repoquery(ctx, "kernel-debuginfo", altArch)
+	searchOut, err := repoquery(ctx, d.runner, "kernel-debuginfo", altArch)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	pkgs, err := parseRepoqueryPackages(searchOut, kernel.NewKernelVersion(""))
+	pkgs, err := parseRepoqueryPackages(searchOut, kernel.NewKernelVersion(""), d.runner)
 	if err != nil {
-		return fmt.Errorf("parse package listing: %s", err)
+		return nil, fmt.Errorf("parse package listing: %s", err)
 	}
-	sort.Sort(pkg.ByVersion(pkgs))
 
-	for _, pkg := range pkgs {
-		err := processPackage(ctx, pkg, workDir, force, jobChan)
-		if err != nil {
-			if errors.Is(err, utils.ErrHasBTF) {
-				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", pkg)
-				return nil
-			}
-			return err
-		}
-	}
+	plan := newPlan(target, workDir, force)
+	plan.StopOnError = true
+	plan.AddGroup("default", pkgs)
 
-	return nil
+	return plan, nil
 }
 
-func repoquery(ctx context.Context, pkg string, arch string) (*bytes.Buffer, error) {
-	stdout := &bytes.Buffer{}
-	stderr := &bytes.Buffer{}
-	binary, args := utils.SudoCMD("repoquery", "--archlist="+arch, "--show-duplicates", pkg)
-	cmd := exec.CommandContext(ctx, binary, args...)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("repoquery search %s: %s\n%s", pkg, err, stderr.String())
+func repoquery(ctx context.Context, runner utils.CommandRunner, pkg string, arch string) (*bytes.Buffer, error) {
+	stdout, err := runner.Run(ctx, utils.Command{
+		Name: "repoquery",
+		Args: []string{"--archlist=" + arch, "--show-duplicates", pkg},
+		Root: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repoquery search %s: %s", pkg, err)
 	}
 	return stdout, nil
 }
 
-func parseRepoqueryPackages(rdr io.Reader, minVersion kernel.Version) ([]pkg.Package, error) {
+// parseRepoqueryPackages parses the kernel debuginfo packages of a repoquery,
+// the packages are downloaded with the given runner
+func parseRepoqueryPackages(rdr io.Reader, minVersion kernel.Version, runner utils.CommandRunner) ([]pkg.Package, error) {
 	pkgs := map[string]pkg.Package{}
 	bio := bufio.NewScanner(rdr)
 	for bio.Scan() {
@@ -104,6 +96,7 @@ func parseRepoqueryPackages(rdr io.Reader, minVersion kernel.Version) ([]pkg.Pac
 			NameOfFile:    filename,
 			KernelVersion: kernel.NewKernelVersion(filename[:lastdot]),
 			Architecture:  filename[lastdot+1:],
+			Runner:        runner,
 		}
 		if !minVersion.IsZero() && p.Version().Less(minVersion) {
 			continue
@@ -2,16 +2,13 @@ package repo
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"log"
 	"regexp"
-	"sort"
 	"strings"
 
-	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/kernel"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
@@ -21,11 +18,12 @@ type CentosRepo struct {
 	minVersion kernel.Version
 }
 
-func NewCentOSRepo() Repository {
+func NewCentOSRepo(_ utils.CommandRunner) Repository {
 	return &CentosRepo{
 		archs: map[string]string{
-			"x86_64": "x86_64",
-			"arm64":  "aarch64",
+			"x86_64":  "x86_64",
+			"arm64":   "aarch64",
+			"ppc64le": "ppc64le",
 		},
 		repos: map[string]string{
 			"7": "http://mirror.facebook.net/centos-debuginfo/7/%s/",
@@ -41,8 +39,9 @@ func (d *CentosRepo) GetKernelPackages(
 	release string,
 	arch string,
 	force bool,
-	jobChan chan<- job.Job,
-) error {
+) (*Plan, error) {
+	target := report.Target{Distro: "centos", Release: release, Arch: arch}
+
 	var pkgs []pkg.Package
 
 	altArch := d.archs[arch]
@@ -51,15 +50,15 @@ func (d *CentosRepo) GetKernelPackages(
 
 	repoURL := fmt.Sprintf(d.repos[release], altArch)
 
-	links, err := utils.GetLinks(ctx, repoURL)
+	links, err := utils.GetListing(ctx, repoURL)
 	if err != nil {
-		return fmt.Errorf("ERROR: list packages: %s", err)
+		return nil, fmt.Errorf("ERROR: list packages: %s", err)
 	}
 
 	kre := regexp.MustCompile(fmt.Sprintf(`kernel-debuginfo-([-1-9].*\.%s)\.rpm`, altArch))
 
 	for _, l := range links {
-		match := kre.FindStringSubmatch(l)
+		match := kre.FindStringSubmatch(l.URL)
 		if match != nil {
 			name := strings.TrimSuffix(match[0], ".rpm")
 
@@ -69,7 +68,8 @@ func (d *CentosRepo) GetKernelPackages(
 				Name:          name,
 				NameOfFile:    match[1],
 				Architecture:  altArch,
-				URL:           l,
+				URL:           l.URL,
+				Size:          l.Size,
 				KernelVersion: kernel.NewKernelVersion(match[1]),
 			}
 
@@ -81,32 +81,8 @@ func (d *CentosRepo) GetKernelPackages(
 		}
 	}
 
-	sort.Sort(pkg.ByVersion(pkgs)) // so kernels can be skipped if previous has BTF already
-
-	for i, pkg := range pkgs {
-		log.Printf("DEBUG: start pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
-
-		// Jobs about to be created:
-		//
-		// 1. Download package and extract vmlinux file
-		// 2. Extract BTF info from vmlinux file
-
-		err := processPackage(ctx, pkg, workDir, force, jobChan)
-		if err != nil {
-			if errors.Is(err, utils.ErrHasBTF) {
-				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", pkg)
-				return nil
-			}
-			if errors.Is(err, context.Canceled) {
-				return nil
-			}
-
-			log.Printf("ERROR: %s: %s\n", pkg, err)
-			continue
-		}
-
-		log.Printf("DEBUG: end pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
-	}
+	plan := newPlan(target, workDir, force)
+	plan.AddGroup("default", pkgs)
 
-	return nil
+	return plan, nil
 }
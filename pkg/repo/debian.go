@@ -1,18 +1,14 @@
 package repo
 
 import (
-	"bytes"
 	"context"
-	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"regexp"
-	"sort"
 	"strings"
 
-	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
@@ -38,11 +34,13 @@ var newRepos = []string{
 	"http://security.debian.org/debian-security/dists/%s-security/main/binary-%s/Packages.xz",
 }
 
-func NewDebianRepo() Repository {
+func NewDebianRepo(_ utils.CommandRunner) Repository {
 	return &DebianRepo{
 		archs: map[string]string{
-			"x86_64": "amd64",
-			"arm64":  "arm64",
+			"x86_64":  "amd64",
+			"arm64":   "arm64",
+			"ppc64le": "ppc64el",
+			"s390x":   "s390x",
 		},
 		repos: map[string][]string{
 			"stretch":  archiveRepos,
@@ -68,36 +66,29 @@ func (d *DebianRepo) GetKernelPackages(
 	release string,
 	arch string,
 	force bool,
-	jobChan chan<- job.Job,
-) error {
+) (*Plan, error) {
+	target := report.Target{Distro: "debian", Release: release, Arch: arch}
+
 	altArch := d.archs[arch]
 
 	var pkgs []pkg.Package
 
 	for _, r := range d.repos[release] {
-		rawPkgs := &bytes.Buffer{}
-
 		// Get Packages.xz from main, updates and security
 
 		repo := fmt.Sprintf(r, release, altArch) // ..debian/dists/%s/%s/main.../Packages.gz
 
-		if err := utils.Download(ctx, repo, rawPkgs); err != nil {
-			return fmt.Errorf("download package list %s: %s", repo, err)
-		}
-
-		// Get the list of kernel packages to download from those repos
-
 		repoURL, err := url.Parse(repo)
 		if err != nil {
-			return fmt.Errorf("repo url parse: %s", err)
+			return nil, fmt.Errorf("repo url parse: %s", err)
 		}
 
 		// Get the list of kernel packages to download from debug repo
 
 		repoURL.Path = "/" + strings.Split(repoURL.Path, "/")[1]
-		kernelDbgPkgs, err := pkg.ParseAPTPackages(rawPkgs, repoURL.String(), release)
+		kernelDbgPkgs, err := pkg.GetAPTIndexPackages(ctx, repo, repoURL.String(), release)
 		if err != nil {
-			return fmt.Errorf("parsing package list: %s", err)
+			return nil, fmt.Errorf("package list %s: %s", repo, err)
 		}
 
 		// Filter out packages that aren't debug kernel packages
@@ -113,31 +104,8 @@ func (d *DebianRepo) GetKernelPackages(
 		}
 	}
 
-	sort.Sort(pkg.ByVersion(pkgs)) // so kernels can be skipped if previous has BTF already
-
-	for i, pkg := range pkgs {
-		log.Printf("DEBUG: start pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
-
-		// Jobs about to be created:
-		//
-		// 1. Download package and extract vmlinux file
-		// 2. Extract BTF info from vmlinux file
+	plan := newPlan(target, workDir, force)
+	plan.AddGroup("default", pkgs)
 
-		err := processPackage(ctx, pkg, workDir, force, jobChan)
-		if err != nil {
-			if errors.Is(err, utils.ErrHasBTF) {
-				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", pkg)
-				return nil
-			}
-			if errors.Is(err, context.Canceled) {
-				return nil
-			}
-
-			log.Printf("ERROR: %s: %s\n", pkg, err)
-			continue
-		}
-
-		log.Printf("DEBUG: end pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
-	}
-	return nil
+	return plan, nil
 }
@@ -2,16 +2,14 @@ package repo
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"regexp"
-	"sort"
 	"strings"
 
-	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/kernel"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
@@ -35,21 +33,23 @@ var repoOrganization = []string{
 	"https://archives.fedoraproject.org/pub/archive/fedora/linux/updates/%s/Everything/%s/debug/Packages/k/",
 }
 
-func NewFedoraRepo() Repository {
+func NewFedoraRepo(_ utils.CommandRunner) Repository {
 	return &FedoraRepo{
 		archs: map[string]string{
-			"x86_64": "x86_64",
-			"arm64":  "aarch64",
+			"x86_64":  "x86_64",
+			"arm64":   "aarch64",
+			"ppc64le": "ppc64le",
+			"s390x":   "s390x",
 		},
 		repos: map[string][]string{
 			"24": olderRepoOrganization, // amd64
 			"25": oldRepoOrganization,   // amd64
 			"26": oldRepoOrganization,   // amd64
 			"27": oldRepoOrganization,   // amd64
-			"28": repoOrganization,      // amd64, arm64
-			"29": repoOrganization,      // amd64, arm64
-			"30": repoOrganization,      // amd64, arm64
-			"31": repoOrganization,      // amd64, arm64
+			"28": repoOrganization,      // amd64, arm64, ppc64le, s390x
+			"29": repoOrganization,      // amd64, arm64, ppc64le, s390x
+			"30": repoOrganization,      // amd64, arm64, ppc64le, s390x
+			"31": repoOrganization,      // amd64, arm64, ppc64le, s390x
 			// "32": repoOrganization,
 			// "33": repoOrganization,
 			// "34": repoOrganization,
@@ -66,18 +66,13 @@ func (d *FedoraRepo) GetKernelPackages(
 	release string,
 	arch string,
 	force bool,
-	jobChan chan<- job.Job,
-) error {
+) (*Plan, error) {
 
-	if release == "24" || release == "25" || release == "26" || release == "27" {
-		if arch == "arm64" {
-			log.Printf("INFO: Fedora %s does not have arm64 packages\n", release)
-			return nil
-		}
-	}
+	target := report.Target{Distro: "fedora", Release: release, Arch: arch}
+	plan := newPlan(target, workDir, force)
 
 	var pkgs []pkg.Package
-	var links []string
+	var links []utils.Link
 	var repos []string
 
 	altArch := d.archs[arch]
@@ -90,7 +85,7 @@ func (d *FedoraRepo) GetKernelPackages(
 	// Pick all the links from multiple repositories
 
 	for _, repo := range repos {
-		rlinks, err := utils.GetLinks(ctx, repo)
+		rlinks, err := utils.GetListing(ctx, repo)
 		if err != nil {
 			log.Printf("ERROR: list packages: %s\n", err)
 			continue
@@ -103,7 +98,7 @@ func (d *FedoraRepo) GetKernelPackages(
 	kre := regexp.MustCompile(fmt.Sprintf(`kernel-debuginfo-([0-9].*\.%s)\.rpm`, altArch))
 
 	for _, l := range links {
-		match := kre.FindStringSubmatch(l)
+		match := kre.FindStringSubmatch(l.URL)
 		if match != nil {
 			name := strings.TrimSuffix(match[0], ".rpm")
 
@@ -113,7 +108,8 @@ func (d *FedoraRepo) GetKernelPackages(
 				Name:          name,
 				NameOfFile:    match[1],
 				Architecture:  altArch,
-				URL:           l,
+				URL:           l.URL,
+				Size:          l.Size,
 				KernelVersion: kernel.NewKernelVersion(match[1]),
 			}
 
@@ -121,32 +117,7 @@ func (d *FedoraRepo) GetKernelPackages(
 		}
 	}
 
-	sort.Sort(pkg.ByVersion(pkgs)) // so kernels can be skipped if previous has BTF already
-
-	for i, pkg := range pkgs {
-		log.Printf("DEBUG: start pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
-
-		// Jobs about to be created:
-		//
-		// 1. Download package and extract vmlinux file
-		// 2. Extract BTF info from vmlinux file
-
-		err := processPackage(ctx, pkg, workDir, force, jobChan)
-		if err != nil {
-			if errors.Is(err, utils.ErrHasBTF) {
-				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", pkg)
-				return nil
-			}
-			if errors.Is(err, context.Canceled) {
-				return nil
-			}
-
-			log.Printf("ERROR: %s: %s\n", pkg, err)
-			continue
-		}
-
-		log.Printf("DEBUG: end pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
-	}
+	plan.AddGroup("default", pkgs)
 
-	return nil
+	return plan, nil
 }
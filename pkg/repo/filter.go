@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/pkg"
+)
+
+// Filter selects the kernel packages of a plan. Zero value fields match all
+// packages.
+type Filter struct {
+	Kernel       string         // kernel name (as in the BTF file name) or package version
+	MinVersion   kernel.Version // oldest package version (inclusive)
+	MaxVersion   kernel.Version // newest package version (inclusive)
+	Flavors      []string       // kernel flavors (generic, aws, azure, default, uek, ...)
+	PackageRegex *regexp.Regexp // package name
+}
+
+// IsZero returns true if the filter matches all packages
+func (f *Filter) IsZero() bool {
+	return f.Kernel == "" &&
+		f.MinVersion.IsZero() &&
+		f.MaxVersion.IsZero() &&
+		len(f.Flavors) == 0 &&
+		f.PackageRegex == nil
+}
+
+// Match returns true if the given package, of the given flavor, is selected
+func (f *Filter) Match(p pkg.Package, flavor string) bool {
+	if f.Kernel != "" && f.Kernel != p.BTFFilename() && f.Kernel != p.Version().String() {
+		return false
+	}
+	if !f.MinVersion.IsZero() && p.Version().Less(f.MinVersion) {
+		return false
+	}
+	if !f.MaxVersion.IsZero() && f.MaxVersion.Less(p.Version()) {
+		return false
+	}
+	if len(f.Flavors) > 0 && !slices.Contains(f.Flavors, flavor) {
+		return false
+	}
+	if f.PackageRegex != nil && !f.PackageRegex.MatchString(p.String()) {
+		return false
+	}
+	return true
+}
+
+// Filter removes the entries of the plan not selected by the given filter.
+// Skip reasons are kept as they were planned, so kernels after one that has a
+// .BTF section are still skipped even if that kernel is filtered out.
+func (plan *Plan) Filter(f *Filter) {
+	if f == nil || f.IsZero() {
+		return
+	}
+
+	var groups []*PlanGroup
+
+	for _, g := range plan.Groups {
+		var entries []*PlanEntry
+		for _, e := range g.Entries {
+			if f.Match(e.Package, g.Flavor) {
+				entries = append(entries, e)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		g.Entries = entries
+		groups = append(groups, g)
+	}
+
+	plan.Groups = groups
+}
@@ -0,0 +1,109 @@
+package repo
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/fakerepo"
+	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/pkg"
+)
+
+func centosPackage(name string, version string) pkg.Package {
+	return &pkg.CentOSPackage{
+		Name:          name + "-" + version,
+		NameOfFile:    version,
+		KernelVersion: kernel.NewKernelVersion(version),
+	}
+}
+
+func TestPlanFilter(t *testing.T) {
+	srv := fakerepo.NewServer(t)
+	srv.AddListing("https://oss.oracle.com/ol8/debuginfo/",
+		"kernel-debuginfo-4.18.0-193.el8.x86_64.rpm",
+		"kernel-uek-debuginfo-5.4.17-2036.el8uek.x86_64.rpm",
+		"kernel-debuginfo-4.18.0-80.el8.x86_64.rpm",
+		"kernel-uek-debuginfo-5.4.17-2011.el8uek.x86_64.rpm",
+		"kernel-debuginfo-4.18.0-147.el8.x86_64.rpm",
+		"kernel-debuginfo-4.18.0-147.el8.aarch64.rpm",
+	)
+
+	newTestPlan := func() *Plan {
+		plan, err := NewOracleRepo(nil).GetKernelPackages(context.Background(), t.TempDir(), "8", "x86_64", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plan
+	}
+
+	var flavors []string
+	for _, g := range newTestPlan().Groups {
+		flavors = append(flavors, g.Flavor)
+	}
+	if len(flavors) != 2 || flavors[0] != "default" || flavors[1] != "uek" {
+		t.Fatalf("flavors %v, want [default uek]", flavors)
+	}
+
+	kernels := func(plan *Plan) []string {
+		var ks []string
+		for _, e := range plan.Entries() {
+			ks = append(ks, e.Kernel)
+		}
+		return ks
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{
+			name:   "no filter",
+			filter: Filter{},
+			want: []string{
+				"4.18.0-80.el8.x86_64", "4.18.0-147.el8.x86_64", "4.18.0-193.el8.x86_64",
+				"5.4.17-2011.el8uek.x86_64", "5.4.17-2036.el8uek.x86_64",
+			},
+		},
+		{
+			name:   "kernel",
+			filter: Filter{Kernel: "4.18.0-147.el8.x86_64"},
+			want:   []string{"4.18.0-147.el8.x86_64"},
+		},
+		{
+			name: "version range",
+			filter: Filter{
+				MinVersion: kernel.NewKernelVersion("4.18.0-147"),
+				MaxVersion: kernel.NewKernelVersion("5.4.17-2011.el8uek.x86_64"),
+			},
+			want: []string{"4.18.0-147.el8.x86_64", "4.18.0-193.el8.x86_64", "5.4.17-2011.el8uek.x86_64"},
+		},
+		{
+			name:   "flavor",
+			filter: Filter{Flavors: []string{"uek"}},
+			want:   []string{"5.4.17-2011.el8uek.x86_64", "5.4.17-2036.el8uek.x86_64"},
+		},
+		{
+			name:   "package regex",
+			filter: Filter{PackageRegex: regexp.MustCompile(`^kernel-debuginfo-4\.18\.0-1`)},
+			want:   []string{"4.18.0-147.el8.x86_64", "4.18.0-193.el8.x86_64"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := newTestPlan()
+			plan.Filter(&tt.filter)
+			got := kernels(plan)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
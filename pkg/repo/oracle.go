@@ -2,16 +2,14 @@ package repo
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"regexp"
-	"sort"
 	"strings"
 
-	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/kernel"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
@@ -21,7 +19,7 @@ type oracleRepo struct {
 	minVersion kernel.Version
 }
 
-func NewOracleRepo() Repository {
+func NewOracleRepo(_ utils.CommandRunner) Repository {
 	return &oracleRepo{
 		archs: map[string]string{
 			"arm64":  "aarch64",
@@ -41,70 +39,60 @@ func (d *oracleRepo) GetKernelPackages(
 	release string,
 	arch string,
 	force bool,
-	jobChan chan<- job.Job,
-) error {
-	var pkgs []pkg.Package
+) (*Plan, error) {
+	target := report.Target{Distro: "ol", Release: release, Arch: arch}
+
+	pkgsByFlavor := make(map[string][]pkg.Package) // map[flavor]packages
 
 	altArch := d.archs[arch]
 
-	// Pick all the links that match the kernel-debuginfo pattern
+	// Pick all the links that match the kernel-debuginfo pattern, grouped by
+	// flavor: the Unbreakable Enterprise Kernels (kernel-uek-) or the Red Hat
+	// compatible ones (kernel-)
 
 	repoURL := d.repos[release]
 
-	links, err := utils.GetLinks(ctx, repoURL)
+	links, err := utils.GetListing(ctx, repoURL)
 	if err != nil {
-		return fmt.Errorf("ERROR: list packages: %s", err)
+		return nil, fmt.Errorf("ERROR: list packages: %s", err)
 	}
 
-	kre := regexp.MustCompile(fmt.Sprintf(`kernel(?:-uek)?-debuginfo-([0-9].*\.%s)\.rpm`, altArch))
+	kre := regexp.MustCompile(fmt.Sprintf(`kernel(-uek)?-debuginfo-([0-9].*\.%s)\.rpm`, altArch))
 
 	for _, l := range links {
-		match := kre.FindStringSubmatch(l)
+		match := kre.FindStringSubmatch(l.URL)
 		if match != nil {
 
 			// Create a package object from the link and add it to pkgs list
 
 			p := &pkg.CentOSPackage{
 				Name:          strings.TrimSuffix(match[0], ".rpm"),
-				NameOfFile:    match[1],
+				NameOfFile:    match[2],
 				Architecture:  altArch,
-				URL:           l,
-				KernelVersion: kernel.NewKernelVersion(match[1]),
+				URL:           l.URL,
+				Size:          l.Size,
+				KernelVersion: kernel.NewKernelVersion(match[2]),
 			}
 			if p.Version().Less(d.minVersion) {
 				continue
 			}
 
-			pkgs = append(pkgs, p)
+			flavor := "default"
+			if match[1] != "" {
+				flavor = "uek"
+			}
+			pkgsByFlavor[flavor] = append(pkgsByFlavor[flavor], p)
 		}
 	}
 
-	sort.Sort(pkg.ByVersion(pkgs)) // so kernels can be skipped if previous has BTF already
-
-	for i, pkg := range pkgs {
-		log.Printf("DEBUG: start pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
-
-		// Jobs about to be created:
-		//
-		// 1. Download package and extract vmlinux file
-		// 2. Extract BTF info from vmlinux file
+	plan := newPlan(target, workDir, force)
 
-		err := processPackage(ctx, pkg, workDir, force, jobChan)
-		if err != nil {
-			if errors.Is(err, utils.ErrHasBTF) {
-				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", pkg)
-				return nil
-			}
-			if errors.Is(err, context.Canceled) {
-				return nil
-			}
-
-			log.Printf("ERROR: %s: %s\n", pkg, err)
-			continue
+	for _, flavor := range []string{"default", "uek"} {
+		if pkgs, ok := pkgsByFlavor[flavor]; ok {
+			log.Printf("DEBUG: %s %s flavor %d kernels\n", arch, flavor, len(pkgs))
+			plan.AddGroup(flavor, pkgs)
 		}
-
-		log.Printf("DEBUG: end pkg %s (%d/%d)\n", pkg, i+1, len(pkgs))
 	}
 
-	return nil
+	return plan, nil
 }
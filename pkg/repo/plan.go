@@ -0,0 +1,288 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/job"
+	"github.com/aquasecurity/btfhub/pkg/metrics"
+	"github.com/aquasecurity/btfhub/pkg/output"
+	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// Action tells what is going to be done with a planned package
+type Action string
+
+const (
+	ActionProcess Action = "process"
+	ActionSkip    Action = "skip"
+)
+
+// Reasons for an action to be taken on a planned package
+const (
+	ReasonNew         = "new kernel"
+	ReasonForced      = "forced"
+	ReasonExists      = "BTF file exists"
+	ReasonFailed      = "previously failed"
+	ReasonStale       = "BTF file from another toolchain"
+	ReasonHasBTF      = "kernel has .BTF section"
+	ReasonAfterHasBTF = "older kernel has .BTF section"
+)
+
+// PlanEntry is a kernel package and what is going to be done with it
+type PlanEntry struct {
+	Package pkg.Package `json:"-"`
+	Name    string      `json:"package"`
+	Kernel  string      `json:"kernel"`
+	Flavor  string      `json:"flavor,omitempty"`
+	Size    uint64      `json:"size"`
+	BTFPath string      `json:"btf"`
+	Action  Action      `json:"action"`
+	Reason  string      `json:"reason"`
+}
+
+// PlanGroup is a list of kernel packages, sorted by version, processed in
+// order: once a kernel is found to have a .BTF section, the remaining kernels
+// of the group are skipped.
+type PlanGroup struct {
+	Flavor  string       `json:"flavor,omitempty"`
+	Entries []*PlanEntry `json:"entries"`
+}
+
+// Plan is the list of kernel packages found for a target and what is going to
+// be done with each one of them. Groups are processed concurrently. The BTF
+// files and markers are kept in WorkDir, while the downloaded packages and
+// other intermediate files go to ScratchDir (WorkDir if empty). If Store is
+// set, the generated BTF files are deduplicated in it. If Cache is set, the
+// downloaded packages are kept in it, and taken from it when needed again.
+// The kernel modules in Modules also get split BTF files, kept next to the
+// kernel BTF file. With StopOnError, a package failing to be processed stops
+// its group (and the run) instead of being logged and skipped. Pahole is the
+// toolchain of the BTF generation jobs.
+type Plan struct {
+	Target     report.Target         `json:"target"`
+	WorkDir    string                `json:"workdir"`
+	ScratchDir string                `json:"scratchdir,omitempty"`
+	Force      bool                  `json:"force"`
+	Modules    []string              `json:"modules,omitempty"`
+	Groups     []*PlanGroup          `json:"groups"`
+	Store      *archive.Store        `json:"-"`
+	Cache      *archive.PackageCache `json:"-"`
+	Pahole     *job.Pahole           `json:"-"`
+
+	StopOnError bool `json:"-"`
+}
+
+func newPlan(target report.Target, workDir string, force bool) *Plan {
+	return &Plan{
+		Target:  target,
+		WorkDir: workDir,
+		Force:   force,
+	}
+}
+
+// AddGroup sorts the given packages by version and adds them to the plan as a
+// group, deciding whether each one of them should be processed or skipped.
+func (plan *Plan) AddGroup(flavor string, pkgs []pkg.Package) {
+	sort.Sort(pkg.ByVersion(pkgs)) // so kernels can be skipped if previous has BTF already
+
+	group := &PlanGroup{Flavor: flavor}
+	hasBTF := false
+
+	for _, p := range pkgs {
+		entry := &PlanEntry{
+			Package: p,
+			Name:    p.String(),
+			Kernel:  p.BTFFilename(),
+			Flavor:  flavor,
+			Size:    p.DownloadSize(),
+			BTFPath: filepath.Join(plan.WorkDir, fmt.Sprintf("%s%s", p.BTFFilename(), output.Default.Ext())),
+			Action:  ActionSkip,
+		}
+
+		switch {
+		case hasBTF:
+			entry.Reason = ReasonAfterHasBTF
+		case pkg.PackageHasBTF(p, plan.WorkDir):
+			entry.Reason = ReasonHasBTF
+			hasBTF = true
+		case plan.Force:
+			entry.Action, entry.Reason = ActionProcess, ReasonForced
+		case utils.Exists(entry.BTFPath):
+			entry.Reason = ReasonExists
+		case pkg.PackageFailed(p, plan.WorkDir):
+			entry.Reason = ReasonFailed
+		default:
+			entry.Action, entry.Reason = ActionProcess, ReasonNew
+		}
+
+		group.Entries = append(group.Entries, entry)
+	}
+
+	plan.Groups = append(plan.Groups, group)
+
+	sort.Slice(plan.Groups, func(i, j int) bool {
+		return plan.Groups[i].Flavor < plan.Groups[j].Flavor
+	})
+}
+
+// MarkStale processes again, instead of skipping them, the existing BTF files
+// not generated by the given toolchain (see job.Pahole.Stale). Their files are
+// kept until the regenerated ones replace them.
+func (plan *Plan) MarkStale(pahole *job.Pahole) error {
+	m, err := archive.ReadManifest(plan.WorkDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range plan.Entries() {
+		if e.Action != ActionSkip || e.Reason != ReasonExists {
+			continue
+		}
+		if pahole.Stale(m.Files[filepath.Base(e.BTFPath)]) {
+			e.Action, e.Reason = ActionProcess, ReasonStale
+		}
+	}
+
+	return nil
+}
+
+// scratchDir returns the directory for the intermediate files of the plan
+func (plan *Plan) scratchDir() string {
+	if plan.ScratchDir != "" {
+		return plan.ScratchDir
+	}
+	return plan.WorkDir
+}
+
+// Entries returns all the entries of the plan
+func (plan *Plan) Entries() []*PlanEntry {
+	var entries []*PlanEntry
+	for _, g := range plan.Groups {
+		entries = append(entries, g.Entries...)
+	}
+	return entries
+}
+
+// ExecutePlan processes the groups of the plan concurrently, sending jobs to
+// the workers for the packages that should be processed.
+func ExecutePlan(ctx context.Context, plan *Plan, jobChan chan<- job.Job) error {
+	g, ctx := errgroup.WithContext(ctx)
+
+	for _, group := range plan.Groups {
+		g.Go(func() error {
+			log.Printf("DEBUG: start %s kernels %s (%d pkgs)\n", group.Flavor, plan.Target, len(group.Entries))
+			err := executeGroup(ctx, plan, group, jobChan)
+			log.Printf("DEBUG: end %s kernels %s\n", group.Flavor, plan.Target)
+			return err
+		})
+	}
+
+	return g.Wait()
+}
+
+// executeGroup processes the entries of a group in order, stopping at the first
+// kernel that has a .BTF section.
+func executeGroup(ctx context.Context, plan *Plan, group *PlanGroup, jobChan chan<- job.Job) error {
+	target := plan.Target
+
+	for i, e := range group.Entries {
+		metrics.PackagesDiscovered.WithLabelValues(target.Distro).Inc()
+
+		if e.Action == ActionSkip {
+			switch e.Reason {
+			case ReasonExists:
+				log.Printf("SKIP: %s exists\n", filepath.Base(e.BTFPath))
+				metrics.PackagesSkipped.WithLabelValues(target.Distro, metrics.SkipExists).Inc()
+				report.Default.Skipped(target, e.Kernel)
+			case ReasonFailed:
+				metrics.PackagesSkipped.WithLabelValues(target.Distro, metrics.SkipFailed).Inc()
+			case ReasonHasBTF:
+				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", e.Package)
+				metrics.PackagesSkipped.WithLabelValues(target.Distro, metrics.SkipHasBTF).Inc()
+				report.Default.HasBTF(target, e.Kernel)
+			}
+			continue
+		}
+
+		log.Printf("DEBUG: start pkg %s (%d/%d)\n", e.Package, i+1, len(group.Entries))
+
+		// Jobs about to be created:
+		//
+		// 1. Download package and extract vmlinux file
+		// 2. Extract BTF info from vmlinux file
+
+		err := processPackage(ctx, plan, e.Package, e.Reason == ReasonStale, jobChan)
+		if err != nil {
+			if errors.Is(err, utils.ErrHasBTF) {
+				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", e.Package)
+				return nil
+			}
+			if plan.StopOnError {
+				return err
+			}
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+
+			log.Printf("ERROR: %s: %s\n", e.Package, err)
+			continue
+		}
+
+		log.Printf("DEBUG: end pkg %s (%d/%d)\n", e.Package, i+1, len(group.Entries))
+	}
+
+	return nil
+}
+
+// WritePlans writes the given plans in a human readable table
+func WritePlans(w io.Writer, plans []*Plan) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "TARGET\tFLAVOR\tACTION\tPACKAGE\tSIZE\tBTF\tREASON\n")
+
+	var size uint64
+	var count int
+
+	for _, plan := range plans {
+		for _, e := range plan.Entries() {
+			sz := "-"
+			if e.Size > 0 {
+				sz = humanize.Bytes(e.Size)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				plan.Target, e.Flavor, e.Action, e.Name, sz, e.BTFPath, e.Reason)
+			if e.Action == ActionProcess {
+				size += e.Size
+				count++
+			}
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d packages to process (%s to download)\n", count, humanize.Bytes(size))
+	return err
+}
+
+// WritePlansJSON writes the given plans as JSON
+func WritePlansJSON(w io.Writer, plans []*Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plans)
+}
@@ -0,0 +1,59 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aquasecurity/btfhub/pkg/archive"
+	"github.com/aquasecurity/btfhub/pkg/job"
+	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
+)
+
+func TestPlanMarkStale(t *testing.T) {
+	pahole := &job.Pahole{
+		Version: job.PaholeVersion{Major: 1, Minor: 25},
+		Flags:   []string{"--btf_gen_floats"},
+	}
+
+	dir := t.TempDir()
+	m := &archive.Manifest{Files: map[string]*archive.ManifestEntry{
+		"4.18.0-80.el8.x86_64.btf.tar.xz":  {Pahole: "v1.25", PaholeFlags: []string{"--btf_gen_floats"}},
+		"4.18.0-147.el8.x86_64.btf.tar.xz": {Pahole: "v1.22", PaholeFlags: []string{"--btf_gen_floats"}},
+		"4.18.0-193.el8.x86_64.btf.tar.xz": {Pahole: "v1.25"},
+		// 4.18.0-240.el8.x86_64: no entry (unknown toolchain)
+	}}
+	if err := m.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	versions := []string{"4.18.0-80.el8.x86_64", "4.18.0-147.el8.x86_64", "4.18.0-193.el8.x86_64", "4.18.0-240.el8.x86_64"}
+	var pkgs []pkg.Package
+	for _, v := range versions {
+		pkgs = append(pkgs, centosPackage("kernel-debuginfo", v))
+		if err := os.WriteFile(filepath.Join(dir, v+".btf.tar.xz"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkgs = append(pkgs, centosPackage("kernel-debuginfo", "4.18.0-305.el8.x86_64")) // new
+
+	plan := newPlan(report.Target{Distro: "centos", Release: "8", Arch: "x86_64"}, dir, false)
+	plan.AddGroup("default", pkgs)
+	if err := plan.MarkStale(pahole); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"4.18.0-80.el8.x86_64":  ReasonExists,
+		"4.18.0-147.el8.x86_64": ReasonStale,
+		"4.18.0-193.el8.x86_64": ReasonStale,
+		"4.18.0-240.el8.x86_64": ReasonStale,
+		"4.18.0-305.el8.x86_64": ReasonNew,
+	}
+	for _, e := range plan.Entries() {
+		if e.Reason != want[e.Kernel] {
+			t.Errorf("%s: reason %q, want %q", e.Kernel, e.Reason, want[e.Kernel])
+		}
+	}
+}
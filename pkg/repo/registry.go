@@ -0,0 +1,148 @@
+package repo
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aquasecurity/btfhub/pkg/utils"
+)
+
+// Distro describes a distribution and the repository used to fetch its kernel
+// packages.
+type Distro struct {
+	Name         string
+	Releases     []string
+	Archs        []string
+	ReleaseArchs map[string][]string // archs of the releases not published for all Archs
+	Default      bool                // updated when no distribution is selected
+	Modules      []string            // kernel modules to generate split BTF files for
+	New          func(runner utils.CommandRunner) Repository
+}
+
+// defaultModules are the kernel modules commonly traced by eBPF tools, shipped
+// as modules by all the distributions
+var defaultModules = []string{
+	"br_netfilter",
+	"nf_conntrack",
+	"nf_nat",
+	"nf_tables",
+	"overlay",
+	"xfs",
+}
+
+// HasRelease returns true if the given release is supported
+func (d *Distro) HasRelease(release string) bool {
+	return slices.Contains(d.Releases, release)
+}
+
+// HasArch returns true if the given architecture is supported
+func (d *Distro) HasArch(arch string) bool {
+	return slices.Contains(d.Archs, arch)
+}
+
+// ReleaseHasArch returns true if the given release is published for the given
+// architecture
+func (d *Distro) ReleaseHasArch(release string, arch string) bool {
+	if archs, ok := d.ReleaseArchs[release]; ok {
+		return slices.Contains(archs, arch)
+	}
+	return d.HasArch(arch)
+}
+
+var registry = []*Distro{
+	{
+		Name:     "ubuntu",
+		Releases: []string{"xenial", "bionic", "focal"},
+		Archs:    []string{"x86_64", "arm64", "ppc64le", "s390x"},
+		Default:  true,
+		Modules:  defaultModules,
+		New:      NewUbuntuRepo,
+	},
+	{
+		Name:     "debian",
+		Releases: []string{"stretch", "buster", "bullseye"},
+		Archs:    []string{"x86_64", "arm64", "ppc64le", "s390x"},
+		Default:  true,
+		Modules:  defaultModules,
+		New:      NewDebianRepo,
+	},
+	{
+		Name:     "fedora",
+		Releases: []string{"24", "25", "26", "27", "28", "29", "30", "31"},
+		Archs:    []string{"x86_64", "arm64", "ppc64le", "s390x"},
+		ReleaseArchs: map[string][]string{
+			"24": {"x86_64"},
+			"25": {"x86_64"},
+			"26": {"x86_64"},
+			"27": {"x86_64"},
+		},
+		Default: true,
+		Modules: defaultModules,
+		New:     NewFedoraRepo,
+	},
+	{
+		Name:     "centos",
+		Releases: []string{"7", "8"},
+		Archs:    []string{"x86_64", "arm64", "ppc64le"}, // no s390x before CentOS Stream 9 (kernels with BTF)
+		ReleaseArchs: map[string][]string{
+			"7": {"x86_64", "arm64"},
+		},
+		Default: true,
+		Modules: defaultModules,
+		New:     NewCentOSRepo,
+	},
+	{
+		Name:     "ol",
+		Releases: []string{"7", "8"},
+		Archs:    []string{"x86_64", "arm64"},
+		Default:  true,
+		Modules:  defaultModules,
+		New:      NewOracleRepo,
+	},
+	{
+		Name:     "rhel", // needs subscription
+		Releases: []string{"7", "8"},
+		Archs:    []string{"x86_64", "arm64", "ppc64le", "s390x"},
+		Modules:  defaultModules,
+		New:      NewRHELRepo,
+	},
+	{
+		Name:     "amzn", // needs the debuginfo repositories configured
+		Releases: []string{"1", "2"},
+		Archs:    []string{"x86_64", "arm64"},
+		Modules:  append(defaultModules, "ena"),
+		New:      NewAmazonRepo,
+	},
+	{
+		Name:     "sles", // needs a registered system
+		Releases: []string{"12.3", "12.5", "15.1", "15.2", "15.3", "15.4"},
+		Archs:    []string{"x86_64", "arm64", "ppc64le", "s390x"},
+		Modules:  append(defaultModules, "btrfs"),
+		New:      NewSUSERepo,
+	},
+}
+
+// Distros returns all the registered distributions
+func Distros() []*Distro {
+	return registry
+}
+
+// DistroNames returns the names of all the registered distributions
+func DistroNames() []string {
+	var names []string
+	for _, d := range registry {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+// GetDistro returns the registered distribution with the given name
+func GetDistro(name string) (*Distro, error) {
+	for _, d := range registry {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid distribution %s (valid: %s)", name, strings.Join(DistroNames(), ","))
+}
@@ -2,17 +2,16 @@ package repo
 
 import (
 	"context"
-
-	"github.com/aquasecurity/btfhub/pkg/job"
 )
 
 type Repository interface {
+	// GetKernelPackages finds the kernel packages of a release and returns the
+	// plan of what is going to be done with each one of them (see ExecutePlan)
 	GetKernelPackages(
 		ctx context.Context,
 		workDir string,
 		release string,
 		arch string,
 		force bool,
-		jobChan chan<- job.Job,
-	) error
+	) (*Plan, error)
 }
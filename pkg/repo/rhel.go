@@ -2,14 +2,10 @@ package repo
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"log"
-	"sort"
 
-	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/kernel"
-	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
@@ -17,21 +13,29 @@ type RHELRepo struct {
 	archs           map[string]string
 	releaseVersions map[string]string
 	minVersion      kernel.Version
+	runner          utils.CommandRunner
 }
 
-func NewRHELRepo() Repository {
+func NewRHELRepo(runner utils.CommandRunner) Repository {
 	return &RHELRepo{
 		archs: map[string]string{
-			"x86_64": "x86_64",
-			"arm64":  "aarch64",
+			"x86_64":  "x86_64",
+			"arm64":   "aarch64",
+			"ppc64le": "ppc64le",
+			"s390x":   "s390x",
 		},
 		releaseVersions: map[string]string{
 			"7:x86_64":  "7.9",
 			"7:aarch64": "7Server",
+			"7:ppc64le": "7.9",
+			"7:s390x":   "7.9",
 			"8:x86_64":  "8.1",
 			"8:aarch64": "8.1",
+			"8:ppc64le": "8.1",
+			"8:s390x":   "8.1",
 		},
 		minVersion: kernel.NewKernelVersion("3.10.0-957"),
+		runner:     runner,
 	}
 }
 
@@ -41,35 +45,33 @@ func (d *RHELRepo) GetKernelPackages(
 	release string,
 	arch string,
 	force bool,
-	jobChan chan<- job.Job,
-) error {
+) (*Plan, error) {
+	target := report.Target{Distro: "rhel", Release: release, Arch: arch}
+
 	altArch := d.archs[arch]
 	rver := d.releaseVersions[release+":"+altArch]
-	binary, args := utils.SudoCMD("subscription-manager", "release", fmt.Sprintf("--set=%s", rver))
-	if err := utils.RunCMD(ctx, "", binary, args...); err != nil {
-		return err
+	_, err := d.runner.Run(ctx, utils.Command{
+		Name:    "subscription-manager",
+		Args:    []string{"release", fmt.Sprintf("--set=%s", rver)},
+		Root:    true,
+		Changes: true,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	searchOut, err := yumSearch(ctx, "kernel-debuginfo")
+	searchOut, err := yumSearch(ctx, d.runner, "kernel-debuginfo")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	pkgs, err := parseYumPackages(searchOut, d.minVersion)
+	pkgs, err := parseYumPackages(searchOut, d.minVersion, d.runner)
 	if err != nil {
-		return fmt.Errorf("parse package listing: %s", err)
+		return nil, fmt.Errorf("parse package listing: %s", err)
 	}
-	sort.Sort(pkg.ByVersion(pkgs))
 
-	for _, pkg := range pkgs {
-		err := processPackage(ctx, pkg, workDir, force, jobChan)
-		if err != nil {
-			if errors.Is(err, utils.ErrHasBTF) {
-				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", pkg)
-				return nil
-			}
-			return err
-		}
-	}
+	plan := newPlan(target, workDir, force)
+	plan.StopOnError = true
+	plan.AddGroup("default", pkgs)
 
-	return nil
+	return plan, nil
 }
@@ -4,82 +4,92 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"log"
+	"path/filepath"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"unicode"
 
-	"golang.org/x/sync/errgroup"
-
-	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/kernel"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
+// ZyppPackagesDir is where zypper downloads the packages to (in an alias/arch
+// directory per repository)
+var ZyppPackagesDir = "/var/cache/zypp/packages"
+
 type suseRepo struct {
 	archs       map[string]string
 	repoAliases map[string]string
+	runner      utils.CommandRunner
 }
 
-func NewSUSERepo() Repository {
+func NewSUSERepo(runner utils.CommandRunner) Repository {
 	return &suseRepo{
 		archs: map[string]string{
-			"x86_64": "x86_64",
-			"arm64":  "aarch64",
+			"x86_64":  "x86_64",
+			"arm64":   "aarch64",
+			"ppc64le": "ppc64le",
+			"s390x":   "s390x",
 		},
 		repoAliases: map[string]string{},
+		runner:      runner,
 	}
 }
 
-func (d *suseRepo) GetKernelPackages(ctx context.Context, dir string, release string, arch string, force bool, jobchan chan<- job.Job) error {
+func (d *suseRepo) GetKernelPackages(ctx context.Context, dir string, release string, arch string, force bool) (*Plan, error) {
+	target := report.Target{Distro: "sles", Release: release, Arch: arch}
+
+	// repositories, zypper and its package cache name the arch as SUSE does
+	altArch := d.archs[arch]
+
 	var repos []string
 
 	switch release {
 	case "12.3":
-		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_12_SP3_%s:SLES12-SP3-Debuginfo-Pool", arch))
-		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_12_SP3_%s:SLES12-SP3-Debuginfo-Updates", arch))
+		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_12_SP3_%s:SLES12-SP3-Debuginfo-Pool", altArch))
+		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_12_SP3_%s:SLES12-SP3-Debuginfo-Updates", altArch))
 	case "12.5":
-		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_%s:SLES12-SP5-Debuginfo-Pool", arch))
-		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_%s:SLES12-SP5-Debuginfo-Updates", arch))
+		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_%s:SLES12-SP5-Debuginfo-Pool", altArch))
+		repos = append(repos, fmt.Sprintf("SUSE_Linux_Enterprise_Server_%s:SLES12-SP5-Debuginfo-Updates", altArch))
 	case "15.1":
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_15_SP1_%s:SLE-Module-Basesystem15-SP1-Debuginfo-Pool", arch))
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_15_SP1_%s:SLE-Module-Basesystem15-SP1-Debuginfo-Updates", arch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_15_SP1_%s:SLE-Module-Basesystem15-SP1-Debuginfo-Pool", altArch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_15_SP1_%s:SLE-Module-Basesystem15-SP1-Debuginfo-Updates", altArch))
 	case "15.2":
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP2-Debuginfo-Pool", arch))
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP2-Debuginfo-Updates", arch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP2-Debuginfo-Pool", altArch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP2-Debuginfo-Updates", altArch))
 	case "15.3":
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP3-Debuginfo-Pool", arch))
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP3-Debuginfo-Updates", arch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP3-Debuginfo-Pool", altArch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP3-Debuginfo-Updates", altArch))
 	case "15.4":
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP4-Debuginfo-Pool", arch))
-		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP4-Debuginfo-Updates", arch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP4-Debuginfo-Pool", altArch))
+		repos = append(repos, fmt.Sprintf("Basesystem_Module_%s:SLE-Module-Basesystem15-SP4-Debuginfo-Updates", altArch))
 	}
 	for _, r := range repos {
-		if _, err := utils.RunZypperCMD(ctx, "modifyrepo", "--enable", r); err != nil {
-			return err
+		if _, err := utils.RunZypperCMD(ctx, d.runner, "modifyrepo", "--enable", r); err != nil {
+			return nil, err
 		}
 	}
 
 	if err := d.getRepoAliases(ctx); err != nil {
-		return fmt.Errorf("repo aliases: %s", err)
+		return nil, fmt.Errorf("repo aliases: %s", err)
 	}
 
 	// packages are named kernel-<type>-debuginfo
 	// possible types are: default, azure
-	searchOut, err := zypperSearch(ctx, "kernel-*-debuginfo")
+	searchOut, err := d.zypperSearch(ctx, "kernel-*-debuginfo")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pkgs, err := d.parseZypperPackages(searchOut, arch)
+	pkgs, err := d.parseZypperPackages(searchOut, altArch)
 	if err != nil {
-		return fmt.Errorf("parse package listing: %s", err)
+		return nil, fmt.Errorf("parse package listing: %s", err)
 	}
 
 	pkgsByKernelType := make(map[string][]pkg.Package)
@@ -92,27 +102,18 @@ func (d *suseRepo) GetKernelPackages(ctx context.Context, dir string, release st
 		pkgsByKernelType[p.Flavor] = ks
 	}
 
-	for kt, ks := range pkgsByKernelType {
-		sort.Sort(pkg.ByVersion(ks))
-		log.Printf("DEBUG: %s %s flavor %d kernels\n", arch, kt, len(ks))
-	}
+	plan := newPlan(target, dir, force)
 
-	g, ctx := errgroup.WithContext(ctx)
 	for kt, ks := range pkgsByKernelType {
-		ckt := kt
-		cks := ks
-		g.Go(func() error {
-			log.Printf("DEBUG: start kernel type %s %s (%d pkgs)\n", ckt, arch, len(cks))
-			err := d.processPackages(ctx, dir, cks, force, jobchan)
-			log.Printf("DEBUG: end kernel type %s %s\n", ckt, arch)
-			return err
-		})
+		log.Printf("DEBUG: %s %s flavor %d kernels\n", altArch, kt, len(ks))
+		plan.AddGroup(kt, ks)
 	}
-	return g.Wait()
+
+	return plan, nil
 }
 
 func (d *suseRepo) getRepoAliases(ctx context.Context) error {
-	repos, err := zypperRepos(ctx)
+	repos, err := d.zypperRepos(ctx)
 	if err != nil {
 		return err
 	}
@@ -135,25 +136,6 @@ func (d *suseRepo) getRepoAliases(ctx context.Context) error {
 	return bio.Err()
 }
 
-func (d *suseRepo) processPackages(ctx context.Context, dir string, pkgs []pkg.Package, force bool, jobchan chan<- job.Job) error {
-	for i, p := range pkgs {
-		log.Printf("DEBUG: start pkg %s (%d/%d)\n", p, i+1, len(pkgs))
-		if err := processPackage(ctx, p, dir, force, jobchan); err != nil {
-			if errors.Is(err, utils.ErrHasBTF) {
-				log.Printf("INFO: kernel %s has BTF already, skipping later kernels\n", p)
-				return nil
-			}
-			if errors.Is(err, context.Canceled) {
-				return nil
-			}
-			log.Printf("ERROR: %s: %s\n", p, err)
-			continue
-		}
-		log.Printf("DEBUG: end pkg %s (%d/%d)\n", p, i+1, len(pkgs))
-	}
-	return nil
-}
-
 func (d *suseRepo) parseZypperPackages(rdr io.Reader, arch string) ([]*pkg.SUSEPackage, error) {
 	var pkgs []*pkg.SUSEPackage
 	kre := regexp.MustCompile(`^kernel-([^-]+)-debuginfo$`)
@@ -193,7 +175,8 @@ func (d *suseRepo) parseZypperPackages(rdr io.Reader, arch string) ([]*pkg.SUSEP
 				Architecture:  pkgarch,
 				Repo:          repo,
 				Flavor:        flavor,
-				Downloaddir:   fmt.Sprintf("/var/cache/zypp/packages/%s/%s", alias, arch),
+				Downloaddir:   filepath.Join(ZyppPackagesDir, alias, arch),
+				Runner:        d.runner,
 			}
 			pkgs = append(pkgs, p)
 		}
@@ -204,10 +187,10 @@ func (d *suseRepo) parseZypperPackages(rdr io.Reader, arch string) ([]*pkg.SUSEP
 	return pkgs, nil
 }
 
-func zypperRepos(ctx context.Context) (*bytes.Buffer, error) {
-	return utils.RunZypperCMD(ctx, "repos")
+func (d *suseRepo) zypperRepos(ctx context.Context) (*bytes.Buffer, error) {
+	return utils.RunZypperCMD(ctx, d.runner, "repos")
 }
 
-func zypperSearch(ctx context.Context, pkg string) (*bytes.Buffer, error) {
-	return utils.RunZypperCMD(ctx, "search", "-s", pkg)
+func (d *suseRepo) zypperSearch(ctx context.Context, pkg string) (*bytes.Buffer, error) {
+	return utils.RunZypperCMD(ctx, d.runner, "search", "-s", pkg)
 }
@@ -4,23 +4,29 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"golang.org/x/exp/maps"
 
+	"github.com/aquasecurity/btfhub/pkg/archive"
 	"github.com/aquasecurity/btfhub/pkg/job"
 	"github.com/aquasecurity/btfhub/pkg/kernel"
+	"github.com/aquasecurity/btfhub/pkg/metrics"
+	"github.com/aquasecurity/btfhub/pkg/output"
 	"github.com/aquasecurity/btfhub/pkg/pkg"
+	"github.com/aquasecurity/btfhub/pkg/report"
 	"github.com/aquasecurity/btfhub/pkg/utils"
 )
 
-func parseYumPackages(rdr io.Reader, minVersion kernel.Version) ([]pkg.Package, error) {
+// parseYumPackages parses the kernel debuginfo packages of a yum search, the
+// packages are downloaded with the given runner
+func parseYumPackages(rdr io.Reader, minVersion kernel.Version, runner utils.CommandRunner) ([]pkg.Package, error) {
 	pkgs := map[string]pkg.Package{}
 	bio := bufio.NewScanner(rdr)
 	for bio.Scan() {
@@ -46,6 +52,7 @@ func parseYumPackages(rdr io.Reader, minVersion kernel.Version) ([]pkg.Package,
 			NameOfFile:    filename,
 			KernelVersion: kernel.NewKernelVersion(filename[:lastdot]),
 			Architecture:  filename[lastdot+1:],
+			Runner:        runner,
 		}
 		if !minVersion.IsZero() && p.Version().Less(minVersion) {
 			continue
@@ -60,58 +67,92 @@ func parseYumPackages(rdr io.Reader, minVersion kernel.Version) ([]pkg.Package,
 	return maps.Values(pkgs), nil
 }
 
-func yumSearch(ctx context.Context, pkg string) (*bytes.Buffer, error) {
-	stdout := &bytes.Buffer{}
-	stderr := &bytes.Buffer{}
-	binary, args := utils.SudoCMD("yum", "search", "--showduplicates", pkg)
-	cmd := exec.CommandContext(ctx, binary, args...)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("yum search %s: %s\n%s", pkg, err, stderr.String())
+func yumSearch(ctx context.Context, runner utils.CommandRunner, pkg string) (*bytes.Buffer, error) {
+	stdout, err := runner.Run(ctx, utils.Command{
+		Name: "yum",
+		Args: []string{"search", "--showduplicates", pkg},
+		Root: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("yum search %s: %s", pkg, err)
 	}
 	return stdout, nil
 }
 
 // processPackage creates a kernel extraction job and waits for the reply. It
-// then creates a BTF generation job and sends it to the worker. It returns
+// then creates a BTF generation job and sends it to the worker. Intermediate
+// files go to the plan scratch dir, and only the final BTF file goes to the
+// plan work dir. A regenerated package (see Plan.MarkStale) doesn't reuse the
+// BTF file of the store. It returns utils.ErrHasBTF if the kernel already has
+// a .BTF section (so later kernels can be skipped), and accounts the outcome
+// in the metrics and the report.
 func processPackage(
 	ctx context.Context,
+	plan *Plan,
 	p pkg.Package,
-	workDir string,
-	force bool,
+	regenerate bool,
 	jobChan chan<- job.Job,
-) error {
+) (err error) {
+
+	target := plan.Target
+	workDir := plan.WorkDir
+	scratchDir := plan.scratchDir()
+
+	defer func() {
+		switch {
+		case err == nil:
+		case errors.Is(err, utils.ErrHasBTF):
+			metrics.PackagesSkipped.WithLabelValues(target.Distro, metrics.SkipHasBTF).Inc()
+			report.Default.HasBTF(target, p.BTFFilename())
+		case errors.Is(err, context.Canceled):
+		default:
+			metrics.PackagesFailed.WithLabelValues(target.Distro).Inc()
+			report.Default.Failed(target, p.BTFFilename(), err)
+		}
+	}()
 
-	btfName := fmt.Sprintf("%s.btf", p.BTFFilename())
-	btfPath := filepath.Join(workDir, btfName)
-	btfTarName := fmt.Sprintf("%s.btf.tar.xz", p.BTFFilename())
-	btfTarPath := filepath.Join(workDir, btfTarName)
+	btfName := fmt.Sprintf("%s%s", p.BTFFilename(), archive.PartialBTFExt)
+	btfPath := filepath.Join(scratchDir, btfName)
+	outName := fmt.Sprintf("%s%s", p.BTFFilename(), output.Default.Ext())
+	outPath := filepath.Join(workDir, outName)
 
-	if pkg.PackageHasBTF(p, workDir) {
-		return utils.ErrHasBTF
+	// 1st job: Extract kernel vmlinux file
+
+	var modulesDir string
+	if _, ok := p.(pkg.ModulesExtractor); ok && len(plan.Modules) > 0 {
+		modulesDir = filepath.Join(scratchDir, archive.ModulesScratchPrefix+p.Filename())
 	}
-	if !force && utils.Exists(btfTarPath) {
-		log.Printf("SKIP: %s exists\n", btfTarName)
-		return nil
+
+	kernelExtJob := &job.KernelExtractionJob{
+		Target:     target,
+		Pkg:        p,
+		WorkDir:    scratchDir,
+		ReplyChan:  make(chan interface{}),
+		Force:      plan.Force,
+		Modules:    plan.Modules,
+		ModulesDir: modulesDir,
+		Cache:      plan.Cache,
 	}
 
-	// 1st job: Extract kernel vmlinux file
+	// Let the jobs of the hosts with spare capacity go first: the request slot
+	// of the download is reserved before the job is queued, and released once
+	// the job replied (if the download didn't already)
 
-	kernelExtJob := &job.KernelExtractionJob{
-		Pkg:       p,
-		WorkDir:   workDir,
-		ReplyChan: make(chan interface{}),
-		Force:     force,
+	if up, ok := p.(pkg.URLPackage); ok && up.DownloadURL() != "" {
+		slot, err := utils.DefaultHostLimiter.Reserve(ctx, up.DownloadURL())
+		if err != nil {
+			return err
+		}
+		defer slot.Release()
+		kernelExtJob.Slot = slot
 	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case jobChan <- kernelExtJob: // send vmlinux file extraction job to worker
+	if err := sendJob(ctx, jobChan, kernelExtJob); err != nil {
+		return err
 	}
 
 	reply := <-kernelExtJob.ReplyChan // wait for reply
+	kernelExtJob.Slot.Release()
 
 	var vmlinuxPath string
 
@@ -122,31 +163,67 @@ func processPackage(
 		vmlinuxPath = v // receive vmlinux path from worker
 	}
 
-	// Check if BTF is already present in vmlinux (will skip further packages)
+	// Check that the vmlinux file is the kernel of the package (its release is
+	// the name of the BTF file, "uname -r") and of the target architecture
 
-	hasBTF, err := utils.HasBTFSection(vmlinuxPath)
+	info, err := kernel.Inspect(vmlinuxPath)
 	if err != nil {
-		return fmt.Errorf("BTF check: %s", err)
+		return fmt.Errorf("inspect %s: %s", vmlinuxPath, err)
+	}
+	switch {
+	case info.Release == "":
+		// separated debuginfo file: checked by its path in the package only
+		log.Printf("DEBUG: no release in %s, not checked against %s\n", vmlinuxPath, p)
+	case info.Release != p.BTFFilename():
+		return fmt.Errorf("vmlinux release %s doesn't match package %s", info.Release, p)
 	}
-	if hasBTF {
+	if info.Arch != target.Arch {
+		return fmt.Errorf("vmlinux architecture %q doesn't match %s", info.Arch, target.Arch)
+	}
+
+	// Check if BTF is already present in vmlinux (will skip further packages)
+
+	if info.HasBTF() {
 		pkg.MarkPackageHasBTF(p, workDir)
 		// Removing here is bad for re-runs (it has to re-download)
 		os.Remove(vmlinuxPath)
+		if modulesDir != "" {
+			os.RemoveAll(modulesDir) // modules have .BTF sections as well
+		}
 		return utils.ErrHasBTF
 	}
 
 	// 2nd job: Generate BTF file from vmlinux file
 
-	job := &job.BTFGenerationJob{
+	btfGenJob := &job.BTFGenerationJob{
+		Target:      target,
+		Kernel:      p.BTFFilename(),
 		VmlinuxPath: vmlinuxPath,
 		BTFPath:     btfPath,
-		BTFTarPath:  btfTarPath,
+		OutPath:     outPath,
+		Encoder:     output.Default,
+		Store:       plan.Store,
+		Pahole:      plan.Pahole,
+		Regenerate:  regenerate,
+	}
+	if modulesDir != "" {
+		btfGenJob.ModulesDir = modulesDir
+		btfGenJob.ModulesOutDir = filepath.Join(workDir, p.BTFFilename()+archive.ModulesExt)
 	}
 
+	return sendJob(ctx, jobChan, btfGenJob)
+}
+
+// sendJob sends a job to the workers, accounting it as queued while it waits
+// for a worker to pick it up.
+func sendJob(ctx context.Context, jobChan chan<- job.Job, j job.Job) error {
+	metrics.QueuedJobs.Inc()
+	defer metrics.QueuedJobs.Dec()
+
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case jobChan <- job: // send BTF generation job to worker
+	case jobChan <- j:
 	}
 
 	return nil
@@ -0,0 +1,248 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Target identifies a distribution, release and architecture being updated
+type Target struct {
+	Distro  string `json:"distro"`
+	Release string `json:"release"`
+	Arch    string `json:"arch"`
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("%s/%s/%s", t.Distro, t.Release, t.Arch)
+}
+
+// Failure describes a kernel that could not be processed
+type Failure struct {
+	Kernel string `json:"kernel"`
+	Error  string `json:"error"`
+}
+
+// TargetReport holds the outcome of the kernels processed for a target
+type TargetReport struct {
+	Target
+	Generated []string      `json:"generated"`
+	Skipped   []string      `json:"skipped"`
+	HasBTF    []string      `json:"hasbtf"`
+	Failed    []Failure     `json:"failed"`
+	Bytes     uint64        `json:"bytes"`
+	Time      time.Duration `json:"time"`
+}
+
+// Report is a summary of everything that happened during a run
+type Report struct {
+	mu      sync.Mutex
+	Start   time.Time                `json:"start"`
+	End     time.Time                `json:"end"`
+	Bytes   uint64                   `json:"bytes"`
+	Targets map[string]*TargetReport `json:"targets"`
+}
+
+// Default is the report of the current run
+var Default = New()
+
+func New() *Report {
+	return &Report{
+		Start:   time.Now(),
+		Targets: make(map[string]*TargetReport),
+	}
+}
+
+// target returns the report of the given target, creating it if needed (must
+// be called with the lock held)
+func (r *Report) target(t Target) *TargetReport {
+	tr, ok := r.Targets[t.String()]
+	if !ok {
+		tr = &TargetReport{Target: t}
+		r.Targets[t.String()] = tr
+	}
+	return tr
+}
+
+// Generated records a new BTF file generated for the given kernel
+func (r *Report) Generated(t Target, kernel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tr := r.target(t)
+	tr.Generated = append(tr.Generated, kernel)
+}
+
+// Skipped records a kernel skipped because its BTF file already exists
+func (r *Report) Skipped(t Target, kernel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tr := r.target(t)
+	tr.Skipped = append(tr.Skipped, kernel)
+}
+
+// HasBTF records a kernel that already has a .BTF section
+func (r *Report) HasBTF(t Target, kernel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tr := r.target(t)
+	tr.HasBTF = append(tr.HasBTF, kernel)
+}
+
+// Failed records a kernel that could not be processed
+func (r *Report) Failed(t Target, kernel string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tr := r.target(t)
+	tr.Failed = append(tr.Failed, Failure{Kernel: kernel, Error: err.Error()})
+}
+
+// Downloaded accounts the bytes downloaded for the given target
+func (r *Report) Downloaded(t Target, bytes uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.target(t).Bytes += bytes
+	r.Bytes += bytes
+}
+
+// Elapsed accounts the time spent working on the given target
+func (r *Report) Elapsed(t Target, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.target(t).Time += d
+}
+
+// Finish marks the end of the run
+func (r *Report) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.End = time.Now()
+}
+
+// sortedTargets returns the target reports sorted by name (must be called with
+// the lock held)
+func (r *Report) sortedTargets() []*TargetReport {
+	keys := make([]string, 0, len(r.Targets))
+	for k := range r.Targets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	trs := make([]*TargetReport, 0, len(keys))
+	for _, k := range keys {
+		tr := r.Targets[k]
+		sort.Strings(tr.Generated)
+		sort.Strings(tr.Skipped)
+		sort.Strings(tr.HasBTF)
+		sort.Slice(tr.Failed, func(i, j int) bool {
+			return tr.Failed[i].Kernel < tr.Failed[j].Kernel
+		})
+		trs = append(trs, tr)
+	}
+	return trs
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sortedTargets()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteMarkdown writes a summary of the report in markdown (suitable for a
+// pull request comment)
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "## BTFHub update summary\n\n")
+	fmt.Fprintf(b, "Downloaded %s in %s.\n\n", humanize.Bytes(r.Bytes), r.End.Sub(r.Start).Round(time.Second))
+
+	targets := r.sortedTargets()
+	if len(targets) == 0 {
+		fmt.Fprintf(b, "Nothing to report.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	fmt.Fprintf(b, "| Target | New | Skipped | Has BTF | Failed | Downloaded | Time |\n")
+	fmt.Fprintf(b, "|--------|----:|--------:|--------:|-------:|-----------:|-----:|\n")
+	for _, tr := range targets {
+		fmt.Fprintf(b, "| %s | %d | %d | %d | %d | %s | %s |\n",
+			tr.Target,
+			len(tr.Generated),
+			len(tr.Skipped),
+			len(tr.HasBTF),
+			len(tr.Failed),
+			humanize.Bytes(tr.Bytes),
+			tr.Time.Round(time.Second),
+		)
+	}
+
+	for _, tr := range targets {
+		if len(tr.Generated) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "\n<details><summary>%s: %d new BTFs</summary>\n\n", tr.Target, len(tr.Generated))
+		for _, k := range tr.Generated {
+			fmt.Fprintf(b, "- `%s`\n", k)
+		}
+		fmt.Fprintf(b, "\n</details>\n")
+	}
+
+	for _, tr := range targets {
+		if len(tr.Failed) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "\n### Failures: %s\n\n", tr.Target)
+		for _, f := range tr.Failed {
+			fmt.Fprintf(b, "- `%s`: %s\n", f.Kernel, firstLine(f.Error))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFiles writes the JSON and the markdown reports to the given paths
+// (empty paths are ignored)
+func (r *Report) WriteFiles(jsonPath string, mdPath string) error {
+	write := func(path string, fn func(io.Writer) error) error {
+		if path == "" {
+			return nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("create report: %s", err)
+		}
+		if err := fn(f); err != nil {
+			f.Close()
+			return fmt.Errorf("write report %s: %s", path, err)
+		}
+		return f.Close()
+	}
+
+	if err := write(jsonPath, r.WriteJSON); err != nil {
+		return err
+	}
+	return write(mdPath, r.WriteMarkdown)
+}
+
+// firstLine returns the first line of a (possibly multi-line) error message
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
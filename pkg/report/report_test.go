@@ -0,0 +1,58 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReportMarkdown(t *testing.T) {
+	r := New()
+	focal := Target{Distro: "ubuntu", Release: "focal", Arch: "x86_64"}
+	c7 := Target{Distro: "centos", Release: "7", Arch: "arm64"}
+
+	r.Generated(focal, "5.4.0-100-generic")
+	r.Generated(focal, "5.4.0-1-generic")
+	r.Skipped(focal, "5.4.0-99-generic")
+	r.HasBTF(c7, "4.18.0-300.el8.aarch64")
+	r.Failed(c7, "3.10.0-957.el7.aarch64", errors.New("pahole: exit status 1\nmore details"))
+	r.Downloaded(focal, 1000)
+	r.Finish()
+
+	md := &bytes.Buffer{}
+	if err := r.WriteMarkdown(md); err != nil {
+		t.Fatal(err)
+	}
+	out := md.String()
+
+	for _, want := range []string{
+		"| centos/7/arm64 | 0 | 0 | 1 | 1 |",
+		"| ubuntu/focal/x86_64 | 2 | 1 | 0 | 0 | 1.0 kB |",
+		"- `3.10.0-957.el7.aarch64`: pahole: exit status 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "centos/7/arm64") > strings.Index(out, "ubuntu/focal/x86_64") {
+		t.Errorf("targets are not sorted:\n%s", out)
+	}
+
+	js := &bytes.Buffer{}
+	if err := r.WriteJSON(js); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	generated := decoded.Targets[focal.String()].Generated
+	if len(generated) != 2 || generated[0] != "5.4.0-1-generic" {
+		t.Errorf("unexpected generated kernels: %v", generated)
+	}
+	if decoded.Bytes != 1000 {
+		t.Errorf("unexpected total bytes: %d", decoded.Bytes)
+	}
+}
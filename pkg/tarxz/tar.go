@@ -0,0 +1,79 @@
+// Package tarxz writes reproducible .tar.xz files: the output only depends on
+// the archived file name and contents, never on the host (file owner, mode or
+// modification time, tar or xz versions).
+package tarxz
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TarballBlockSize is the size of the xz blocks of the tarballs: the BTF file
+// of a kernel (a few MiB) is split into blocks compressed in parallel, for
+// about the same size as a single block (the dictionary is shrunk to the block
+// size, so the levels above 0 give the same output)
+const TarballBlockSize = 1 << 20
+
+// WriteTarball writes a .tar.xz holding a single file, with the given name and
+// contents, to w. The tar header only holds the name and size: the mode is
+// 0444, the owner root:root (uid and gid 0), the modification time 0, in USTAR
+// format (PAX for names longer than 100 bytes), as written by archive/tar. The
+// golden files of the tests pin the output. The xz blocks are TarballBlockSize
+// long, unless opts.BlockSize is set.
+func WriteTarball(ctx context.Context, w io.Writer, name string, r io.Reader, size int64, opts Options) error {
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = TarballBlockSize
+	}
+	xw, err := NewXZWriter(w, opts)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(xw)
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0444,
+		Uname:    "root",
+		Gname:    "root",
+		ModTime:  time.Unix(0, 0),
+		Format:   tar.FormatUSTAR,
+	}
+	if len(name) > 100 {
+		hdr.Format = tar.FormatPAX // USTAR can't hold long names
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("tar header: %s", err)
+	}
+
+	n, err := io.Copy(tw, &ctxReader{ctx: ctx, r: r})
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s: expected %d bytes, got %d", name, size, n)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("tar close: %s", err)
+	}
+
+	return xw.Close()
+}
+
+// ctxReader stops reading once the context is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
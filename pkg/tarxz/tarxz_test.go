@@ -0,0 +1,119 @@
+package tarxz
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fastxz "github.com/therootcompany/xz"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// testData returns compressible data, repeated far enough for the dictionary
+// size of level 0 to matter, and longer than a block of the tarballs (the
+// dictionary is shrunk to the block size, so the levels above 0 give the same
+// output: only the default one is kept)
+func testData() []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < 300<<10; i++ {
+		fmt.Fprintf(&b, "struct type_%d { int field_%d; long field_%d; };\n", i, i%97, i%13)
+	}
+	return bytes.Repeat(b.Bytes(), 4)
+}
+
+func writeTestTarball(t *testing.T, data []byte, opts Options) []byte {
+	var out bytes.Buffer
+	err := WriteTarball(context.Background(), &out, "test.btf", bytes.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestTarballGolden(t *testing.T) {
+	data := testData()
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"level-0", Options{Level: 0}},
+		{"level-6", Options{Level: 6}},
+		{"level-6-blocks", Options{Level: 6, BlockSize: 64 << 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := writeTestTarball(t, data, tt.opts)
+
+			// the output must not depend on the number of goroutines
+			parallel := tt.opts
+			parallel.Concurrency = 4
+			if !bytes.Equal(out, writeTestTarball(t, data, parallel)) {
+				t.Fatal("parallel output differs")
+			}
+
+			golden := filepath.Join("testdata", tt.name+".tar.xz")
+			if *update {
+				if err := os.WriteFile(golden, out, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, expected) {
+				t.Fatalf("output differs from %s", golden)
+			}
+
+			// decompress and check the tar header and contents
+			xr, err := fastxz.NewReader(bytes.NewReader(out), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(xr)
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name != "test.btf" || hdr.Mode != 0444 || hdr.Uid != 0 || hdr.Gid != 0 || hdr.ModTime.Unix() != 0 {
+				t.Errorf("unexpected header: %+v", hdr)
+			}
+			contents, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, data) {
+				t.Error("contents differ")
+			}
+			if _, err := tr.Next(); err != io.EOF {
+				t.Errorf("expected a single entry, got %v", err)
+			}
+		})
+	}
+}
+
+// TestLevelDictionary checks the levels use their dictionary size: data
+// repeated after more than the level 1 dictionary (1 MiB) only compresses
+// well with a larger one
+func TestLevelDictionary(t *testing.T) {
+	var b bytes.Buffer
+	for i := 0; b.Len() < 3<<19; i++ {
+		fmt.Fprintf(&b, "%08x\n", uint32(i)*2654435761)
+	}
+	data := bytes.Repeat(b.Bytes(), 2)
+
+	level1 := writeTestTarball(t, data, Options{Level: 1, BlockSize: len(data)})
+	level6 := writeTestTarball(t, data, Options{Level: 6, BlockSize: len(data)})
+	if len(level6) >= len(level1)*3/4 {
+		t.Errorf("level 6: %d bytes, level 1: %d bytes", len(level6), len(level1))
+	}
+}
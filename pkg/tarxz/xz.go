@@ -0,0 +1,313 @@
+package tarxz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// The xz container format is described at https://tukaani.org/xz/xz-file-format.txt.
+// Input is split into blocks, each one compressed independently (LZMA2) by its
+// own goroutine, and the blocks are written in order followed by the index.
+// The output only depends on the input and the options, never on the number
+// of goroutines.
+
+var (
+	headerMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	footerMagic = []byte{'Y', 'Z'}
+)
+
+const (
+	checkCRC64  = 0x04 // check type of the blocks
+	checkSize   = 8
+	filterLZMA2 = 0x21
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// dictCaps are the LZMA2 dictionary sizes of the compression levels, the same
+// as the xz(1) presets. All levels use the hash table match finder: the binary
+// tree one of the lzma package is orders of magnitude slower and compresses
+// BTF data worse.
+var dictCaps = [...]int{
+	256 << 10,
+	1 << 20,
+	2 << 20,
+	4 << 20,
+	4 << 20,
+	8 << 20,
+	8 << 20,
+	16 << 20,
+	32 << 20,
+	64 << 20,
+}
+
+// Options of the xz writer
+type Options struct {
+	// Level is the compression level (0-9). It only picks the dictionary
+	// size, the one of the xz(1) preset of the same level (the other settings
+	// of the presets are not applied).
+	Level int
+	// BlockSize is the size of the uncompressed blocks, compressed in
+	// parallel. It defaults to 3 times the dictionary size of the level (the
+	// default of xz -T).
+	BlockSize int
+	// Concurrency is the maximum number of blocks compressed at the same
+	// time (defaults to 1).
+	Concurrency int
+}
+
+// DefaultLevel is the compression level of xz(1)
+const DefaultLevel = 6
+
+// XZWriter compresses data into a multi block xz stream
+type XZWriter struct {
+	w       io.Writer
+	dictCap int
+	size    int
+	buf     []byte
+	pending []chan block // blocks being compressed, in order
+	max     int          // maximum number of pending blocks
+	records []record     // index records
+	err     error
+	closed  bool
+}
+
+// block is a compressed block ready to be written
+type block struct {
+	data []byte // block header, compressed data, padding and check
+	rec  record
+	err  error
+}
+
+// record is an index record
+type record struct {
+	unpadded     uint64
+	uncompressed uint64
+}
+
+// NewXZWriter returns a writer compressing to w. The stream is completed on
+// Close (which does not close w).
+func NewXZWriter(w io.Writer, opts Options) (*XZWriter, error) {
+	if opts.Level < 0 || opts.Level >= len(dictCaps) {
+		return nil, fmt.Errorf("invalid xz level %d", opts.Level)
+	}
+	dictCap := dictCaps[opts.Level]
+
+	size := opts.BlockSize
+	if size <= 0 {
+		size = 3 * dictCap
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	if err := writeStreamHeader(w); err != nil {
+		return nil, err
+	}
+
+	return &XZWriter{
+		w:       w,
+		dictCap: dictCap,
+		size:    size,
+		max:     concurrency,
+	}, nil
+}
+
+// Write buffers the data, compressing every full block
+func (x *XZWriter) Write(p []byte) (int, error) {
+	if x.closed {
+		return 0, errors.New("xz writer closed")
+	}
+	if x.err != nil {
+		return 0, x.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		if x.buf == nil {
+			x.buf = make([]byte, 0, x.size)
+		}
+		c := min(len(p), x.size-len(x.buf))
+		x.buf = append(x.buf, p[:c]...)
+		p = p[c:]
+		n += c
+		if len(x.buf) == x.size {
+			if err := x.flushBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Close compresses the last block, and writes the index and the stream footer
+func (x *XZWriter) Close() error {
+	if x.closed {
+		return x.err
+	}
+	x.closed = true
+
+	if x.err == nil && len(x.buf) > 0 {
+		x.flushBlock()
+	}
+	for len(x.pending) > 0 && x.err == nil {
+		x.writeBlock()
+	}
+	if x.err != nil {
+		return x.err
+	}
+
+	index := encodeIndex(x.records)
+	if _, err := x.w.Write(index); err != nil {
+		return err
+	}
+
+	return writeStreamFooter(x.w, len(index))
+}
+
+// flushBlock starts compressing the buffered block, writing the oldest
+// compressed block first if too many are in flight
+func (x *XZWriter) flushBlock() error {
+	for len(x.pending) >= x.max && x.err == nil {
+		x.writeBlock()
+	}
+	if x.err != nil {
+		return x.err
+	}
+
+	ch := make(chan block, 1)
+	x.pending = append(x.pending, ch)
+	data := x.buf
+	x.buf = nil
+
+	go func() {
+		ch <- compressBlock(data, x.dictCap)
+	}()
+
+	return nil
+}
+
+// writeBlock waits for the oldest block in flight and writes it
+func (x *XZWriter) writeBlock() {
+	b := <-x.pending[0]
+	x.pending = x.pending[1:]
+	if b.err != nil {
+		x.err = b.err
+		return
+	}
+	if _, err := x.w.Write(b.data); err != nil {
+		x.err = err
+		return
+	}
+	x.records = append(x.records, b.rec)
+}
+
+// compressBlock compresses the data into a complete xz block
+func compressBlock(data []byte, dictCap int) block {
+	if len(data) < dictCap {
+		dictCap = max(len(data), lzma.MinDictCap)
+	}
+
+	var compressed bytes.Buffer
+	lw, err := lzma.Writer2Config{DictCap: dictCap, Matcher: lzma.HashTable4}.NewWriter2(&compressed)
+	if err != nil {
+		return block{err: fmt.Errorf("lzma2 writer: %s", err)}
+	}
+	if _, err := lw.Write(data); err != nil {
+		return block{err: fmt.Errorf("lzma2 write: %s", err)}
+	}
+	if err := lw.Close(); err != nil {
+		return block{err: fmt.Errorf("lzma2 close: %s", err)}
+	}
+
+	header := encodeBlockHeader(uint64(compressed.Len()), uint64(len(data)), dictCap)
+
+	out := make([]byte, 0, len(header)+compressed.Len()+3+checkSize)
+	out = append(out, header...)
+	out = append(out, compressed.Bytes()...)
+	out = append(out, make([]byte, padding(compressed.Len()))...)
+	out = binary.LittleEndian.AppendUint64(out, crc64.Checksum(data, crc64Table))
+
+	return block{
+		data: out,
+		rec: record{
+			unpadded:     uint64(len(header) + compressed.Len() + checkSize),
+			uncompressed: uint64(len(data)),
+		},
+	}
+}
+
+func writeStreamHeader(w io.Writer) error {
+	flags := []byte{0x00, checkCRC64}
+	h := append(append([]byte{}, headerMagic...), flags...)
+	h = binary.LittleEndian.AppendUint32(h, crc32.ChecksumIEEE(flags))
+	_, err := w.Write(h)
+	return err
+}
+
+func writeStreamFooter(w io.Writer, indexSize int) error {
+	f := binary.LittleEndian.AppendUint32(nil, uint32(indexSize/4-1)) // backward size
+	f = append(f, 0x00, checkCRC64)
+	f = append(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(f)), f...)
+	f = append(f, footerMagic...)
+	_, err := w.Write(f)
+	return err
+}
+
+// encodeBlockHeader encodes a block header, with the compressed and
+// uncompressed sizes (so the blocks can be decompressed in parallel too) and a
+// single LZMA2 filter
+func encodeBlockHeader(compressed uint64, uncompressed uint64, dictCap int) []byte {
+	h := []byte{0x00, 0x40 | 0x80} // size (set below), flags: 1 filter and sizes
+	h = appendMultibyte(h, compressed)
+	h = appendMultibyte(h, uncompressed)
+	h = append(h, filterLZMA2, 0x01, dictSizeByte(dictCap))
+	h = append(h, make([]byte, padding(len(h)))...)
+	h[0] = byte((len(h)+4)/4 - 1)
+	return binary.LittleEndian.AppendUint32(h, crc32.ChecksumIEEE(h))
+}
+
+// encodeIndex encodes the index of the stream
+func encodeIndex(records []record) []byte {
+	idx := []byte{0x00}
+	idx = appendMultibyte(idx, uint64(len(records)))
+	for _, r := range records {
+		idx = appendMultibyte(idx, r.unpadded)
+		idx = appendMultibyte(idx, r.uncompressed)
+	}
+	idx = append(idx, make([]byte, padding(len(idx)))...)
+	return binary.LittleEndian.AppendUint32(idx, crc32.ChecksumIEEE(idx))
+}
+
+// dictSizeByte encodes the dictionary size of the LZMA2 filter properties (the
+// smallest encodable size not smaller than dictCap)
+func dictSizeByte(dictCap int) byte {
+	for b := 0; b < 40; b++ {
+		if (2|uint64(b&1))<<(b/2+11) >= uint64(dictCap) {
+			return byte(b)
+		}
+	}
+	return 40
+}
+
+func appendMultibyte(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// padding returns the number of bytes needed to align n to 4 bytes
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
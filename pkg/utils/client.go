@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// HTTPClient is the client of all the fetches: packages, repository indexes
+// and directory listings (see NewHTTPClient)
+var HTTPClient = http.DefaultClient
+
+// HTTPConfig configures the HTTP client of the fetches
+type HTTPConfig struct {
+	Proxy          string        // proxy URL (from the environment, HTTP_PROXY etc., if empty)
+	CAFile         string        // PEM bundle of CAs trusted on top of the system ones
+	CertFile       string        // PEM client certificate (with KeyFile)
+	KeyFile        string        // PEM key of the client certificate
+	NetrcFile      string        // per-host credentials, in the netrc format (basic auth)
+	UserAgent      string        // Go default if empty
+	ConnectTimeout time.Duration // no timeout if 0
+	HeaderTimeout  time.Duration // wait for the response headers (no timeout if 0)
+	Limiter        *HostLimiter  // per-host concurrency and bandwidth (optional)
+}
+
+// NewHTTPClient returns a client configured as given. The proxy, TLS and
+// timeout settings apply to a clone of http.DefaultTransport: if it was
+// replaced (e.g. by tests), it is used as is.
+func NewHTTPClient(cfg HTTPConfig) (*http.Client, error) {
+	var base http.RoundTripper = http.DefaultTransport
+
+	if dt, ok := http.DefaultTransport.(*http.Transport); ok {
+		t := dt.Clone()
+
+		if cfg.Proxy != "" {
+			proxy, err := url.Parse(cfg.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("proxy: %s", err)
+			}
+			t.Proxy = http.ProxyURL(proxy)
+		}
+
+		if cfg.CAFile != "" || cfg.CertFile != "" {
+			tlsConfig, err := newTLSConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+			t.TLSClientConfig = tlsConfig
+		}
+
+		if cfg.ConnectTimeout != 0 {
+			dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+			t.DialContext = dialer.DialContext
+			t.TLSHandshakeTimeout = cfg.ConnectTimeout
+		}
+		t.ResponseHeaderTimeout = cfg.HeaderTimeout
+
+		base = t
+	}
+	if cfg.Limiter != nil {
+		base = cfg.Limiter.Transport(base)
+	}
+
+	var creds map[string]credentials
+	if cfg.NetrcFile != "" {
+		var err error
+		if creds, err = readNetrc(cfg.NetrcFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Client{
+		Transport: &headerTransport{
+			base:      base,
+			userAgent: cfg.UserAgent,
+			creds:     creds,
+		},
+	}, nil
+}
+
+func newTLSConfig(cfg HTTPConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if cfg.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca bundle: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca bundle %s: no certificates", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		if cfg.KeyFile == "" {
+			return nil, errors.New("client certificate without key")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// headerTransport sets the User-Agent, and the credentials of the host of the
+// request (if any, and not already set). The credentials are only sent over
+// TLS, to the hosts they were given for (redirects included).
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	creds     map[string]credentials // by host
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, ok := t.creds[req.URL.Hostname()]
+	hasAuth := ok && req.URL.Scheme == "https" && req.Header.Get("Authorization") == ""
+
+	if t.userAgent != "" || hasAuth {
+		req = req.Clone(req.Context()) // round trippers must not modify requests
+		if t.userAgent != "" {
+			req.Header.Set("User-Agent", t.userAgent)
+		}
+		if hasAuth {
+			req.SetBasicAuth(c.login, c.password)
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// credentials are the login and password of a host
+type credentials struct {
+	login, password string
+}
+
+// readNetrc reads the credentials of the machines of a netrc file (machine,
+// login and password tokens; the default entry, which would send its
+// credentials to every host, macdef and account are ignored)
+func readNetrc(path string) (map[string]credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("netrc: %s", err)
+	}
+	defer f.Close()
+
+	creds := map[string]credentials{}
+
+	var host string
+	var c credentials
+	inEntry := false
+	save := func() {
+		if inEntry {
+			creds[host] = c
+		}
+	}
+
+	scan := bufio.NewScanner(f)
+	scan.Split(bufio.ScanWords)
+	for scan.Scan() {
+		switch scan.Text() {
+		case "machine":
+			save()
+			if !scan.Scan() {
+				return nil, fmt.Errorf("netrc %s: machine without name", path)
+			}
+			host, c, inEntry = scan.Text(), credentials{}, true
+		case "default":
+			save()
+			inEntry = false
+		case "login", "password":
+			token := scan.Text()
+			if !scan.Scan() {
+				return nil, fmt.Errorf("netrc %s: %s without value", path, token)
+			}
+			if token == "login" {
+				c.login = scan.Text()
+			} else {
+				c.password = scan.Text()
+			}
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, fmt.Errorf("netrc %s: %s", path, err)
+	}
+	save()
+
+	return creds, nil
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "builder" || pass != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.UserAgent()))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	ca := filepath.Join(dir, "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(ca, pem.EncodeToMemory(block), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	netrc := filepath.Join(dir, "netrc")
+	data := "machine mirror.example.com login other password other\n" +
+		"machine 127.0.0.1\n  login builder\n  password s3cret\n"
+	if err := os.WriteFile(netrc, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewHTTPClient(HTTPConfig{CAFile: ca, NetrcFile: netrc, UserAgent: "btfhub-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "btfhub-test" {
+		t.Errorf("status %d, user agent %q", resp.StatusCode, body)
+	}
+
+	// without the CA, the server certificate isn't trusted
+
+	client, err = NewHTTPClient(HTTPConfig{NetrcFile: netrc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Error("untrusted certificate: no error")
+	}
+
+	// the credentials aren't sent over plain http, and the default ones are
+	// never sent
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			http.Error(w, "credentials over http", http.StatusBadRequest)
+		}
+	}))
+	defer plain.Close()
+
+	data += "default login anyone password leaked\n"
+	if err := os.WriteFile(netrc, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewHTTPClient(HTTPConfig{NetrcFile: netrc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Get(plain.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("credentials sent over plain http")
+	}
+	creds, err := readNetrc(netrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := creds[""]; ok || len(creds) != 2 {
+		t.Errorf("credentials %v, expected the machines only", creds)
+	}
+}
@@ -1,32 +1,42 @@
 package utils
 
 import (
-	"bufio"
 	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
-	"regexp"
+	"strings"
 
 	fastxz "github.com/therootcompany/xz"
+
+	"github.com/aquasecurity/btfhub/pkg/metrics"
 )
 
 func DownloadFile(ctx context.Context, url string, file string) error {
+	return DownloadFileSize(ctx, url, file, 0)
+}
+
+// DownloadFileSize is DownloadFile with the expected size of the file (e.g.
+// from a directory listing), for the progress if the server doesn't tell it
+func DownloadFileSize(ctx context.Context, url string, file string, size uint64) error {
 	f, err := os.Create(file)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	return Download(ctx, url, f)
+	return download(ctx, url, f, size)
 }
 
 // Download downloads a file from a given URL, and writes it to a given
 // destination, which can be a file or a pipe
 func Download(ctx context.Context, url string, dest io.Writer) error {
+	return download(ctx, url, dest, 0)
+}
+
+func download(ctx context.Context, url string, dest io.Writer, size uint64) error {
 
 	// Request given URL
 
@@ -34,7 +44,7 @@ func Download(ctx context.Context, url string, dest io.Writer) error {
 	if err != nil {
 		return err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -46,31 +56,26 @@ func Download(ctx context.Context, url string, dest io.Writer) error {
 
 	// Create a progress counter reader
 
+	if resp.ContentLength >= 0 {
+		size = uint64(resp.ContentLength)
+	}
 	counter := &ProgressCounter{
 		Ctx:  ctx,
-		Op:   "Download",                 // operation
-		Name: resp.Request.URL.String(),  // file name
-		Size: uint64(resp.ContentLength), // file length
+		Op:   "Download",                // operation
+		Name: resp.Request.URL.String(), // file name
+		Size: size,                      // file length (0 if unknown)
 	}
 	brdr := io.TeeReader(resp.Body, counter) // forward body reader to counter
 
-	// Deal with response (gzip, xz, plain): reader from the counter reader (act the body reader)
+	defer func() {
+		metrics.BytesDownloaded.Add(float64(counter.written))
+	}()
 
-	var rdr io.Reader
+	// Deal with response (gzip, xz, plain): reader from the counter reader (act the body reader)
 
-	switch resp.Header.Get("Content-Type") {
-	case "application/x-gzip":
-		rdr, err = gzip.NewReader(brdr)
-		if err != nil {
-			return fmt.Errorf("gzip body read: %s", err)
-		}
-	case "application/x-xz":
-		rdr, err = fastxz.NewReader(brdr, 0)
-		if err != nil {
-			return fmt.Errorf("xz reader: %s", err)
-		}
-	default:
-		rdr = brdr
+	rdr, err := decompress(brdr, resp.Header.Get("Content-Type"), "")
+	if err != nil {
+		return err
 	}
 
 	_, err = io.Copy(dest, rdr) // copy to destination
@@ -78,60 +83,23 @@ func Download(ctx context.Context, url string, dest io.Writer) error {
 	return err
 }
 
-// GetLinks returns a list of links from a given URL
-func GetLinks(ctx context.Context, repoURL string) ([]string, error) {
-	// Read the repo URL
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, repoURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("http request: %s", err)
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("get links from %s: %s", repoURL, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("url %s returned %d", repoURL, resp.StatusCode)
-	}
-
-	re := regexp.MustCompile(`.*href="([^"]+)"`)
-
-	var links []string
-
-	// Create a progress counter reader
-
-	counter := &ProgressCounter{
-		Ctx:  ctx,
-		Op:   "Download",
-		Name: resp.Request.URL.String(),
-		Size: uint64(resp.ContentLength),
-	}
-
-	scan := bufio.NewScanner(io.TeeReader(resp.Body, counter))
-
-	for scan.Scan() {
-		line := string(scan.Bytes())
-
-		matches := re.FindAllStringSubmatch(line, -1)
-		if matches == nil {
-			continue
+// decompress returns the decompressed content of a gzip or xz compressed
+// reader, by content type or, if it doesn't tell, by file name extension (of a
+// URL), or the reader itself
+func decompress(rdr io.Reader, contentType string, name string) (io.Reader, error) {
+	switch {
+	case contentType == "application/x-gzip", strings.HasSuffix(name, ".gz"):
+		r, err := gzip.NewReader(rdr)
+		if err != nil {
+			return nil, fmt.Errorf("gzip body read: %s", err)
 		}
-
-		// Find all links in the line
-
-		for _, m := range matches {
-			res, err := url.JoinPath(repoURL, m[1])
-			if err != nil {
-				continue
-			}
-			links = append(links, res)
+		return r, nil
+	case contentType == "application/x-xz", strings.HasSuffix(name, ".xz"):
+		r, err := fastxz.NewReader(rdr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("xz reader: %s", err)
 		}
+		return r, nil
 	}
-
-	if err := scan.Err(); err != nil {
-		return nil, fmt.Errorf("error reading response: %s", err)
-	}
-
-	return links, nil
+	return rdr, nil
 }
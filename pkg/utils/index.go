@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/aquasecurity/btfhub/pkg/metrics"
+)
+
+// IndexCache keeps the fetched repository indexes (APT Packages and Release
+// files, HTML directory listings) with their validators, so they are only
+// downloaded again when changed upstream (If-None-Match, If-Modified-Since),
+// and the results of their parsing, so unchanged indexes aren't parsed again:
+//
+//	<sha256 of url>              index, as received (compressed) or put
+//	<sha256 of url>.json         url, validators and checksum of the index
+//	<sha256 of url>.parsed.json  checksum of the parsed index and the result
+type IndexCache struct {
+	Dir string
+}
+
+// DefaultIndexCache is the cache of the indexes parsed with ParseIndex (the
+// indexes are downloaded and parsed every time if nil)
+var DefaultIndexCache *IndexCache
+
+// NewIndexCache returns the index cache kept in the given directory
+func NewIndexCache(dir string) *IndexCache {
+	return &IndexCache{Dir: dir}
+}
+
+// IndexSource is where to fetch an index from. With the expected checksum of
+// the index (from an APT Release file), a cached index with that checksum is
+// used without any request, and a changed one is fetched from ByHashURL (an
+// APT by-hash URL, if any).
+type IndexSource struct {
+	URL       string
+	SHA256    string
+	ByHashURL string
+}
+
+// Index is a cached repository index
+type Index struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	SHA256       string `json:"sha256"` // of the index, as received
+
+	Path string `json:"-"` // cached index file
+}
+
+func (c *IndexCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
+
+// Cached returns the cached index of the given URL (nil if not cached, or if
+// the index file doesn't match the checksum of its metadata)
+func (c *IndexCache) Cached(url string) *Index {
+	path := c.path(url)
+	b, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil
+	}
+	idx := &Index{}
+	if err := json.Unmarshal(b, idx); err != nil || idx.URL != url {
+		return nil
+	}
+	if sum, err := FileSHA256(path); err != nil || sum != idx.SHA256 {
+		if err == nil {
+			log.Printf("DEBUG: cached index of %s doesn't match its checksum\n", url)
+		}
+		return nil
+	}
+	idx.Path = path
+	return idx
+}
+
+// Fetch returns the cached index of the given source, fetching it first if
+// it changed upstream (or was never fetched)
+func (c *IndexCache) Fetch(ctx context.Context, src IndexSource) (*Index, error) {
+	idx := c.Cached(src.URL)
+
+	url := src.URL
+	if src.SHA256 != "" {
+		if idx != nil && idx.SHA256 == src.SHA256 {
+			return idx, nil // unchanged (no request)
+		}
+		if src.ByHashURL != "" {
+			url = src.ByHashURL
+			idx = nil // by-hash files never change: no conditional request
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if idx != nil {
+		if idx.ETag != "" {
+			req.Header.Set("If-None-Match", idx.ETag)
+		}
+		if idx.LastModified != "" {
+			req.Header.Set("If-Modified-Since", idx.LastModified)
+		}
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && idx != nil {
+		log.Printf("DEBUG: %s not modified\n", src.URL)
+		return idx, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status code: %d", url, resp.StatusCode)
+	}
+
+	counter := &ProgressCounter{
+		Ctx:  ctx,
+		Op:   "Download",
+		Name: resp.Request.URL.String(),
+		Size: uint64(resp.ContentLength),
+	}
+	idx = &Index{
+		URL:          src.URL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}
+	err = c.write(idx, io.TeeReader(resp.Body, counter), src.SHA256)
+	metrics.BytesDownloaded.Add(float64(counter.written))
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %s", url, err)
+	}
+
+	return idx, nil
+}
+
+// Put caches the content of the given reader as the index of the given source
+// (an index built locally, like an APT index updated with pdiffs), checking
+// its checksum if the source has one
+func (c *IndexCache) Put(src IndexSource, rdr io.Reader) (*Index, error) {
+	idx := &Index{URL: src.URL}
+	if err := c.write(idx, rdr, src.SHA256); err != nil {
+		return nil, fmt.Errorf("cache %s: %s", src.URL, err)
+	}
+	return idx, nil
+}
+
+// Remove removes the cached index of the given URL, and the result of its
+// parsing
+func (c *IndexCache) Remove(url string) error {
+	path := c.path(url)
+	for _, p := range []string{path + ".json", path, path + ".parsed.json"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes the index to the cache, and then its metadata, with the
+// checksum of the index (which must be the expected one, if not empty),
+// through temporary files of their own (the same index can be fetched
+// concurrently)
+func (c *IndexCache) write(idx *Index, rdr io.Reader, expected string) error {
+	if err := os.MkdirAll(c.Dir, 0775); err != nil {
+		return err
+	}
+
+	path := c.path(idx.URL)
+	f, err := CreateTemp(path)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), rdr)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	idx.SHA256 = hex.EncodeToString(h.Sum(nil))
+	idx.Path = path
+	if expected != "" && idx.SHA256 != expected {
+		return fmt.Errorf("checksum %s, expected %s", idx.SHA256, expected)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path+".json", b)
+}
+
+// Open returns the decompressed content of the cached index
+func (idx *Index) Open() (io.ReadCloser, error) {
+	f, err := os.Open(idx.Path)
+	if err != nil {
+		return nil, err
+	}
+	rdr, err := decompress(f, idx.ContentType, idx.URL)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{rdr, f}, nil
+}
+
+// parsedIndex is the result of the parsing of an index
+type parsedIndex[T any] struct {
+	SHA256 string `json:"sha256"` // of the parsed index
+	Result T      `json:"result"`
+}
+
+// ParseIndex fetches the index (see IndexCache.Fetch) and parses its
+// decompressed content. With DefaultIndexCache, the result is cached, and
+// returned without parsing while the index is unchanged.
+func ParseIndex[T any](ctx context.Context, src IndexSource, parse func(io.Reader) (T, error)) (T, error) {
+	var zero T
+
+	c := DefaultIndexCache
+	if c == nil {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(Download(ctx, src.URL, pw))
+		}()
+		defer pr.Close()
+		return parse(pr)
+	}
+
+	idx, err := c.Fetch(ctx, src)
+	if err != nil {
+		return zero, err
+	}
+
+	// Reuse the result of the parsing of the same index
+
+	parsedPath := idx.Path + ".parsed.json"
+	if b, err := os.ReadFile(parsedPath); err == nil {
+		var parsed parsedIndex[T]
+		if err := json.Unmarshal(b, &parsed); err == nil && parsed.SHA256 == idx.SHA256 {
+			return parsed.Result, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return zero, err
+	}
+
+	// Parse the index, and cache the result under the checksum of what was
+	// actually parsed (the index may be replaced meanwhile by another fetch)
+
+	f, err := os.Open(idx.Path)
+	if err != nil {
+		return zero, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	tee := io.TeeReader(f, h)
+
+	rdr, err := decompress(tee, idx.ContentType, idx.URL)
+	if err != nil {
+		return zero, err
+	}
+	result, err := parse(rdr)
+	if err != nil {
+		return zero, err
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return zero, err
+	}
+
+	b, err := json.Marshal(parsedIndex[T]{SHA256: hex.EncodeToString(h.Sum(nil)), Result: result})
+	if err != nil {
+		return zero, err
+	}
+	if err := WriteFileAtomic(parsedPath, b); err != nil {
+		return zero, err
+	}
+
+	return result, nil
+}
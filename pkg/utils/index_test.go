@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseIndex(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	body := "<a href=\"a.rpm\">a.rpm</a>\n"
+	served := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "index.html", modified, strings.NewReader(body))
+		if w.Header().Get("Content-Length") != "" {
+			served++
+		}
+	}))
+	defer srv.Close()
+
+	DefaultIndexCache = NewIndexCache(t.TempDir())
+	defer func() { DefaultIndexCache = nil }()
+
+	parsed := 0
+	parse := func(rdr io.Reader) ([]string, error) {
+		parsed++
+		l, err := ParseListing(rdr, srv.URL+"/")
+		if err != nil {
+			return nil, err
+		}
+		var links []string
+		for _, link := range l.Links {
+			links = append(links, link.URL)
+		}
+		return links, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		links, err := ParseIndex(context.Background(), IndexSource{URL: srv.URL + "/"}, parse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(links) != 1 || links[0] != srv.URL+"/a.rpm" {
+			t.Fatalf("links: %v", links)
+		}
+	}
+	if served != 1 || parsed != 1 {
+		t.Errorf("served %d times, parsed %d times", served, parsed)
+	}
+
+	// changed upstream
+
+	body = "<a href=\"b.rpm\">b.rpm</a>\n"
+	modified = modified.Add(time.Hour)
+	links, err := ParseIndex(context.Background(), IndexSource{URL: srv.URL + "/"}, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0] != srv.URL+"/b.rpm" || parsed != 2 {
+		t.Errorf("links %v, parsed %d times", links, parsed)
+	}
+}
+
+func TestIndexCacheCorrupted(t *testing.T) {
+	body := "Package: a\n"
+	served := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(body))
+	src := IndexSource{URL: srv.URL + "/Packages", SHA256: hex.EncodeToString(sum[:])}
+	c := NewIndexCache(t.TempDir())
+
+	idx, err := c.Fetch(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch(context.Background(), src); err != nil || served != 1 {
+		t.Fatalf("served %d times: %v", served, err)
+	}
+
+	// the cached index no longer matches its metadata: fetched again
+
+	if err := os.WriteFile(idx.Path, []byte("Package: b\n"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = c.Fetch(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(idx.Path); string(b) != body || served != 2 {
+		t.Errorf("served %d times, cached %q", served, b)
+	}
+}
+
+func TestIndexCacheConcurrent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("Package: a\n", 10000))
+	}))
+	defer srv.Close()
+
+	c := NewIndexCache(t.TempDir())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Fetch(context.Background(), IndexSource{URL: srv.URL + "/Packages"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if c.Cached(srv.URL+"/Packages") == nil {
+		t.Error("index not cached")
+	}
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// HostLimit caps the concurrent requests to a host, and their bandwidth
+type HostLimit struct {
+	Concurrency int   // no cap if 0
+	Bandwidth   int64 // bytes per second, shared by the requests (no limit if 0)
+}
+
+// ParseHostLimits parses comma separated host limits, like
+// "ddebs.ubuntu.com=2/50MB,archives.fedoraproject.org=4,*=8": the maximum
+// number of concurrent requests to the host (0 for no cap), and optionally
+// its bandwidth per second. A host can be narrowed to a repository, by a path
+// prefix ("archives.fedoraproject.org/pub/archive/fedora=2"). The "*" host is
+// the limit of each of the other hosts.
+func ParseHostLimits(s string) (map[string]HostLimit, error) {
+	limits := map[string]HostLimit{}
+	if s == "" {
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		host, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || host == "" {
+			return nil, fmt.Errorf("host limit %q: expected host=concurrency[/bandwidth]", entry)
+		}
+		conc, bw, _ := strings.Cut(value, "/")
+
+		var limit HostLimit
+		if conc != "" {
+			n, err := strconv.Atoi(conc)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("host limit %q: bad concurrency", entry)
+			}
+			limit.Concurrency = n
+		}
+		if bw != "" {
+			n, err := humanize.ParseBytes(bw)
+			if err != nil {
+				return nil, fmt.Errorf("host limit %q: bad bandwidth: %s", entry, err)
+			}
+			limit.Bandwidth = int64(n)
+		}
+		limits[host] = limit
+	}
+
+	return limits, nil
+}
+
+// HostLimiter applies the limits of the hosts (and repositories) to the
+// requests of a client (see HTTPConfig). The request slots are held until the
+// response bodies are closed, so they cap the concurrent downloads.
+type HostLimiter struct {
+	limits map[string]HostLimit // by host or repository, "*" for the others
+
+	mu    sync.Mutex
+	hosts map[string]*hostState // by host or repository
+}
+
+// DefaultHostLimiter is the limiter of HTTPClient, the request slots of the
+// downloads being reserved before they are queued (no limits if nil)
+var DefaultHostLimiter *HostLimiter
+
+type hostState struct {
+	slots  chan struct{} // nil without concurrency cap
+	bucket *tokenBucket  // nil without bandwidth limit
+}
+
+// NewHostLimiter returns a limiter of the given limits (see ParseHostLimits)
+func NewHostLimiter(limits map[string]HostLimit) *HostLimiter {
+	return &HostLimiter{limits: limits, hosts: map[string]*hostState{}}
+}
+
+// state returns the state of the limit of the given URL: the one of the
+// longest repository it is in, or else of its host
+func (l *HostLimiter) state(u *url.URL) *hostState {
+	key := u.Hostname()
+	limit, ok := l.limits[key]
+	if !ok {
+		limit = l.limits["*"]
+	}
+	p := u.Hostname() + u.Path
+	for k, repoLimit := range l.limits {
+		repo := strings.TrimSuffix(k, "/")
+		if strings.Contains(repo, "/") && len(repo) > len(key) && (p == repo || strings.HasPrefix(p, repo+"/")) {
+			key, limit = repo, repoLimit
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if st, ok := l.hosts[key]; ok {
+		return st
+	}
+	st := &hostState{}
+	if limit.Concurrency > 0 {
+		st.slots = make(chan struct{}, limit.Concurrency)
+	}
+	if limit.Bandwidth > 0 {
+		st.bucket = newTokenBucket(limit.Bandwidth)
+	}
+	l.hosts[key] = st
+
+	return st
+}
+
+// HostSlot is a request slot reserved ahead of the request (see
+// HostLimiter.Reserve)
+type HostSlot struct {
+	st   *hostState
+	used atomic.Bool // by a request
+	once sync.Once
+}
+
+// Reserve waits for a free request slot of the host (or repository) of the
+// given URL, and takes it. The downloads of the jobs reserve their slot before
+// the jobs are queued, so the queued jobs never wait for their host, and the
+// producers of the hosts with spare capacity go first. The first request to
+// the host of a context holding the slot (see WithHostSlot) takes it over. The
+// slot is nil if the host has no concurrency cap.
+func (l *HostLimiter) Reserve(ctx context.Context, rawURL string) (*HostSlot, error) {
+	if l == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	st := l.state(u)
+	if st.slots == nil {
+		return nil, nil
+	}
+	select {
+	case st.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return &HostSlot{st: st}, nil
+}
+
+// Release releases the slot, if the request taking it over didn't already
+func (s *HostSlot) Release() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() { <-s.st.slots })
+}
+
+type hostSlotKey struct{}
+
+// WithHostSlot returns a context holding the given reserved slot (see
+// HostLimiter.Reserve)
+func WithHostSlot(ctx context.Context, s *HostSlot) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, hostSlotKey{}, s)
+}
+
+// Transport returns a round tripper applying the limits to the requests of
+// the given one
+func (l *HostLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	return &limitTransport{base: base, limiter: l}
+}
+
+type limitTransport struct {
+	base    http.RoundTripper
+	limiter *HostLimiter
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	st := t.limiter.state(req.URL)
+
+	release := func() {}
+	if st.slots != nil {
+		if s, _ := ctx.Value(hostSlotKey{}).(*HostSlot); s != nil && s.st == st && s.used.CompareAndSwap(false, true) {
+			release = s.Release // reserved
+		} else {
+			select {
+			case st.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			release = func() { <-st.slots }
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, ctx: ctx, bucket: st.bucket, release: release}
+
+	return resp, nil
+}
+
+// limitedBody limits the bandwidth of a response body, and releases the
+// request slot when closed
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	bucket  *tokenBucket
+	release func()
+	once    sync.Once
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.bucket != nil && n > 0 {
+		if werr := b.bucket.wait(b.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
+
+// tokenBucket is a token bucket of bytes, refilled at rate bytes per second,
+// holding up to a second of them. Waiters take their tokens upfront (the
+// bucket may go negative), and wait for the debt to be refilled.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHostLimits(t *testing.T) {
+	limits, err := ParseHostLimits("ddebs.ubuntu.com=2/50MB,archives.fedoraproject.org=4,archives.fedoraproject.org/pub/archive=1,*=/1MB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]HostLimit{
+		"ddebs.ubuntu.com":                       {Concurrency: 2, Bandwidth: 50_000_000},
+		"archives.fedoraproject.org":             {Concurrency: 4},
+		"archives.fedoraproject.org/pub/archive": {Concurrency: 1},
+		"*":                                      {Bandwidth: 1_000_000},
+	}
+	if !reflect.DeepEqual(limits, want) {
+		t.Errorf("got %v, want %v", limits, want)
+	}
+
+	for _, bad := range []string{"ddebs.ubuntu.com", "=2", "host=x", "host=2/fast"} {
+		if _, err := ParseHostLimits(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 150_000)
+	var active, maxActive atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	limiter := NewHostLimiter(map[string]HostLimit{"127.0.0.1": {Concurrency: 2}})
+	client := &http.Client{Transport: limiter.Transport(http.DefaultTransport)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if maxActive.Load() > 2 {
+		t.Errorf("%d concurrent requests, capped to 2", maxActive.Load())
+	}
+	u, _ := url.Parse(srv.URL)
+	if n := len(limiter.state(u).slots); n != 0 {
+		t.Errorf("%d request slots not released", n)
+	}
+
+	// 150KB at 100KB/s: the first 100KB right away, then 0.5s
+
+	limiter = NewHostLimiter(map[string]HostLimit{"*": {Bandwidth: 100_000}})
+	client = &http.Client{Transport: limiter.Transport(http.DefaultTransport)}
+
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if n != int64(len(body)) {
+		t.Fatalf("read %d bytes", n)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("downloaded in %s, limited to 100KB/s", elapsed)
+	}
+}
+
+func TestHostLimiterRepositories(t *testing.T) {
+	limiter := NewHostLimiter(map[string]HostLimit{
+		"archives.fedoraproject.org":              {Concurrency: 4},
+		"archives.fedoraproject.org/pub/archive":  {Concurrency: 1},
+		"archives.fedoraproject.org/pub/archive/": {Concurrency: 2}, // same repository
+		"*": {Concurrency: 8},
+	})
+	state := func(rawURL string) *hostState {
+		u, _ := url.Parse(rawURL)
+		return limiter.state(u)
+	}
+
+	repo := state("https://archives.fedoraproject.org/pub/archive/fedora/linux/")
+	if repo != state("https://archives.fedoraproject.org/pub/archive") {
+		t.Error("same repository, different limits")
+	}
+	if host := state("https://archives.fedoraproject.org/pub/archived/"); host == repo || cap(host.slots) != 4 {
+		t.Error("host limit not applied out of the repository")
+	}
+	if a, b := state("https://a.example.com/"), state("https://b.example.com/"); a == b || cap(a.slots) != 8 {
+		t.Error("other hosts don't have a limit each")
+	}
+}
+
+func TestHostLimiterReserve(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	limiter := NewHostLimiter(map[string]HostLimit{"127.0.0.1": {Concurrency: 1}})
+	client := &http.Client{Transport: limiter.Transport(http.DefaultTransport)}
+	get := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	ctx := context.Background()
+	slot, err := limiter.Reserve(ctx, srv.URL+"/package.deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the only slot is reserved: other requests wait for it
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := get(timeout); err == nil {
+		t.Fatal("request without the reserved slot didn't wait")
+	}
+
+	// the request holding it takes it over, and releases it
+
+	if err := get(WithHostSlot(ctx, slot)); err != nil {
+		t.Fatal(err)
+	}
+	slot.Release() // no-op
+	if err := get(ctx); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(srv.URL)
+	if n := len(limiter.state(u).slots); n != 0 {
+		t.Errorf("%d request slots not released", n)
+	}
+}
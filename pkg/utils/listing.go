@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxListingPages caps the pages of a paginated directory listing
+const maxListingPages = 100
+
+// Link is an entry of an HTML directory listing, with the size and the
+// modification time shown next to it (if any)
+type Link struct {
+	URL     string    `json:"url"`
+	Size    uint64    `json:"size,omitempty"` // approximate if shown with a unit (12M)
+	ModTime time.Time `json:"mtime,omitempty"`
+}
+
+// Listing is a page of an HTML directory listing
+type Listing struct {
+	Links []Link `json:"links"`
+	Next  string `json:"next,omitempty"` // next page (paginated listings)
+}
+
+// GetLinks returns a list of links from a given URL (see GetListing)
+func GetLinks(ctx context.Context, repoURL string) ([]string, error) {
+	links, err := GetListing(ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, l := range links {
+		urls = append(urls, l.URL)
+	}
+	return urls, nil
+}
+
+// GetListing returns the entries of the HTML directory listing at the given
+// URL, following its pages (see ParseIndex)
+func GetListing(ctx context.Context, dirURL string) ([]Link, error) {
+	var links []Link
+
+	seen := map[string]bool{}
+	page := dirURL
+	for i := 0; page != "" && !seen[page]; i++ {
+		if i == maxListingPages {
+			return nil, fmt.Errorf("listing %s: more than %d pages", dirURL, maxListingPages)
+		}
+		seen[page] = true
+
+		pageURL := page
+		l, err := ParseIndex(ctx, IndexSource{URL: pageURL}, func(rdr io.Reader) (*Listing, error) {
+			return ParseListing(rdr, pageURL)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("get links from %s: %s", pageURL, err)
+		}
+		links = append(links, l.Links...)
+		page = l.Next
+	}
+
+	return links, nil
+}
+
+// ParseListing parses an HTML directory listing (Apache and nginx autoindex,
+// in pre or table formats, and similar ones): its links, resolved against the
+// page URL, with the size and the modification time shown after them on the
+// same line (or table row), and the link to its next page (rel="next", or a
+// "next" link text). Sorting links (?C=N;O=D) and fragments are skipped, but a
+// query-only link can still be the next page (?page=2).
+func ParseListing(rdr io.Reader, pageURL string) (*Listing, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &Listing{}
+
+	var cur *Link // entry whose trailing text is being collected
+	var trailing strings.Builder
+	var anchor *html.Token // open <a> tag
+	var anchorText strings.Builder
+	inRow := false
+
+	flush := func() {
+		if cur != nil {
+			cur.Size, cur.ModTime = parseListingDetails(trailing.String())
+			listing.Links = append(listing.Links, *cur)
+		}
+		cur = nil
+		trailing.Reset()
+	}
+	resolve := func(href string) (string, bool) {
+		if href == "" || strings.HasPrefix(href, "#") {
+			return "", false
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			return "", false
+		}
+		return base.ResolveReference(ref).String(), true
+	}
+
+	z := html.NewTokenizer(rdr)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("error reading response: %s", err)
+			}
+			break
+		}
+		tok := z.Token() // names in lower case, text and values unescaped
+
+		switch tt {
+		case html.TextToken:
+			if anchor != nil {
+				anchorText.WriteString(tok.Data)
+			}
+			if cur != nil && anchor == nil {
+				if !inRow {
+					// pre format: the details end with the line
+					if line, _, found := strings.Cut(tok.Data, "\n"); found {
+						trailing.WriteString(line)
+						flush()
+						continue
+					}
+				}
+				trailing.WriteString(tok.Data)
+			}
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			closing := tt == html.EndTagToken
+			switch {
+			case tok.Data == "a" && tt == html.StartTagToken:
+				flush()
+				anchor = &tok
+				anchorText.Reset()
+			case tok.Data == "a" && closing && anchor != nil:
+				href, ok := resolve(attr(anchor, "href"))
+				text := strings.ToLower(strings.TrimSpace(anchorText.String()))
+				switch {
+				case !ok:
+				case hasWord(attr(anchor, "rel"), "next") || strings.HasPrefix(text, "next"):
+					listing.Next = href
+				case strings.HasPrefix(attr(anchor, "href"), "?"): // sorting links
+				default:
+					cur = &Link{URL: href}
+				}
+				anchor = nil
+			case tok.Data == "tr":
+				flush()
+				inRow = !closing
+			case tok.Data == "br" || tok.Data == "li" || tok.Data == "p":
+				flush()
+			}
+		}
+	}
+	if anchor != nil && !strings.HasPrefix(attr(anchor, "href"), "?") {
+		if href, ok := resolve(attr(anchor, "href")); ok {
+			cur = &Link{URL: href}
+		}
+	}
+	flush()
+
+	return listing, nil
+}
+
+// attr returns the value of the given attribute of the tag (empty if not set)
+func attr(t *html.Token, name string) string {
+	for _, a := range t.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasWord(s string, word string) bool {
+	for _, w := range strings.Fields(strings.ToLower(s)) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// listingTimeLayouts are the modification time formats of the listings
+var listingTimeLayouts = []string{
+	"2006-01-02 15:04:05", // Apache
+	"2006-01-02 15:04",
+	"02-Jan-2006 15:04:05", // nginx
+	"02-Jan-2006 15:04",
+	"2006-Jan-02 15:04:05", // lighttpd
+	"2006-Jan-02 15:04",
+}
+
+// parseListingDetails parses the modification time and the size shown after
+// a link in a listing (like "2020-01-02 10:00  12M" or
+// "02-Jan-2020 10:00    12582912")
+func parseListingDetails(s string) (uint64, time.Time) {
+	fields := strings.Fields(s)
+
+	var mtime time.Time
+	sizeFrom := 0
+	for i := 0; i+1 < len(fields) && mtime.IsZero(); i++ {
+		for _, layout := range listingTimeLayouts {
+			if t, err := time.Parse(layout, fields[i]+" "+fields[i+1]); err == nil {
+				mtime, sizeFrom = t, i+2
+				break
+			}
+		}
+	}
+
+	for _, f := range fields[sizeFrom:] {
+		if size, ok := parseListingSize(f); ok {
+			return size, mtime
+		}
+	}
+	return 0, mtime
+}
+
+// parseListingSize parses a size of a listing: bytes, or a number with a
+// binary unit (12K, 1.5M, 3G, 12KiB)
+func parseListingSize(s string) (uint64, bool) {
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "iB"), "B")
+	mult := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K', 'k':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return uint64(n * mult), true
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListing(t *testing.T) {
+	const base = "https://mirror.example.com/debuginfo/"
+	rpm := func(name string) string { return base + name }
+	date := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", s)
+		return t
+	}
+
+	tests := []struct {
+		name string
+		page string
+		want []Link
+		next string
+	}{
+		{
+			name: "apache pre",
+			page: `<html><body><h1>Index of /debuginfo</h1><pre><img src="/icons/blank.gif" alt="Icon "> <a href="?C=N;O=D">Name</a>
+<img src="/icons/back.gif" alt="[PARENTDIR]"> <a href="/">Parent Directory</a>                             -
+<img src="/icons/unknown.gif" alt="[   ]"> <a href="kernel-debuginfo-4.18.0-80.el8.x86_64.rpm">kernel-debuginfo-4.18.0-80.el8.x86_64.rpm</a> 2019-05-07 11:24  488M
+<img src="/icons/unknown.gif" alt="[   ]"> <a href="kernel-debuginfo-4.18.0-147.el8.x86_64.rpm">kernel-debuginfo-4.18.0-147.el8.x86_64.rpm</a> 2019-11-05 20:11  1.5G
+</pre></body></html>`,
+			want: []Link{
+				{URL: "https://mirror.example.com/"},
+				{URL: rpm("kernel-debuginfo-4.18.0-80.el8.x86_64.rpm"), Size: 488 << 20, ModTime: date("2019-05-07 11:24")},
+				{URL: rpm("kernel-debuginfo-4.18.0-147.el8.x86_64.rpm"), Size: 1.5 * (1 << 30), ModTime: date("2019-11-05 20:11")},
+			},
+		},
+		{
+			name: "apache table",
+			page: `<table>
+<tr><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th><th><a href="?C=S;O=A">Size</a></th></tr>
+<tr><td valign="top"><img src="/icons/unknown.gif" alt="[   ]"></td><td><a href="kernel-debuginfo-4.18.0-80.el8.x86_64.rpm">kernel-debuginfo-4.18.0-80.el8.x86_64.rpm</a></td>
+<td align="right">2019-05-07 11:24  </td>
+<td align="right">488M</td><td>&nbsp;</td></tr>
+</table>`,
+			want: []Link{
+				{URL: rpm("kernel-debuginfo-4.18.0-80.el8.x86_64.rpm"), Size: 488 << 20, ModTime: date("2019-05-07 11:24")},
+			},
+		},
+		{
+			name: "nginx",
+			page: `<html><head><title>Index of /debuginfo/</title></head><body><h1>Index of /debuginfo/</h1><hr><pre><a href="../">../</a>
+<a href='kernel-uek-debuginfo-5.4.17-2011.el8uek.x86_64.rpm'>kernel-uek-debuginfo-5.4.17-2011.el8uek.x86_64.rpm</a>   07-May-2019 11:24           511705088
+<a href=kernel-debuginfo-a%2Bb.rpm>kernel-debuginfo-a+b.rpm</a>   07-May-2019 11:25           1024
+</pre><hr></body></html>`,
+			want: []Link{
+				{URL: "https://mirror.example.com/"},
+				{URL: rpm("kernel-uek-debuginfo-5.4.17-2011.el8uek.x86_64.rpm"), Size: 511705088, ModTime: date("2019-05-07 11:24")},
+				{URL: rpm("kernel-debuginfo-a%2Bb.rpm"), Size: 1024, ModTime: date("2019-05-07 11:25")},
+			},
+		},
+		{
+			name: "several links per line and pagination",
+			page: `<ul><li><a href="a.rpm">a</a></li><li><a class="x" href="b.rpm?x=1&amp;y=2" >b</a></li></ul>` +
+				`<a href="?page=2" rel="next">older</a> <a href="#top">top</a>`,
+			want: []Link{
+				{URL: rpm("a.rpm")},
+				{URL: rpm("b.rpm?x=1&y=2")},
+			},
+			next: base + "?page=2",
+		},
+		{
+			name: "next link text",
+			page: `<a href="/debuginfo/page/2/">Next &raquo;</a>`,
+			next: "https://mirror.example.com/debuginfo/page/2/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ParseListing(strings.NewReader(tt.page), base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(l.Links, tt.want) {
+				t.Errorf("links:\n got %v\nwant %v", l.Links, tt.want)
+			}
+			if l.Next != tt.next {
+				t.Errorf("next %q, want %q", l.Next, tt.next)
+			}
+		})
+	}
+}
+
+func TestGetListing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Write([]byte(`<a href="a.rpm">a.rpm</a> <a href="/dir/?page=2" rel="next">next</a>`))
+		case "2":
+			w.Write([]byte(`<a href="b.rpm">b.rpm</a> <a href="/dir/?page=2" rel="next">next</a>`)) // loop
+		}
+	}))
+	defer srv.Close()
+
+	links, err := GetLinks(context.Background(), srv.URL+"/dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{srv.URL + "/dir/a.rpm", srv.URL + "/dir/b.rpm"}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("got %v, want %v", links, want)
+	}
+}
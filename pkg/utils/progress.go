@@ -46,6 +46,12 @@ func (wc *ProgressCounter) printProgress() {
 
 	// Return again and print current status of download
 
+	if wc.Size == 0 {
+		fmt.Printf("%sing %s: %s\n", wc.Op, wc.Name, humanize.Bytes(wc.written))
+		wc.lastReport = time.Now()
+		return
+	}
+
 	pct := uint64((float64(wc.written) / float64(wc.Size)) * 100)
 
 	fmt.Printf("%sing %s: %s / %s - %d%% complete\n",
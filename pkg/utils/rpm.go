@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/DataDog/zstd"
@@ -16,7 +17,47 @@ import (
 	fastxz "github.com/therootcompany/xz"
 )
 
-func ExtractVmlinuxFromRPM(ctx context.Context, rpmPath string, vmlinuxPath string) error {
+// ExtractVmlinuxFromRPM extracts the vmlinux file of a rpm package, and checks
+// that it is of the given release (see CheckVmlinuxRelease)
+func ExtractVmlinuxFromRPM(ctx context.Context, rpmPath string, vmlinuxPath string, release string) error {
+	var found string
+
+	err := ExtractFromRPM(ctx, rpmPath, func(name string) (string, bool) {
+		if strings.Contains(name, "vmlinux") {
+			found = name
+			return vmlinuxPath, true
+		}
+		return "", false
+	})
+	if err != nil {
+		return err
+	}
+	if found == "" {
+		return errors.New("vmlinux file not found in rpm")
+	}
+	return CheckVmlinuxRelease(found, release)
+}
+
+// modulesVmlinuxRe matches the path of a vmlinux file in the directory of the
+// modules of its release (debuginfo packages)
+var modulesVmlinuxRe = regexp.MustCompile(`(?:^|/)lib/modules/([^/]+)/vmlinux$`)
+
+// CheckVmlinuxRelease checks that the vmlinux file at the given path of a
+// package, if under /lib/modules/<release>/, is of the given release. The
+// separated debuginfo files have no banner to tell their release (see
+// kernel.Inspect), so their path is the only check.
+func CheckVmlinuxRelease(name string, release string) error {
+	match := modulesVmlinuxRe.FindStringSubmatch(name)
+	if match == nil || release == "" || match[1] == release {
+		return nil
+	}
+	return fmt.Errorf("vmlinux file %s isn't of release %s", name, release)
+}
+
+// ExtractFromRPM extracts the regular files of a rpm package for which pick
+// returns a path (where the file is extracted to). It stops reading the
+// package once pick returns true (last file to extract).
+func ExtractFromRPM(ctx context.Context, rpmPath string, pick func(name string) (string, bool)) error {
 	file, err := os.Open(rpmPath)
 	if err != nil {
 		return err
@@ -80,33 +121,38 @@ func ExtractVmlinuxFromRPM(ctx context.Context, rpmPath string, vmlinuxPath stri
 			continue
 		}
 
-		// Extract vmlinux file
+		outPath, last := pick(cpioHeader.Name)
+		if outPath == "" {
+			continue
+		}
 
-		if strings.Contains(cpioHeader.Name, "vmlinux") {
-			outFile, err := os.Create(vmlinuxPath)
-			if err != nil {
-				return err
-			}
+		// Extract picked file
 
-			counter := &ProgressCounter{
-				Ctx:  ctx,
-				Op:   "Extract",
-				Name: cpioHeader.Name,
-				Size: uint64(cpioHeader.Size),
-			}
+		outFile, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
 
-			_, err = io.Copy(outFile, io.TeeReader(cpioReader, counter))
+		counter := &ProgressCounter{
+			Ctx:  ctx,
+			Op:   "Extract",
+			Name: cpioHeader.Name,
+			Size: uint64(cpioHeader.Size),
+		}
 
-			if err != nil {
-				outFile.Close()
-				os.Remove(vmlinuxPath)
-				return fmt.Errorf("cpio file copy: %s", err)
-			}
+		_, err = io.Copy(outFile, io.TeeReader(cpioReader, counter))
 
+		if err != nil {
 			outFile.Close()
+			os.Remove(outPath)
+			return fmt.Errorf("cpio file copy: %s", err)
+		}
+
+		outFile.Close()
 
+		if last {
 			return nil
 		}
 	}
-	return errors.New("vmlinux file not found in rpm")
+	return nil
 }
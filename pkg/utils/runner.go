@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Command is a command line to run
+type Command struct {
+	Name string // binary
+	Args []string
+	Dir  string // working directory (the current one if empty)
+	Root bool   // needs root privileges (see SudoRunner)
+	// Changes tells the command changes the host (its configuration, or
+	// files), unlike the queries, so it isn't run by dry-runs (see
+	// DryRunRunner)
+	Changes bool
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// CommandRunner runs commands and returns their standard output. The error of
+// a failing command holds its output.
+type CommandRunner interface {
+	Run(ctx context.Context, cmd Command) (*bytes.Buffer, error)
+}
+
+// LocalRunner runs commands on the host
+type LocalRunner struct{}
+
+func (LocalRunner) Run(ctx context.Context, c Command) (*bytes.Buffer, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout, fmt.Errorf("%s: %s\n%s\n%s", c, err, stdout.String(), stderr.String())
+	}
+
+	return stdout, nil
+}
+
+// SudoRunner runs the commands needing root privileges with sudo (if it is
+// installed), and all of them with the given runner
+type SudoRunner struct {
+	Runner CommandRunner
+}
+
+func (r SudoRunner) Run(ctx context.Context, c Command) (*bytes.Buffer, error) {
+	if c.Root {
+		if _, err := exec.LookPath("sudo"); err == nil {
+			c = Command{
+				Name:    "sudo",
+				Args:    append([]string{c.Name}, c.Args...),
+				Dir:     c.Dir,
+				Changes: c.Changes,
+			}
+		}
+	}
+	return r.Runner.Run(ctx, c)
+}
+
+// DryRunRunner runs the queries with the given runner, and records the
+// commands changing the host instead of running them (their output is empty).
+// Without a runner, all the commands are recorded.
+type DryRunRunner struct {
+	Runner   CommandRunner
+	mtx      sync.Mutex
+	commands []Command
+}
+
+func (r *DryRunRunner) Run(ctx context.Context, c Command) (*bytes.Buffer, error) {
+	if !c.Changes && r.Runner != nil {
+		return r.Runner.Run(ctx, c)
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.commands = append(r.commands, c)
+	return &bytes.Buffer{}, nil
+}
+
+// Commands returns the recorded commands, in order
+func (r *DryRunRunner) Commands() []Command {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]Command(nil), r.commands...)
+}
+
+// ContainerRunner runs the commands in a container of the given image (pinned
+// by digest), with CPU and memory limits. The volumes are mounted at the same
+// path in the container, so the paths in the arguments are valid in it.
+type ContainerRunner struct {
+	Runtime string   // podman (default) or docker
+	Image   string   // e.g. quay.io/btfhub/pahole@sha256:...
+	CPUs    string   // --cpus (no limit if empty)
+	Memory  string   // --memory (no limit if empty)
+	Volumes []string // host directories
+	Runner  CommandRunner
+}
+
+// NewContainerRunner returns a container runner using the given runner to run
+// the container runtime. The image must be pinned by digest.
+func NewContainerRunner(runner CommandRunner, runtime string, image string) (*ContainerRunner, error) {
+	if _, digest, found := strings.Cut(image, "@"); !found || !strings.HasPrefix(digest, "sha256:") {
+		return nil, fmt.Errorf("container image %s isn't pinned by digest (image@sha256:...)", image)
+	}
+	if runtime == "" {
+		runtime = "podman"
+	}
+	return &ContainerRunner{
+		Runtime: runtime,
+		Image:   image,
+		Runner:  runner,
+	}, nil
+}
+
+// Command returns the container runtime command running the given command
+func (r *ContainerRunner) Command(c Command) Command {
+	args := []string{"run", "--rm", "--network=none"}
+	if r.CPUs != "" {
+		args = append(args, "--cpus="+r.CPUs)
+	}
+	if r.Memory != "" {
+		args = append(args, "--memory="+r.Memory)
+	}
+	for _, v := range r.Volumes {
+		args = append(args, "--volume="+v+":"+v)
+	}
+	if c.Dir != "" {
+		args = append(args, "--workdir="+c.Dir)
+	}
+	args = append(args, r.Image, c.Name)
+	args = append(args, c.Args...)
+
+	return Command{Name: r.Runtime, Args: args, Root: c.Root, Changes: c.Changes}
+}
+
+func (r *ContainerRunner) Run(ctx context.Context, c Command) (*bytes.Buffer, error) {
+	return r.Runner.Run(ctx, r.Command(c))
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestContainerRunner(t *testing.T) {
+	if _, err := NewContainerRunner(&DryRunRunner{}, "", "quay.io/btfhub/pahole:latest"); err == nil {
+		t.Error("image not pinned by digest: no error")
+	}
+
+	dry := &DryRunRunner{}
+	r, err := NewContainerRunner(dry, "", "quay.io/btfhub/pahole@sha256:0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.CPUs = "2"
+	r.Memory = "4g"
+	r.Volumes = []string{"/archive"}
+
+	_, err = r.Run(context.Background(), Command{
+		Name: "pahole",
+		Args: []string{"--btf_encode_detached", "/archive/out.btf", "/archive/vmlinux"},
+		Dir:  "/archive",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Command{{
+		Name: "podman",
+		Args: []string{
+			"run", "--rm", "--network=none", "--cpus=2", "--memory=4g",
+			"--volume=/archive:/archive", "--workdir=/archive",
+			"quay.io/btfhub/pahole@sha256:0123",
+			"pahole", "--btf_encode_detached", "/archive/out.btf", "/archive/vmlinux",
+		},
+	}}
+	if got := dry.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDryRunRunner(t *testing.T) {
+	queries := &DryRunRunner{}
+	dry := &DryRunRunner{Runner: queries}
+
+	ctx := context.Background()
+	if _, err := RunZypperCMD(ctx, dry, "search", "-s", "kernel-default-debuginfo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunZypperCMD(ctx, dry, "-q", "install", "-y", "kernel-default-debuginfo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queries are run, the other commands only recorded
+	if got := queries.Commands(); len(got) != 1 || got[0].Args[0] != "search" {
+		t.Errorf("run %v", got)
+	}
+	if got := dry.Commands(); len(got) != 1 || got[0].Args[1] != "install" || !got[0].Changes {
+		t.Errorf("recorded %v", got)
+	}
+}
@@ -1,8 +1,13 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 )
 
 var ErrHasBTF = errors.New("vmlinux has .BTF section")
@@ -11,3 +16,54 @@ func Exists(p string) bool {
 	_, err := os.Stat(p)
 	return err == nil
 }
+
+// CreateTemp creates a temporary file of its own (.<name>.*.tmp) next to the
+// given path, to be renamed over it once written, so concurrent writers of the
+// same path never share a temporary file
+func CreateTemp(path string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+}
+
+// WriteFileAtomic writes the file (and its directory, if missing) through a
+// temporary file (see CreateTemp), so readers see either the previous or the
+// whole new content
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+	f, err := CreateTemp(path)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0664)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// FileSHA256 returns the hex encoded sha256 checksum of the given file
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("checksum %s: %s", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -3,8 +3,6 @@ package utils
 import (
 	"bytes"
 	"context"
-	"fmt"
-	"os/exec"
 	"strings"
 	"sync"
 )
@@ -13,18 +11,25 @@ var (
 	zypperMtx sync.Mutex
 )
 
-func RunZypperCMD(ctx context.Context, args ...string) (*bytes.Buffer, error) {
+// zypperQueries are the zypper commands that don't change the host
+var zypperQueries = map[string]bool{
+	"repos":  true,
+	"search": true,
+}
+
+// RunZypperCMD runs zypper as root (one command at a time, zypper locks its
+// database)
+func RunZypperCMD(ctx context.Context, runner CommandRunner, args ...string) (*bytes.Buffer, error) {
 	zypperMtx.Lock()
 	defer zypperMtx.Unlock()
 
-	stdout := &bytes.Buffer{}
-	stderr := &bytes.Buffer{}
-	binary, args := SudoCMD("zypper", args...)
-	cmd := exec.CommandContext(ctx, binary, args...)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("zypper cmd %s %s: %s\n%s", binary, strings.Join(args, " "), err, stderr.String())
+	changes := true
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			changes = !zypperQueries[arg] // the first argument not an option
+			break
+		}
 	}
-	return stdout, nil
+
+	return runner.Run(ctx, Command{Name: "zypper", Args: args, Root: true, Changes: changes})
 }
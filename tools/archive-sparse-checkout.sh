@@ -110,10 +110,11 @@ ARCHS=${ARCH:-"*"}
 EXCLUDE_CUSTOM=${EXCLUDE_PATTERNS:-""}
 
 ARCHS="${ARCHS//aarch64/arm64}"
+ARCHS="${ARCHS//ppc64el/ppc64le}"
 
 for arch in $ARCHS; do
     case $arch in
-        x86_64|arm64|'*')
+        x86_64|arm64|ppc64le|s390x|'*')
             ;;
         *)
             die "invalid ARCH: $arch"
@@ -198,30 +199,42 @@ if [ "$APPLY_ONLY" = false ]; then
         TEE_FLAG="-a"  # After first iteration, always append
     }
 
+    # Helper function to add the patterns of the BTF files of a directory
+    add_btf_patterns() {
+        add_pattern "$1/*.btf.tar.xz"
+        add_pattern "$1/*.btf.zst"
+    }
+
     # Generate patterns based on DISTRO, ARCH, and VERSION
     # Skip pattern generation in exclude mode if all values are wildcards (default state)
     if [ "$EXCLUDE_MODE" = true ] && [ "${DISTROS_ARRAY[0]}" = "*" ] && [ "${ARCHS_ARRAY[0]}" = "*" ] && [ "$VERSION" = "*" ]; then
         echo "Skipping wildcard pattern generation in exclude mode (use EXCLUDE_PATTERNS for custom patterns)"
     else
         if [ "$NUM_DISTROS" -eq 1 ] && [ "$NUM_ARCHS" -eq 1 ]; then
-            add_pattern "${DISTROS_ARRAY[0]}/$VERSION/${ARCHS_ARRAY[0]}/*.btf.tar.xz"
+            add_btf_patterns "${DISTROS_ARRAY[0]}/$VERSION/${ARCHS_ARRAY[0]}"
         elif [ "$NUM_DISTROS" -gt 1 ] && [ "$NUM_ARCHS" -eq 1 ]; then
             for distro in "${DISTROS_ARRAY[@]}"; do
-                add_pattern "$distro/$VERSION/${ARCHS_ARRAY[0]}/*.btf.tar.xz"
+                add_btf_patterns "$distro/$VERSION/${ARCHS_ARRAY[0]}"
             done
         elif [ "$NUM_DISTROS" -eq 1 ] && [ "$NUM_ARCHS" -gt 1 ]; then
             for arch in "${ARCHS_ARRAY[@]}"; do
-                add_pattern "${DISTROS_ARRAY[0]}/$VERSION/$arch/*.btf.tar.xz"
+                add_btf_patterns "${DISTROS_ARRAY[0]}/$VERSION/$arch"
             done
         else
             for distro in "${DISTROS_ARRAY[@]}"; do
                 for arch in "${ARCHS_ARRAY[@]}"; do
-                    add_pattern "$distro/$VERSION/$arch/*.btf.tar.xz"
+                    add_btf_patterns "$distro/$VERSION/$arch"
                 done
             done
         fi
     fi
 
+    # The .btf.zst files (of deduplicated archives) are symbolic links to the
+    # blobs of the content addressed store, kept whatever the selection
+    if [ "$EXCLUDE_MODE" = false ]; then
+        add_pattern "/.store/"
+    fi
+
     # Add custom exclude patterns (only when in exclude mode)
     if [ "$EXCLUDE_MODE" = true ] && [ "$NUM_EXCLUDE_CUSTOM" -gt 0 ] && [ "${EXCLUDE_CUSTOM_ARRAY[0]}" != "" ]; then
         for custom_pattern in "${EXCLUDE_CUSTOM_ARRAY[@]}"; do
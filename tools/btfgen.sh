@@ -1,7 +1,7 @@
 #!/bin/bash
 
 usage() {
-    echo "Usage: $0 [-a <x86_64|arm64> -o <file01.bpf.o> -o <file02.bpf.o>] [-j <num_jobs>]" 1>&2
+    echo "Usage: $0 [-a <x86_64|arm64|ppc64le|s390x> -o <file01.bpf.o> -o <file02.bpf.o>] [-j <num_jobs>]" 1>&2
     exit 1
 }
 
@@ -27,7 +27,7 @@ while getopts ":a:o:j:" opt; do
     case "${opt}" in
         a)
             a="${OPTARG}"
-            [[ "${a}" != "x86_64" && "${a}" != "arm64" ]] && usage
+            [[ "${a}" != "x86_64" && "${a}" != "arm64" && "${a}" != "ppc64le" && "${a}" != "s390x" ]] && usage
             ;;
         o)
             [[ ! -f "${OPTARG}" ]] && {